@@ -0,0 +1,93 @@
+package archiver
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/turbolytics/librarian/internal/checkpointer"
+	"github.com/turbolytics/librarian/pkg/replicator"
+	"go.uber.org/zap"
+)
+
+func TestSpecArgs(t *testing.T) {
+	args, err := specArgs(replicator.Spec{
+		ID:      "orders",
+		Source:  "postgres://localhost:5432/shop",
+		Targets: []string{"kafka://localhost:9092/a", "kafka://localhost:9092/b"},
+		Options: map[string]interface{}{
+			"filter":                       []interface{}{"op != \"d\"", "after.id > 1"},
+			"source-checkpoint-batch-size": float64(100),
+		},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"--id=orders",
+		"--source=postgres://localhost:5432/shop",
+		"--target=kafka://localhost:9092/a",
+		"--target=kafka://localhost:9092/b",
+	}, args[:4])
+	assert.ElementsMatch(t, []string{
+		"--filter=op != \"d\"",
+		"--filter=after.id > 1",
+		"--source-checkpoint-batch-size=100",
+	}, args[4:])
+
+	_, err = specArgs(replicator.Spec{ID: "orders", Options: map[string]interface{}{"source": "x"}})
+	assert.Error(t, err)
+	_, err = specArgs(replicator.Spec{ID: "orders", Options: map[string]interface{}{"filter": map[string]interface{}{}}})
+	assert.Error(t, err)
+
+	// stores, files and listeners are shared by the process
+	for _, name := range []string{"checkpoint", "lease", "dlq", "tls-cert", "spec-store"} {
+		_, err = specArgs(replicator.Spec{ID: "orders", Options: map[string]interface{}{name: "x"}})
+		assert.ErrorContains(t, err, "cannot be set by a spec", name)
+	}
+}
+
+func TestReplicatorFactoryInvalidOptions(t *testing.T) {
+	factory := newReplicatorFactory(newReplicatorFlags(), zap.NewNop(), checkpointer.NewCache(zap.NewNop()))
+
+	_, err := factory(context.Background(), replicator.Spec{
+		ID:      "orders",
+		Source:  "postgres://localhost:5432/shop",
+		Targets: []string{"kafka://localhost:9092/orders"},
+		Options: map[string]interface{}{"no-such-flag": true},
+	})
+	assert.ErrorContains(t, err, "no-such-flag")
+
+	_, err = factory(context.Background(), replicator.Spec{
+		ID:      "orders",
+		Source:  "oracle://localhost/shop",
+		Targets: []string{"kafka://localhost:9092/orders"},
+	})
+	assert.ErrorContains(t, err, "unsupported source protocol")
+}
+
+func TestLoadCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.yml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+credentials:
+  - name: grafana
+    role: read
+    token: read-token
+  - name: oncall
+    role: operator
+    token: operator-token
+`), 0600))
+
+	credentials, err := loadCredentials(path)
+	require.NoError(t, err)
+	assert.Equal(t, []replicator.Credential{
+		{Name: "grafana", Role: replicator.RoleReader, Token: "read-token"},
+		{Name: "oncall", Role: replicator.RoleOperator, Token: "operator-token"},
+	}, credentials)
+
+	require.NoError(t, os.WriteFile(path, []byte("credentials:\n  - name: admin\n    role: admin\n    token: x\n"), 0600))
+	_, err = loadCredentials(path)
+	assert.ErrorContains(t, err, "unknown role")
+}
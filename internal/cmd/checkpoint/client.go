@@ -0,0 +1,545 @@
+package checkpoint
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/turbolytics/librarian/pkg/mongo"
+	"github.com/turbolytics/librarian/pkg/postgres"
+	"github.com/turbolytics/librarian/pkg/replicator"
+	"go.uber.org/zap"
+)
+
+// codecs parse positions before they are saved, by source format.
+var codecs = map[string]replicator.PositionCodec{
+	"postgres": postgres.Positions,
+	"mongodb":  mongo.Positions,
+	"raw":      replicator.RawPositions,
+}
+
+// errNotStopped is returned when a checkpoint would be changed directly
+// while no server confirms the replicator is stopped.
+var errNotStopped = errors.New("no server confirms the replicator is stopped")
+
+// client reads and changes checkpoints. A replicator served by the librarian
+// API at server has its checkpoint changed through the API while it is
+// paused, so it resumes from the new position, and directly through the
+// checkpointer once it is stopped. Changing the checkpoint of a running
+// replicator is refused: it would overwrite the change with its next
+// checkpoint.
+//
+// A checkpoint is only changed directly when the server confirms the
+// replicator is stopped, when the lease of the replicator is acquired from
+// leaser, which is then held for the duration of the change, or when force
+// is set. The checkpoint is saved under the epoch of the lease.
+//
+// The checkpointer is only opened once a checkpoint is read or changed
+// directly, since some, like bbolt, cannot be opened while the server holds
+// them.
+type client struct {
+	open         func(context.Context) (replicator.Checkpointer, error)
+	checkpointer replicator.Checkpointer
+	leaser       replicator.Leaser
+	holder       string
+	force        bool
+	server       string
+	token        string
+	format       string
+	http         *http.Client
+	logger       *zap.Logger
+}
+
+func newClient(open func(context.Context) (replicator.Checkpointer, error), server, format string, logger *zap.Logger) (*client, error) {
+	if _, ok := codecs[format]; format != "" && !ok {
+		return nil, fmt.Errorf("unsupported position format %q: expected postgres, mongodb or raw", format)
+	}
+	return &client{
+		open:   open,
+		server: strings.TrimSuffix(server, "/"),
+		format: format,
+		http:   &http.Client{Timeout: 30 * time.Second},
+		logger: logger,
+	}, nil
+}
+
+// store returns the checkpointer, opening it on first use.
+func (c *client) store(ctx context.Context) (replicator.Checkpointer, error) {
+	if c.checkpointer == nil {
+		checkpointer, err := c.open(ctx)
+		if err != nil {
+			return nil, err
+		}
+		c.checkpointer = checkpointer
+	}
+	return c.checkpointer, nil
+}
+
+// Close closes the checkpointer and the leaser, if they hold connections.
+func (c *client) Close() error {
+	var errs []error
+	for _, v := range []interface{}{c.checkpointer, c.leaser} {
+		if closer, ok := v.(io.Closer); ok {
+			errs = append(errs, closer.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// route reports whether the checkpoint of a replicator is changed through
+// the API, and otherwise whether the server confirmed it is stopped.
+func (c *client) route(ctx context.Context, id string) (api, stopped bool, err error) {
+	state, found, err := c.state(ctx, id)
+	if err != nil {
+		return false, false, err
+	}
+	if !found {
+		return false, false, c.checkDirect(id, false)
+	}
+
+	switch state {
+	case replicator.StatePaused:
+		return true, false, nil
+	case replicator.StateCreated, replicator.StateStopped, replicator.StateError:
+		return false, true, nil
+	default:
+		return false, false, fmt.Errorf("%w: %s is %s, pause it first", replicator.ErrReplicatorRunning, id, state)
+	}
+}
+
+// checkDirect refuses to change the checkpoint of a replicator directly
+// unless it is known to be stopped, its lease will be acquired, or force is
+// set.
+func (c *client) checkDirect(id string, stopped bool) error {
+	if stopped || c.leaser != nil || c.force {
+		return nil
+	}
+	return fmt.Errorf("%w: %s, pass --lease to acquire its lease or --force", errNotStopped, id)
+}
+
+// direct changes the checkpoint of a replicator through the checkpointer.
+// change is called with the epoch of the lease, held until it returns, or 0
+// without a leaser.
+func (c *client) direct(ctx context.Context, id string, stopped bool, change func(ctx context.Context, store replicator.Checkpointer, epoch uint64) error) error {
+	if err := c.checkDirect(id, stopped); err != nil {
+		return err
+	}
+	store, err := c.store(ctx)
+	if err != nil {
+		return err
+	}
+	if c.leaser == nil {
+		if !stopped {
+			c.logger.Warn("Changing the checkpoint without confirming the replicator is stopped",
+				zap.String("replicator_id", id))
+		}
+		return change(ctx, store, 0)
+	}
+
+	lease, err := c.leaser.Acquire(ctx, id, c.holder)
+	if errors.Is(err, replicator.ErrLeaseHeld) {
+		return fmt.Errorf("%w: %w", replicator.ErrReplicatorRunning, err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to acquire lease: %w", err)
+	}
+	defer func() {
+		if err := lease.Release(context.Background()); err != nil {
+			c.logger.Warn("Failed to release lease", zap.String("replicator_id", id), zap.Error(err))
+		}
+	}()
+	return change(ctx, store, lease.Epoch())
+}
+
+// state returns the state of a replicator served by the API. found is false
+// when no server is configured, it is not running, or it does not serve the
+// replicator.
+func (c *client) state(ctx context.Context, id string) (state replicator.State, found bool, err error) {
+	if c.server == "" {
+		return "", false, nil
+	}
+
+	resp, err := c.do(ctx, http.MethodGet, id, "", nil)
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		c.logger.Info("librarian server is not running", zap.String("server", c.server))
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return "", false, nil
+	}
+	if err := checkResponse(resp, http.StatusOK); err != nil {
+		return "", false, err
+	}
+
+	var info replicator.ReplicatorInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return "", false, err
+	}
+	return info.State, true, nil
+}
+
+// Get returns the checkpoint of a replicator, or nil if it has none.
+func (c *client) Get(ctx context.Context, id string) (*replicator.CheckpointInfo, error) {
+	_, found, err := c.state(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		store, err := c.store(ctx)
+		if err != nil {
+			return nil, err
+		}
+		checkpoint, err := store.Load(ctx, id)
+		if err != nil || checkpoint == nil {
+			return nil, err
+		}
+		info := replicator.NewCheckpointInfo(checkpoint, codecs[c.format])
+		return &info, nil
+	}
+
+	resp, err := c.do(ctx, http.MethodGet, id, "/checkpoint", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err := checkResponse(resp, http.StatusOK); err != nil {
+		return nil, err
+	}
+
+	var info replicator.CheckpointInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// Set moves the checkpoint of a replicator.
+func (c *client) Set(ctx context.Context, id string, position string) (*replicator.CheckpointInfo, error) {
+	api, stopped, err := c.route(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return c.set(ctx, replicator.CheckpointInfo{
+		ReplicatorID: id,
+		Position:     position,
+		Version:      replicator.CheckpointVersion,
+	}, api, stopped)
+}
+
+// set saves info as the checkpoint of its replicator. Only the position is
+// sent to the API, the server fills in the rest. Saved directly, info is
+// kept as is, except that a zero timestamp is replaced by the current time,
+// the source fingerprint of the stored checkpoint is kept when info has
+// none, and the epoch never lowers the fence of the stored checkpoint.
+func (c *client) set(ctx context.Context, info replicator.CheckpointInfo, api, stopped bool) (*replicator.CheckpointInfo, error) {
+	id := info.ReplicatorID
+	position, err := c.parse(info.Position, api)
+	if err != nil {
+		return nil, err
+	}
+
+	if !api {
+		var checkpoint *replicator.Checkpoint
+		err := c.direct(ctx, id, stopped, func(ctx context.Context, store replicator.Checkpointer, epoch uint64) error {
+			stored, err := store.Load(ctx, id)
+			if err != nil {
+				return err
+			}
+			checkpoint = &replicator.Checkpoint{
+				ReplicatorID: id,
+				Position:     []byte(position),
+				Timestamp:    info.Timestamp,
+				Events:       info.Events,
+				Epoch:        replicator.RestoredEpoch(stored, epoch),
+				Source:       info.Source,
+				Version:      info.Version,
+			}
+			if checkpoint.Timestamp.IsZero() {
+				checkpoint.Timestamp = time.Now()
+			}
+			if epoch == 0 {
+				checkpoint.Epoch = max(checkpoint.Epoch, info.Epoch)
+			}
+			if checkpoint.Source == nil && stored != nil {
+				checkpoint.Source = stored.Source
+			}
+			return store.Save(ctx, checkpoint)
+		})
+		if err != nil {
+			return nil, err
+		}
+		info := replicator.NewCheckpointInfo(checkpoint, codecs[c.format])
+		return &info, nil
+	}
+
+	body, err := json.Marshal(map[string]string{"position": position})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(ctx, http.MethodPut, id, "/checkpoint", body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp, http.StatusOK); err != nil {
+		return nil, err
+	}
+
+	var saved replicator.CheckpointInfo
+	if err := json.NewDecoder(resp.Body).Decode(&saved); err != nil {
+		return nil, err
+	}
+	return &saved, nil
+}
+
+// Delete deletes the checkpoint of a replicator.
+func (c *client) Delete(ctx context.Context, id string) error {
+	api, stopped, err := c.route(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !api {
+		return c.direct(ctx, id, stopped, func(ctx context.Context, store replicator.Checkpointer, _ uint64) error {
+			return store.Delete(ctx, id)
+		})
+	}
+
+	resp, err := c.do(ctx, http.MethodDelete, id, "/checkpoint", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp, http.StatusNoContent)
+}
+
+// Export returns every checkpoint saved by the checkpointer.
+func (c *client) Export(ctx context.Context) ([]replicator.CheckpointInfo, error) {
+	store, err := c.store(ctx)
+	if err != nil {
+		return nil, err
+	}
+	lister, ok := store.(replicator.CheckpointLister)
+	if !ok {
+		return nil, errors.New("checkpointer cannot list checkpoints")
+	}
+
+	checkpoints, err := lister.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return replicator.NewCheckpointInfos(checkpoints, codecs[c.format]), nil
+}
+
+// History returns the checkpoints of a replicator saved since the given time,
+// oldest first.
+func (c *client) History(ctx context.Context, id string, since time.Time) ([]replicator.CheckpointInfo, error) {
+	_, found, err := c.state(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		store, err := c.store(ctx)
+		if err != nil {
+			return nil, err
+		}
+		h, ok := store.(replicator.CheckpointHistory)
+		if !ok {
+			return nil, replicator.ErrHistoryUnsupported
+		}
+		checkpoints, err := h.History(ctx, id, since)
+		if err != nil {
+			return nil, err
+		}
+		return replicator.NewCheckpointInfos(checkpoints, codecs[c.format]), nil
+	}
+
+	path := "/checkpoint/history"
+	if !since.IsZero() {
+		path += "?since=" + url.QueryEscape(since.Format(time.RFC3339))
+	}
+	resp, err := c.do(ctx, http.MethodGet, id, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp, http.StatusOK); err != nil {
+		return nil, err
+	}
+
+	var infos []replicator.CheckpointInfo
+	if err := json.NewDecoder(resp.Body).Decode(&infos); err != nil {
+		return nil, err
+	}
+	return infos, nil
+}
+
+// Rollback restores the latest checkpoint of a replicator saved at or before
+// to.
+func (c *client) Rollback(ctx context.Context, id string, to time.Time) (*replicator.CheckpointInfo, error) {
+	api, stopped, err := c.route(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if !api {
+		var checkpoint *replicator.Checkpoint
+		err := c.direct(ctx, id, stopped, func(ctx context.Context, store replicator.Checkpointer, epoch uint64) error {
+			rb, ok := store.(replicator.CheckpointRollbacker)
+			if !ok {
+				return replicator.ErrHistoryUnsupported
+			}
+			var err error
+			checkpoint, err = rb.Rollback(ctx, id, to, epoch)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		info := replicator.NewCheckpointInfo(checkpoint, codecs[c.format])
+		return &info, nil
+	}
+
+	body, err := json.Marshal(map[string]time.Time{"timestamp": to})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(ctx, http.MethodPost, id, "/checkpoint/rollback", body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp, http.StatusOK); err != nil {
+		return nil, err
+	}
+
+	var info replicator.CheckpointInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// Import sets every checkpoint. All replicators are checked before any
+// checkpoint is changed, so a running replicator or an invalid position
+// leaves every checkpoint untouched. Checkpoints saved directly keep their
+// timestamp, event count, epoch, source fingerprint and version.
+func (c *client) Import(ctx context.Context, infos []replicator.CheckpointInfo) error {
+	type route struct{ api, stopped bool }
+	routes := make([]route, len(infos))
+	for i, info := range infos {
+		if info.ReplicatorID == "" {
+			return errors.New("checkpoint without a replicator_id")
+		}
+		api, stopped, err := c.route(ctx, info.ReplicatorID)
+		if err != nil {
+			return err
+		}
+		if _, err := c.parse(info.Position, api); err != nil {
+			return fmt.Errorf("%s: %w", info.ReplicatorID, err)
+		}
+		routes[i] = route{api, stopped}
+	}
+
+	for i, info := range infos {
+		if _, err := c.set(ctx, info, routes[i].api, routes[i].stopped); err != nil {
+			return fmt.Errorf("%s: %w", info.ReplicatorID, err)
+		}
+	}
+	return nil
+}
+
+// parse checks a position against the configured format and returns it in
+// its stored form, so a rendered position such as the _data of a MongoDB
+// resume token is saved encoded. Positions sent to the API are also parsed
+// by the source of the replicator, so the format is only required when
+// saving directly.
+func (c *client) parse(position string, api bool) (string, error) {
+	if position == "" {
+		return "", fmt.Errorf("%w: position is empty", replicator.ErrInvalidPosition)
+	}
+	if c.format == "" {
+		if api {
+			return position, nil
+		}
+		return "", errors.New("--format is required to save a checkpoint directly: postgres, mongodb or raw")
+	}
+	parsed, err := codecs[c.format].Parse(position)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", replicator.ErrInvalidPosition, err)
+	}
+	return string(parsed.Bytes()), nil
+}
+
+func (c *client) do(ctx context.Context, method, id, path string, body []byte) (*http.Response, error) {
+	endpoint := c.server + "/api/v1/replicators/" + url.PathEscape(id) + path
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return c.http.Do(req)
+}
+
+// newTLSTransport trusts the server certificates signed by caFile, the system
+// roots when it is empty, and presents the client certificate, if any, for
+// mutual TLS.
+func newTLSTransport(caFile, certFile, keyFile string) (*http.Transport, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ca: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return transport, nil
+}
+
+func checkResponse(resp *http.Response, status int) error {
+	if resp.StatusCode == status {
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return fmt.Errorf("%s %s: %s: %s", resp.Request.Method, resp.Request.URL, resp.Status, strings.TrimSpace(string(msg)))
+}
@@ -0,0 +1,264 @@
+package checkpoint
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/turbolytics/librarian/pkg/boltdb"
+	"github.com/turbolytics/librarian/pkg/replicator"
+	"go.uber.org/zap"
+)
+
+// fakeServer serves the replicator states of the librarian API and records
+// the positions set through it.
+func fakeServer(t *testing.T, states map[string]replicator.State) (*httptest.Server, map[string]string) {
+	set := make(map[string]string)
+	r := chi.NewRouter()
+	r.Get("/api/v1/replicators/{id}", func(w http.ResponseWriter, r *http.Request) {
+		state, ok := states[chi.URLParam(r, "id")]
+		if !ok {
+			http.Error(w, "replicator not found", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(replicator.ReplicatorInfo{ID: chi.URLParam(r, "id"), State: state})
+	})
+	r.Put("/api/v1/replicators/{id}/checkpoint", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Position string `json:"position"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		set[chi.URLParam(r, "id")] = body.Position
+		json.NewEncoder(w).Encode(replicator.CheckpointInfo{ReplicatorID: chi.URLParam(r, "id"), Position: body.Position})
+	})
+
+	r.Post("/api/v1/replicators/{id}/checkpoint/rollback", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Timestamp time.Time `json:"timestamp"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		set[chi.URLParam(r, "id")] = "rollback " + body.Timestamp.Format(time.RFC3339)
+		json.NewEncoder(w).Encode(replicator.CheckpointInfo{ReplicatorID: chi.URLParam(r, "id"), Timestamp: body.Timestamp})
+	})
+
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	return srv, set
+}
+
+// fakeLeaser grants the leases of replicators not listed in held, counting
+// epochs from 1.
+type fakeLeaser struct {
+	held  map[string]bool
+	epoch uint64
+}
+
+func (l *fakeLeaser) Acquire(ctx context.Context, replicatorID, holder string) (replicator.Lease, error) {
+	if l.held[replicatorID] {
+		return nil, replicator.ErrLeaseHeld
+	}
+	l.epoch++
+	return fakeLease(l.epoch), nil
+}
+
+type fakeLease uint64
+
+func (l fakeLease) Epoch() uint64                     { return uint64(l) }
+func (l fakeLease) Lost() <-chan struct{}             { return nil }
+func (l fakeLease) Release(ctx context.Context) error { return nil }
+
+// opened returns an opener of an already open checkpointer.
+func opened(checkpointer replicator.Checkpointer) func(context.Context) (replicator.Checkpointer, error) {
+	return func(context.Context) (replicator.Checkpointer, error) {
+		return checkpointer, nil
+	}
+}
+
+func TestClientSet(t *testing.T) {
+	ctx := context.Background()
+	srv, set := fakeServer(t, map[string]replicator.State{
+		"paused":    replicator.StatePaused,
+		"streaming": replicator.StateStreaming,
+		"stopped":   replicator.StateStopped,
+	})
+	checkpointer := replicator.NewFilesystemCheckpointer(t.TempDir(), zap.NewNop())
+	c, err := newClient(opened(checkpointer), srv.URL, "postgres", zap.NewNop())
+	require.NoError(t, err)
+
+	_, err = c.Set(ctx, "paused", "0/16B3748")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"paused": "0/16B3748"}, set)
+
+	_, err = c.Set(ctx, "streaming", "0/16B3748")
+	assert.ErrorIs(t, err, replicator.ErrReplicatorRunning)
+
+	_, err = c.Set(ctx, "stopped", "not an lsn")
+	assert.ErrorIs(t, err, replicator.ErrInvalidPosition)
+
+	// the stored source fingerprint is kept
+	fingerprint := &replicator.SourceFingerprint{Connector: "postgresql", System: "7301525123456789012"}
+	require.NoError(t, checkpointer.Save(ctx, &replicator.Checkpoint{
+		ReplicatorID: "stopped",
+		Position:     []byte("0/1"),
+		Source:       fingerprint,
+	}))
+	_, err = c.Set(ctx, "stopped", "0/16B3750")
+	require.NoError(t, err)
+	checkpoint, err := checkpointer.Load(ctx, "stopped")
+	require.NoError(t, err)
+	assert.Equal(t, "0/16B3750", string(checkpoint.Position))
+	assert.Equal(t, fingerprint, checkpoint.Source)
+	assert.Equal(t, replicator.CheckpointVersion, checkpoint.Version)
+
+	// a replicator the server does not confirm is stopped is only changed
+	// with --force
+	_, err = c.Set(ctx, "unknown", "0/16B3750")
+	assert.ErrorIs(t, err, errNotStopped)
+	c.force = true
+	_, err = c.Set(ctx, "unknown", "0/16B3750")
+	require.NoError(t, err)
+	checkpoint, err = checkpointer.Load(ctx, "unknown")
+	require.NoError(t, err)
+	assert.Equal(t, "0/16B3750", string(checkpoint.Position))
+	assert.Len(t, set, 1)
+}
+
+func TestClientLease(t *testing.T) {
+	ctx := context.Background()
+	checkpointer := replicator.NewFilesystemCheckpointer(t.TempDir(), zap.NewNop())
+	c, err := newClient(opened(checkpointer), "", "postgres", zap.NewNop())
+	require.NoError(t, err)
+	c.leaser = &fakeLeaser{held: map[string]bool{"running": true}}
+
+	info, err := c.Set(ctx, "orders", "0/16B3748")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), info.Epoch)
+
+	_, err = c.Set(ctx, "running", "0/16B3748")
+	assert.ErrorIs(t, err, replicator.ErrReplicatorRunning)
+	assert.ErrorIs(t, c.Delete(ctx, "running"), replicator.ErrReplicatorRunning)
+	checkpoint, err := checkpointer.Load(ctx, "running")
+	require.NoError(t, err)
+	assert.Nil(t, checkpoint)
+}
+
+func TestClientExportImport(t *testing.T) {
+	ctx := context.Background()
+	srv, _ := fakeServer(t, map[string]replicator.State{
+		"streaming": replicator.StateStreaming,
+	})
+
+	source := replicator.NewFilesystemCheckpointer(t.TempDir(), zap.NewNop())
+	timestamp := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	fingerprint := &replicator.SourceFingerprint{Connector: "postgresql", System: "7301525123456789012"}
+	for _, id := range []string{"users", "orders"} {
+		require.NoError(t, source.Save(ctx, &replicator.Checkpoint{
+			ReplicatorID: id,
+			Position:     []byte("0/16B3748"),
+			Timestamp:    timestamp,
+			Events:       42,
+			Epoch:        3,
+			Source:       fingerprint,
+			Version:      replicator.CheckpointVersion,
+		}))
+	}
+	c, err := newClient(opened(source), "", "", zap.NewNop())
+	require.NoError(t, err)
+	infos, err := c.Export(ctx)
+	require.NoError(t, err)
+	require.Len(t, infos, 2)
+	assert.Equal(t, "orders", infos[0].ReplicatorID)
+
+	target := replicator.NewFilesystemCheckpointer(t.TempDir(), zap.NewNop())
+	c, err = newClient(opened(target), srv.URL, "postgres", zap.NewNop())
+	require.NoError(t, err)
+	assert.ErrorIs(t, c.Import(ctx, infos), errNotStopped)
+	c.force = true
+	require.NoError(t, c.Import(ctx, infos))
+
+	checkpoint, err := target.Load(ctx, "users")
+	require.NoError(t, err)
+	assert.Equal(t, "0/16B3748", string(checkpoint.Position))
+	assert.True(t, timestamp.Equal(checkpoint.Timestamp))
+	assert.Equal(t, 42, checkpoint.Events)
+	assert.Equal(t, uint64(3), checkpoint.Epoch)
+	assert.Equal(t, fingerprint, checkpoint.Source)
+	assert.Equal(t, replicator.CheckpointVersion, checkpoint.Version)
+
+	// nothing is imported when one of the replicators is running
+	running := append(infos, replicator.CheckpointInfo{ReplicatorID: "streaming", Position: "0/1"})
+	require.NoError(t, target.Delete(ctx, "users"))
+	assert.ErrorIs(t, c.Import(ctx, running), replicator.ErrReplicatorRunning)
+	checkpoint, err = target.Load(ctx, "users")
+	require.NoError(t, err)
+	assert.Nil(t, checkpoint)
+}
+
+func TestClientRollback(t *testing.T) {
+	ctx := context.Background()
+	srv, set := fakeServer(t, map[string]replicator.State{
+		"paused":    replicator.StatePaused,
+		"streaming": replicator.StateStreaming,
+	})
+	u, err := url.Parse("bolt://" + filepath.Join(t.TempDir(), "checkpoints.db"))
+	require.NoError(t, err)
+	checkpointer, err := boltdb.NewCheckpointer(u, zap.NewNop())
+	require.NoError(t, err)
+	c, err := newClient(opened(checkpointer), srv.URL, "", zap.NewNop())
+	require.NoError(t, err)
+	defer c.Close()
+
+	start := time.Date(2025, 11, 19, 8, 0, 0, 0, time.UTC)
+	for i, position := range []string{"0/1", "0/2"} {
+		require.NoError(t, checkpointer.Save(ctx, &replicator.Checkpoint{
+			ReplicatorID: "stopped",
+			Position:     []byte(position),
+			Timestamp:    start.Add(time.Duration(i) * time.Hour),
+		}))
+	}
+
+	infos, err := c.History(ctx, "stopped", start.Add(time.Minute))
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	assert.Equal(t, "0/2", infos[0].Position)
+
+	_, err = c.Rollback(ctx, "stopped", start.Add(time.Minute))
+	assert.ErrorIs(t, err, errNotStopped)
+
+	// the checkpoint is restored under the lease
+	c.leaser = &fakeLeaser{}
+	info, err := c.Rollback(ctx, "stopped", start.Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, "0/1", info.Position)
+	assert.Equal(t, uint64(1), info.Epoch)
+
+	_, err = c.Rollback(ctx, "streaming", start)
+	assert.ErrorIs(t, err, replicator.ErrReplicatorRunning)
+
+	_, err = c.Rollback(ctx, "paused", start)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"paused": "rollback 2025-11-19T08:00:00Z"}, set)
+}
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2025, 11, 19, 8, 0, 0, 0, time.UTC)
+
+	ts, err := parseTime("2025-11-19T07:00:00Z", now)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(-time.Hour), ts)
+
+	ts, err = parseTime("90m", now)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(-90*time.Minute), ts)
+
+	_, err = parseTime("yesterday", now)
+	assert.Error(t, err)
+}
@@ -0,0 +1,321 @@
+package checkpoint
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/turbolytics/librarian/internal/checkpointer"
+	"github.com/turbolytics/librarian/pkg/replicator"
+	"go.uber.org/zap"
+)
+
+func NewCommand() *cobra.Command {
+	var checkpointURL, server, format, leaseURL string
+	var token, caFile, certFile, keyFile string
+	var force bool
+
+	// newCmdClient is shared by the subcommands, which all take the flags of
+	// the checkpoint command
+	newCmdClient := func(ctx context.Context) (*client, error) {
+		logger, err := zap.NewDevelopment()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create logger: %w", err)
+		}
+		l := logger.Named("librarian.checkpoint")
+		open := func(ctx context.Context) (replicator.Checkpointer, error) {
+			return checkpointer.Open(ctx, checkpointURL, l)
+		}
+		c, err := newClient(open, server, format, l)
+		if err != nil {
+			return nil, err
+		}
+
+		c.force = force
+		if leaseURL != "" {
+			if c.leaser, err = checkpointer.OpenLeaser(ctx, leaseURL, l); err != nil {
+				return nil, err
+			}
+			hostname, err := os.Hostname()
+			if err != nil {
+				hostname = "localhost"
+			}
+			c.holder = fmt.Sprintf("librarian checkpoint on %s:%d", hostname, os.Getpid())
+		}
+
+		c.token = token
+		if c.token == "" {
+			c.token = os.Getenv("LIBRARIAN_API_TOKEN")
+		}
+		if caFile != "" || certFile != "" || keyFile != "" {
+			transport, err := newTLSTransport(caFile, certFile, keyFile)
+			if err != nil {
+				c.Close()
+				return nil, err
+			}
+			c.http.Transport = transport
+		}
+		return c, nil
+	}
+
+	var cmd = &cobra.Command{
+		Use:   "checkpoint",
+		Short: "Inspects and moves replicator checkpoints",
+		Long: `Inspects and moves replicator checkpoints.
+
+A replicator must be paused or stopped to change its checkpoint. The checkpoint
+of a replicator paused in the librarian server is changed through its API and
+the replicator resumes from it; stopped replicators have their checkpoint
+changed directly.
+
+A checkpoint is only changed directly when the server confirms the replicator
+is stopped, when its lease is acquired from --lease, the URL given to
+archiver replicate, or with --force.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cmd.Help()
+		},
+	}
+
+	cmd.PersistentFlags().StringVar(&checkpointURL, "checkpoint", checkpointer.DefaultURL, "Checkpoint store URL, as given to archiver replicate")
+	cmd.PersistentFlags().StringVar(&server, "server", "http://localhost:8080", "URL of the librarian server running the replicators, empty to only use the checkpointer")
+	cmd.PersistentFlags().StringVar(&format, "format", "", "Position format to parse against: postgres (LSN), mongodb (base64 resume token or its _data) or raw")
+	cmd.PersistentFlags().StringVar(&leaseURL, "lease", "", "Lease URL, as given to archiver replicate; checkpoints changed directly are changed while holding the lease of the replicator")
+	cmd.PersistentFlags().BoolVar(&force, "force", false, "Change checkpoints directly even though no server confirms the replicator is stopped")
+	cmd.PersistentFlags().StringVar(&token, "token", "", "API token, LIBRARIAN_API_TOKEN by default; changing checkpoints requires the operator role")
+	cmd.PersistentFlags().StringVar(&caFile, "tls-ca", "", "CA file the server certificate is signed by")
+	cmd.PersistentFlags().StringVar(&certFile, "tls-cert", "", "Client certificate file, for servers requiring mutual TLS")
+	cmd.PersistentFlags().StringVar(&keyFile, "tls-key", "", "Private key file of --tls-cert")
+
+	cmd.AddCommand(newGetCommand(newCmdClient))
+	cmd.AddCommand(newSetCommand(newCmdClient))
+	cmd.AddCommand(newDeleteCommand(newCmdClient))
+	cmd.AddCommand(newExportCommand(newCmdClient))
+	cmd.AddCommand(newImportCommand(newCmdClient))
+	cmd.AddCommand(newHistoryCommand(newCmdClient))
+	cmd.AddCommand(newRollbackCommand(newCmdClient))
+	return cmd
+}
+
+func newGetCommand(newClient func(context.Context) (*client, error)) *cobra.Command {
+	return &cobra.Command{
+		Use:   "get <replicator-id>",
+		Short: "Prints the checkpoint of a replicator",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newClient(cmd.Context())
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+			info, err := c.Get(cmd.Context(), args[0])
+			if err != nil {
+				return err
+			}
+			if info == nil {
+				return fmt.Errorf("no checkpoint found for %s", args[0])
+			}
+			return writeJSON(cmd.OutOrStdout(), info)
+		},
+	}
+}
+
+func newSetCommand(newClient func(context.Context) (*client, error)) *cobra.Command {
+	return &cobra.Command{
+		Use:   "set <replicator-id> <position>",
+		Short: "Moves the checkpoint of a paused or stopped replicator",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newClient(cmd.Context())
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+			info, err := c.Set(cmd.Context(), args[0], args[1])
+			if err != nil {
+				return err
+			}
+			return writeJSON(cmd.OutOrStdout(), info)
+		},
+	}
+}
+
+func newDeleteCommand(newClient func(context.Context) (*client, error)) *cobra.Command {
+	return &cobra.Command{
+		Use:   "delete <replicator-id>",
+		Short: "Deletes the checkpoint of a paused or stopped replicator",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newClient(cmd.Context())
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+			return c.Delete(cmd.Context(), args[0])
+		},
+	}
+}
+
+func newExportCommand(newClient func(context.Context) (*client, error)) *cobra.Command {
+	var file string
+
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Writes every checkpoint as a JSON array",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newClient(cmd.Context())
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+			infos, err := c.Export(cmd.Context())
+			if err != nil {
+				return err
+			}
+
+			if file == "" || file == "-" {
+				return writeJSON(cmd.OutOrStdout(), infos)
+			}
+			f, err := os.Create(file)
+			if err != nil {
+				return err
+			}
+			if err := writeJSON(f, infos); err != nil {
+				f.Close()
+				return err
+			}
+			return f.Close()
+		},
+	}
+
+	cmd.Flags().StringVarP(&file, "file", "f", "", "File to write to, stdout by default")
+	return cmd
+}
+
+func newImportCommand(newClient func(context.Context) (*client, error)) *cobra.Command {
+	var file string
+
+	cmd := &cobra.Command{
+		Use:   "import",
+		Short: "Sets the checkpoints of a JSON array written by export",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newClient(cmd.Context())
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+
+			r := cmd.InOrStdin()
+			if file != "" && file != "-" {
+				f, err := os.Open(file)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				r = f
+			}
+
+			var infos []replicator.CheckpointInfo
+			if err := json.NewDecoder(r).Decode(&infos); err != nil {
+				return fmt.Errorf("invalid checkpoints: %w", err)
+			}
+			if err := c.Import(cmd.Context(), infos); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "imported %d checkpoints\n", len(infos))
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&file, "file", "f", "", "File to read from, stdin by default")
+	return cmd
+}
+
+func newHistoryCommand(newClient func(context.Context) (*client, error)) *cobra.Command {
+	var since string
+
+	cmd := &cobra.Command{
+		Use:   "history <replicator-id>",
+		Short: "Prints the earlier checkpoints of a replicator, oldest first",
+		Long: `Prints the earlier checkpoints of a replicator, oldest first.
+
+Only checkpointers keeping a history support it: bolt, and postgres with
+history=true.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var from time.Time
+			if since != "" {
+				var err error
+				if from, err = parseTime(since, time.Now()); err != nil {
+					return fmt.Errorf("invalid --since: %w", err)
+				}
+			}
+
+			c, err := newClient(cmd.Context())
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+			infos, err := c.History(cmd.Context(), args[0], from)
+			if err != nil {
+				return err
+			}
+			return writeJSON(cmd.OutOrStdout(), infos)
+		},
+	}
+
+	cmd.Flags().StringVar(&since, "since", "", "Only checkpoints saved since this RFC 3339 time, or this long ago (e.g. 2h)")
+	return cmd
+}
+
+func newRollbackCommand(newClient func(context.Context) (*client, error)) *cobra.Command {
+	return &cobra.Command{
+		Use:   "rollback <replicator-id> <time>",
+		Short: "Restores the checkpoint a paused or stopped replicator had at a time",
+		Long: `Restores the latest checkpoint a paused or stopped replicator saved at or
+before a time, given in RFC 3339 or as a duration ago: "rollback orders 1h"
+restores the checkpoint from an hour ago. The replicator replays the events
+since when it is resumed or started.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			to, err := parseTime(args[1], time.Now())
+			if err != nil {
+				return err
+			}
+
+			c, err := newClient(cmd.Context())
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+			info, err := c.Rollback(cmd.Context(), args[0], to)
+			if err != nil {
+				return err
+			}
+			return writeJSON(cmd.OutOrStdout(), info)
+		},
+	}
+}
+
+// parseTime parses an RFC 3339 time, or a duration before now.
+func parseTime(v string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return time.Time{}, fmt.Errorf("%q is neither an RFC 3339 time nor a duration", v)
+	}
+	return now.Add(-d), nil
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
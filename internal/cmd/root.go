@@ -1,10 +1,14 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"github.com/turbolytics/librarian/internal/cmd/checkpoint"
 	"github.com/turbolytics/librarian/internal/cmd/fixtures"
 	"github.com/turbolytics/librarian/internal/cmd/schema"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/spf13/cobra"
 	"github.com/turbolytics/librarian/internal/cmd/archiver"
@@ -24,15 +28,22 @@ func NewRootCommand() *cobra.Command {
 	cmd.AddCommand(archiver.NewCommand())
 	cmd.AddCommand(schema.NewCommand())
 	cmd.AddCommand(fixtures.NewCommand())
+	cmd.AddCommand(checkpoint.NewCommand())
 
 	return cmd
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
+// SIGINT and SIGTERM cancel the command context so long running commands can
+// shut down gracefully.
 func Execute() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+
 	cmd := NewRootCommand()
-	if err := cmd.Execute(); err != nil {
+	err := cmd.ExecuteContext(ctx)
+	stop()
+	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
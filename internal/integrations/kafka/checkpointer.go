@@ -0,0 +1,346 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/turbolytics/librarian/pkg/replicator"
+	"go.uber.org/zap"
+)
+
+// checkpointTimeout bounds creating the checkpoint topic, and reading it to
+// its end when the context has no deadline.
+const checkpointTimeout = 30 * time.Second
+
+// Checkpointer stores checkpoints in a compacted Kafka topic, one message per
+// checkpoint keyed by replicator ID, so compaction keeps the latest
+// checkpoint of every replicator. Load reads the topic from the beginning to
+// its end. Delete produces a tombstone.
+//
+// The topic is created with cleanup.policy=compact if it does not exist.
+// Query parameters of the URL are passed through as librdkafka config, as
+// for the target. Kafka has no conditional writes, so checkpoints are fenced
+// by lease epoch on both ends: Save rejects a checkpoint saved under a lease
+// older than the newest epoch this checkpointer loaded or saved, and read
+// skips checkpoints older than the epoch before them, which drops those of a
+// holder which lost its lease without loading the newer checkpoint, until
+// compaction removes the newer one.
+type Checkpointer struct {
+	topic          string
+	consumerConfig kafka.ConfigMap
+	producer       *kafka.Producer
+	logger         *zap.Logger
+
+	mu     sync.Mutex
+	epochs map[string]uint64
+}
+
+func NewCheckpointer(ctx context.Context, uri *url.URL, logger *zap.Logger) (*Checkpointer, error) {
+	topic, brokers, config, err := parseURI(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	// a checkpoint is only saved once its events are delivered, it must
+	// not be lost or reordered either
+	if _, ok := uri.Query()["acks"]; !ok {
+		config["acks"] = "all"
+	}
+	if _, ok := uri.Query()["enable.idempotence"]; !ok {
+		config["enable.idempotence"] = true
+	}
+
+	// the producer defaults of parseURI don't apply to the consumer
+	consumerConfig := kafka.ConfigMap{
+		"bootstrap.servers":    brokers,
+		"client.id":            "librarian-checkpointer",
+		"group.id":             "librarian-checkpointer",
+		"enable.auto.commit":   false,
+		"enable.partition.eof": true,
+	}
+	for key, values := range uri.Query() {
+		if len(values) > 0 {
+			consumerConfig[key] = values[0]
+		}
+	}
+
+	producer, err := kafka.NewProducer(&config)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Checkpointer{
+		topic:          topic,
+		consumerConfig: consumerConfig,
+		producer:       producer,
+		logger:         logger,
+		epochs:         make(map[string]uint64),
+	}
+	c.createTopic(ctx)
+	return c, nil
+}
+
+// createTopic creates the compacted checkpoint topic unless it exists.
+// Failures are only logged: the checkpointer may not be allowed to create
+// topics, and producing may still create one with the broker defaults.
+func (c *Checkpointer) createTopic(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, checkpointTimeout)
+	defer cancel()
+
+	// consumers never create topics when asking for their metadata
+	consumer, err := kafka.NewConsumer(&c.consumerConfig)
+	if err != nil {
+		c.logger.Warn("Failed to create Kafka consumer", zap.Error(err))
+		return
+	}
+	partitions, err := c.partitions(ctx, consumer)
+	consumer.Close()
+	if err == nil && len(partitions) > 0 {
+		return
+	}
+
+	admin, err := kafka.NewAdminClientFromProducer(c.producer)
+	if err != nil {
+		c.logger.Warn("Failed to create Kafka admin client", zap.Error(err))
+		return
+	}
+	defer admin.Close()
+
+	results, err := admin.CreateTopics(ctx, []kafka.TopicSpecification{{
+		Topic:         c.topic,
+		NumPartitions: 1,
+		Config:        map[string]string{"cleanup.policy": "compact"},
+	}})
+	if err == nil && len(results) > 0 {
+		err = results[0].Error
+	}
+
+	var kerr kafka.Error
+	switch {
+	case err == nil || (errors.As(err, &kerr) && kerr.Code() == kafka.ErrNoError):
+		c.logger.Info("Created checkpoint topic", zap.String("topic", c.topic))
+	case errors.As(err, &kerr) && kerr.Code() == kafka.ErrTopicAlreadyExists:
+	default:
+		c.logger.Warn("Failed to create checkpoint topic, it must be compacted",
+			zap.String("topic", c.topic), zap.Error(err))
+	}
+}
+
+func (c *Checkpointer) Load(ctx context.Context, replicatorID string) (*replicator.Checkpoint, error) {
+	checkpoints, err := c.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	checkpoint, ok := checkpoints[replicatorID]
+	if !ok {
+		c.logger.Info("No checkpoint found", zap.String("replicator_id", replicatorID))
+		return nil, nil
+	}
+
+	c.logger.Info("Checkpoint loaded",
+		zap.String("replicator_id", replicatorID),
+		zap.Time("timestamp", checkpoint.Timestamp),
+	)
+	return checkpoint, nil
+}
+
+func (c *Checkpointer) List(ctx context.Context) ([]*replicator.Checkpoint, error) {
+	checkpoints, err := c.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]*replicator.Checkpoint, 0, len(checkpoints))
+	for _, checkpoint := range checkpoints {
+		list = append(list, checkpoint)
+	}
+	return list, nil
+}
+
+// read consumes every partition of the topic from the beginning to its end
+// and returns the latest checkpoint of each replicator.
+func (c *Checkpointer) read(ctx context.Context) (map[string]*replicator.Checkpoint, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, checkpointTimeout)
+		defer cancel()
+	}
+
+	consumer, err := kafka.NewConsumer(&c.consumerConfig)
+	if err != nil {
+		return nil, err
+	}
+	defer consumer.Close()
+
+	partitions, err := c.partitions(ctx, consumer)
+	if err != nil {
+		return nil, err
+	}
+	checkpoints := make(map[string]*replicator.Checkpoint)
+	if len(partitions) == 0 {
+		return checkpoints, nil
+	}
+	if err := consumer.Assign(partitions); err != nil {
+		return nil, err
+	}
+
+	remaining := len(partitions)
+	for remaining > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read checkpoint topic %s: %w", c.topic, err)
+		}
+
+		switch e := consumer.Poll(100).(type) {
+		case *kafka.Message:
+			replicatorID := string(e.Key)
+			if e.Value == nil {
+				delete(checkpoints, replicatorID)
+				continue
+			}
+			var checkpoint replicator.Checkpoint
+			if err := json.Unmarshal(e.Value, &checkpoint); err != nil {
+				return nil, fmt.Errorf("invalid checkpoint at %s: %w", e.TopicPartition, err)
+			}
+			if err := replicator.CheckEpoch(checkpoints[replicatorID], &checkpoint); err != nil {
+				c.logger.Warn("Ignoring checkpoint of a stale lease holder",
+					zap.String("replicator_id", replicatorID),
+					zap.Stringer("offset", e.TopicPartition),
+					zap.Error(err))
+				continue
+			}
+			checkpoints[replicatorID] = &checkpoint
+		case kafka.PartitionEOF:
+			remaining--
+		case kafka.Error:
+			if e.IsFatal() {
+				return nil, e
+			}
+			c.logger.Warn("Checkpoint consumer error", zap.Error(e))
+		}
+	}
+
+	for replicatorID, checkpoint := range checkpoints {
+		c.observeEpoch(replicatorID, checkpoint.Epoch)
+	}
+	return checkpoints, nil
+}
+
+// partitions lists the partitions of the topic, from their first offset. A
+// missing topic has none.
+func (c *Checkpointer) partitions(ctx context.Context, consumer *kafka.Consumer) ([]kafka.TopicPartition, error) {
+	timeout := checkpointTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+
+	metadata, err := consumer.GetMetadata(&c.topic, false, int(timeout.Milliseconds()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint topic metadata: %w", err)
+	}
+	topic, ok := metadata.Topics[c.topic]
+	if !ok || topic.Error.Code() == kafka.ErrUnknownTopicOrPart {
+		return nil, nil
+	}
+	if topic.Error.Code() != kafka.ErrNoError {
+		return nil, fmt.Errorf("failed to read checkpoint topic metadata: %w", topic.Error)
+	}
+
+	partitions := make([]kafka.TopicPartition, 0, len(topic.Partitions))
+	for _, p := range topic.Partitions {
+		partitions = append(partitions, kafka.TopicPartition{
+			Topic:     &c.topic,
+			Partition: p.ID,
+			Offset:    kafka.OffsetBeginning,
+		})
+	}
+	return partitions, nil
+}
+
+// observeEpoch records the newest epoch of a replicator's checkpoints.
+func (c *Checkpointer) observeEpoch(replicatorID string, epoch uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if epoch > c.epochs[replicatorID] {
+		c.epochs[replicatorID] = epoch
+	}
+}
+
+// Save produces the checkpoint and waits for its delivery report. A
+// checkpoint saved under a lease is rejected with replicator.ErrStaleEpoch
+// if this checkpointer loaded or saved one of a newer epoch.
+func (c *Checkpointer) Save(ctx context.Context, checkpoint *replicator.Checkpoint) error {
+	c.mu.Lock()
+	epoch := c.epochs[checkpoint.ReplicatorID]
+	c.mu.Unlock()
+	if err := replicator.CheckEpoch(&replicator.Checkpoint{Epoch: epoch}, checkpoint); err != nil {
+		return err
+	}
+
+	value, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	if err := c.produce(ctx, checkpoint.ReplicatorID, value); err != nil {
+		return err
+	}
+	c.observeEpoch(checkpoint.ReplicatorID, checkpoint.Epoch)
+
+	c.logger.Debug("Checkpoint saved",
+		zap.String("replicator_id", checkpoint.ReplicatorID),
+		zap.Time("timestamp", checkpoint.Timestamp),
+	)
+	return nil
+}
+
+// Delete produces a tombstone, so compaction eventually removes the
+// checkpoints of the replicator.
+func (c *Checkpointer) Delete(ctx context.Context, replicatorID string) error {
+	if err := c.produce(ctx, replicatorID, nil); err != nil {
+		return err
+	}
+
+	c.logger.Info("Checkpoint deleted", zap.String("replicator_id", replicatorID))
+	return nil
+}
+
+func (c *Checkpointer) produce(ctx context.Context, key string, value []byte) error {
+	deliveryChan := make(chan kafka.Event, 1)
+	err := c.producer.Produce(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{
+			Topic:     &c.topic,
+			Partition: kafka.PartitionAny,
+		},
+		Key:   []byte(key),
+		Value: value,
+	}, deliveryChan)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case e := <-deliveryChan:
+		m, ok := e.(*kafka.Message)
+		if !ok {
+			return fmt.Errorf("unexpected delivery report: %v", e)
+		}
+		return m.TopicPartition.Error
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close flushes and closes the producer.
+func (c *Checkpointer) Close() error {
+	if remaining := c.producer.Flush(5000); remaining > 0 {
+		c.logger.Warn("Checkpoints not delivered before close", zap.Int("count", remaining))
+	}
+	c.producer.Close()
+	return nil
+}
@@ -0,0 +1,87 @@
+package kafka
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/turbolytics/librarian/pkg/replicator"
+	"go.uber.org/zap"
+)
+
+func TestCheckpointer(t *testing.T) {
+	cluster, err := kafka.NewMockCluster(1)
+	require.NoError(t, err)
+	defer cluster.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	u, err := url.Parse("kafka://" + cluster.BootstrapServers() + "/librarian-checkpoints")
+	require.NoError(t, err)
+	c, err := NewCheckpointer(ctx, u, zap.NewNop())
+	require.NoError(t, err)
+	defer c.Close()
+
+	checkpoint, err := c.Load(ctx, "orders")
+	require.NoError(t, err)
+	assert.Nil(t, checkpoint)
+
+	for _, position := range []string{"0/16B3748", "0/16B3750"} {
+		require.NoError(t, c.Save(ctx, &replicator.Checkpoint{
+			ReplicatorID: "orders",
+			Position:     []byte(position),
+			Timestamp:    time.Now(),
+		}))
+	}
+	require.NoError(t, c.Save(ctx, &replicator.Checkpoint{ReplicatorID: "invoices", Position: []byte("1")}))
+
+	// a new checkpointer reads the latest checkpoints back from the topic
+	restarted, err := NewCheckpointer(ctx, u, zap.NewNop())
+	require.NoError(t, err)
+	defer restarted.Close()
+
+	checkpoint, err = restarted.Load(ctx, "orders")
+	require.NoError(t, err)
+	require.NotNil(t, checkpoint)
+	assert.Equal(t, "0/16B3750", string(checkpoint.Position))
+
+	checkpoints, err := restarted.List(ctx)
+	require.NoError(t, err)
+	assert.Len(t, checkpoints, 2)
+
+	require.NoError(t, restarted.Delete(ctx, "orders"))
+	checkpoint, err = c.Load(ctx, "orders")
+	require.NoError(t, err)
+	assert.Nil(t, checkpoint)
+
+	// a holder whose lease was taken over cannot move the checkpoint back
+	// once it loaded the checkpoint of the new holder
+	require.NoError(t, c.Save(ctx, &replicator.Checkpoint{ReplicatorID: "orders", Position: []byte("2"), Epoch: 2}))
+	err = c.Save(ctx, &replicator.Checkpoint{ReplicatorID: "orders", Position: []byte("1"), Epoch: 1})
+	assert.ErrorIs(t, err, replicator.ErrStaleEpoch)
+	_, err = restarted.Load(ctx, "orders")
+	require.NoError(t, err)
+	err = restarted.Save(ctx, &replicator.Checkpoint{ReplicatorID: "orders", Position: []byte("1"), Epoch: 1})
+	assert.ErrorIs(t, err, replicator.ErrStaleEpoch)
+
+	checkpoint, err = c.Load(ctx, "orders")
+	require.NoError(t, err)
+	require.NotNil(t, checkpoint)
+	assert.Equal(t, "2", string(checkpoint.Position))
+
+	// and one saved without loading it is skipped when reading
+	stale, err := NewCheckpointer(ctx, u, zap.NewNop())
+	require.NoError(t, err)
+	defer stale.Close()
+	require.NoError(t, stale.Save(ctx, &replicator.Checkpoint{ReplicatorID: "orders", Position: []byte("1"), Epoch: 1}))
+
+	checkpoint, err = c.Load(ctx, "orders")
+	require.NoError(t, err)
+	require.NotNil(t, checkpoint)
+	assert.Equal(t, "2", string(checkpoint.Position))
+}
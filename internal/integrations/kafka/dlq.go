@@ -0,0 +1,113 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/turbolytics/librarian/pkg/replicator"
+	"go.uber.org/zap"
+)
+
+// DeadLetterQueue produces dead letters to a Kafka topic. Each message holds
+// the JSON encoded replicator.DeadLetter, keyed by replicator ID, with the
+// failure details duplicated in headers so they can be inspected without
+// decoding the value.
+type DeadLetterQueue struct {
+	config kafka.ConfigMap
+	topic  string
+	logger *zap.Logger
+
+	mu       sync.Mutex
+	producer *kafka.Producer
+}
+
+func NewDeadLetterQueue(uri *url.URL, logger *zap.Logger) (*DeadLetterQueue, error) {
+	topic, _, config, err := parseURI(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	// a dead letter is the last copy of the event, wait for all replicas
+	if _, ok := uri.Query()["acks"]; !ok {
+		config["acks"] = "all"
+	}
+
+	return &DeadLetterQueue{
+		config: config,
+		topic:  topic,
+		logger: logger,
+	}, nil
+}
+
+// Write produces the dead letter and waits for its delivery report.
+func (d *DeadLetterQueue) Write(ctx context.Context, letter replicator.DeadLetter) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.producer == nil {
+		producer, err := kafka.NewProducer(&d.config)
+		if err != nil {
+			return err
+		}
+		d.producer = producer
+	}
+
+	value, err := json.Marshal(letter)
+	if err != nil {
+		return fmt.Errorf("failed to marshal dead letter: %w", err)
+	}
+
+	deliveryChan := make(chan kafka.Event, 1)
+	err = d.producer.Produce(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{
+			Topic:     &d.topic,
+			Partition: kafka.PartitionAny,
+		},
+		Key:   []byte(letter.ReplicatorID),
+		Value: value,
+		Headers: []kafka.Header{
+			{Key: "librarian.stage", Value: []byte(letter.Stage)},
+			{Key: "librarian.error", Value: []byte(letter.Error)},
+			{Key: "librarian.attempts", Value: []byte(strconv.Itoa(letter.Attempts))},
+			{Key: "librarian.position", Value: []byte(letter.Position)},
+		},
+	}, deliveryChan)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case e := <-deliveryChan:
+		m, ok := e.(*kafka.Message)
+		if !ok {
+			return fmt.Errorf("unexpected delivery report: %v", e)
+		}
+		if m.TopicPartition.Error != nil {
+			return m.TopicPartition.Error
+		}
+		d.logger.Debug("Dead letter delivered",
+			zap.String("topic", d.topic),
+			zap.Int32("partition", m.TopicPartition.Partition),
+			zap.Int64("offset", int64(m.TopicPartition.Offset)))
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *DeadLetterQueue) Close(ctx context.Context) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.producer != nil {
+		d.producer.Flush(5000)
+		d.producer.Close()
+		d.producer = nil
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package kafka
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/url"
 	"strings"
@@ -11,6 +12,7 @@ import (
 
 	"github.com/confluentinc/confluent-kafka-go/kafka"
 	"github.com/turbolytics/librarian/pkg/replicator"
+	"go.opentelemetry.io/otel"
 	"go.uber.org/zap"
 )
 
@@ -24,25 +26,35 @@ type Repository struct {
 	statsMu sync.RWMutex
 	stats   replicator.TargetStats
 
+	// connected is set once Connect was called, so only later calls count
+	// as retries
+	connected bool
+
 	// Batching
 	eventBuffer []replicator.Event
+
+	// ackFn is notified of delivery reports
+	ackFn replicator.AckFunc
 }
 
-func NewRepository(ctx context.Context, uri *url.URL, logger *zap.Logger) (*Repository, error) {
+// parseURI extracts the topic from the URL path, the brokers from the host and
+// builds the producer config. Query parameters are passed through as
+// librdkafka config, overriding the defaults.
+func parseURI(uri *url.URL) (topic string, brokers string, config kafka.ConfigMap, err error) {
 	// Parse topic from path
-	topic := strings.TrimPrefix(uri.Path, "/")
+	topic = strings.TrimPrefix(uri.Path, "/")
 	if topic == "" {
-		return nil, fmt.Errorf("topic must be specified in URL path")
+		return "", "", nil, fmt.Errorf("topic must be specified in URL path")
 	}
 
 	// Parse brokers from host
-	brokers := uri.Host
+	brokers = uri.Host
 	if uri.Port() != "" && !strings.Contains(brokers, ":") {
 		brokers = fmt.Sprintf("%s:%s", uri.Hostname(), uri.Port())
 	}
 
 	// Parse query parameters for Kafka config
-	config := kafka.ConfigMap{
+	config = kafka.ConfigMap{
 		"bootstrap.servers": brokers,
 		"client.id":         "librarian-replicator",
 
@@ -66,6 +78,15 @@ func NewRepository(ctx context.Context, uri *url.URL, logger *zap.Logger) (*Repo
 		}
 	}
 
+	return topic, brokers, config, nil
+}
+
+func NewRepository(ctx context.Context, uri *url.URL, logger *zap.Logger) (*Repository, error) {
+	topic, brokers, config, err := parseURI(uri)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Repository{
 		topic:  topic,
 		config: config,
@@ -84,6 +105,11 @@ func (r *Repository) Connect(ctx context.Context) error {
 	r.statsMu.Lock()
 	defer r.statsMu.Unlock()
 
+	if r.connected {
+		r.stats.ConnectionRetries++
+	}
+	r.connected = true
+
 	// Create producer config from stored values
 
 	producer, err := kafka.NewProducer(&r.config)
@@ -105,13 +131,20 @@ func (r *Repository) Connect(ctx context.Context) error {
 			case *kafka.Message:
 				if ev.TopicPartition.Error != nil {
 					r.logger.Error("Delivery failed", zap.Error(ev.TopicPartition.Error))
-					// Add your retry logic, DLQ, or alerting here
+					r.statsMu.Lock()
+					r.stats.WriteErrorCount++
+					r.stats.LastError = ev.TopicPartition.Error.Error()
+					r.statsMu.Unlock()
 				} else {
 					r.logger.Debug("Message delivered",
 						zap.String("topic", *ev.TopicPartition.Topic),
 						zap.Int32("partition", ev.TopicPartition.Partition),
 						zap.Int64("offset", int64(ev.TopicPartition.Offset)))
 				}
+
+				if seq, ok := ev.Opaque.(uint64); ok && r.ackFn != nil {
+					r.ackFn(seq, ev.TopicPartition.Error)
+				}
 			case kafka.Error:
 				r.logger.Error("Producer error", zap.Error(ev))
 			}
@@ -130,6 +163,7 @@ func (r *Repository) Disconnect(ctx context.Context) error {
 		// Flush any remaining messages
 		r.producer.Flush(5000) // 5 second timeout
 		r.producer.Close()
+		r.producer = nil
 	}
 
 	r.statsMu.Lock()
@@ -146,7 +180,8 @@ func (r *Repository) Write(ctx context.Context, event replicator.Event) error {
 		r.stats.WriteErrorCount++
 		r.stats.LastError = err.Error()
 		r.statsMu.Unlock()
-		return err
+		// the event can never be serialized, retrying the connection won't help
+		return &replicator.EventError{Err: err}
 	}
 
 	// Generate key from source metadata (similar to Debezium's default key format)
@@ -156,20 +191,39 @@ func (r *Repository) Write(ctx context.Context, event replicator.Event) error {
 		event.Payload.Source.Schema,
 		event.Payload.Source.Table)
 
+	// Transforms may route the event to another topic
+	topic := r.topic
+	if event.Topic != "" {
+		topic = event.Topic
+	}
+
 	message := &kafka.Message{
 		TopicPartition: kafka.TopicPartition{
-			Topic:     &r.topic,
+			Topic:     &topic,
 			Partition: kafka.PartitionAny,
 		},
-		Key:   []byte(key),
-		Value: eventData,
+		Key:    []byte(key),
+		Value:  eventData,
+		Opaque: event.Sequence,
 	}
 
+	for k, v := range event.Headers {
+		message.Headers = append(message.Headers, kafka.Header{Key: k, Value: []byte(v)})
+	}
+
+	// continue the replicator's trace in consumers
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier{headers: &message.Headers})
+
 	if err := r.producer.Produce(message, nil); err != nil {
 		r.statsMu.Lock()
 		r.stats.WriteErrorCount++
 		r.stats.LastError = err.Error()
 		r.statsMu.Unlock()
+
+		var kerr kafka.Error
+		if errors.As(err, &kerr) && kerr.Code() == kafka.ErrMsgSizeTooLarge {
+			return &replicator.EventError{Err: err}
+		}
 		return err
 	}
 
@@ -182,6 +236,13 @@ func (r *Repository) Write(ctx context.Context, event replicator.Event) error {
 	return nil
 }
 
+// OnAck registers fn to be called with the delivery report of every message.
+// Write only enqueues messages into the producer, so delivery is confirmed
+// asynchronously by the producer event loop.
+func (r *Repository) OnAck(fn replicator.AckFunc) {
+	r.ackFn = fn
+}
+
 // Flush is a noop since the kafka producer handles batching internally
 func (r *Repository) Flush(ctx context.Context) error {
 	return nil
@@ -191,6 +252,10 @@ func (r *Repository) Close(ctx context.Context) error {
 	return r.Disconnect(ctx)
 }
 
+func (r *Repository) Type() string {
+	return "kafka"
+}
+
 func (r *Repository) Stats() replicator.TargetStats {
 	r.statsMu.RLock()
 	defer r.statsMu.RUnlock()
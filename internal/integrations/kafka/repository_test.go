@@ -0,0 +1,34 @@
+package kafka
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestRepositoryConnectionRetries(t *testing.T) {
+	cluster, err := kafka.NewMockCluster(1)
+	require.NoError(t, err)
+	defer cluster.Close()
+
+	ctx := context.Background()
+	u, err := url.Parse("kafka://" + cluster.BootstrapServers() + "/orders")
+	require.NoError(t, err)
+	r, err := NewRepository(ctx, u, zap.NewNop())
+	require.NoError(t, err)
+
+	// the first connection is not a retry
+	require.NoError(t, r.Connect(ctx))
+	assert.True(t, r.Stats().ConnectionHealthy)
+	assert.Zero(t, r.Stats().ConnectionRetries)
+
+	require.NoError(t, r.Disconnect(ctx))
+	require.NoError(t, r.Connect(ctx))
+	assert.Equal(t, int64(1), r.Stats().ConnectionRetries)
+	require.NoError(t, r.Close(ctx))
+}
@@ -0,0 +1,300 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/turbolytics/librarian/pkg/replicator"
+	"go.uber.org/zap"
+)
+
+// Checkpointer stores each checkpoint as a JSON object under the prefix of
+// the bucket. Writes are conditional on the ETag of the object when it was
+// last loaded or saved, or on its absence, so a second process writing the
+// checkpoints of the same replicator makes Save fail with
+// replicator.ErrCheckpointConflict instead of silently interleaving. It
+// takes the same options as the repository.
+//
+// Checkpoints saved under a lease are fenced by epoch instead: Save reads the
+// stored checkpoint first and fails with replicator.ErrStaleEpoch if a newer
+// lease holder saved it, and overwrites one saved by an older holder, so the
+// instance which lost its lease is the one to stop.
+type Checkpointer struct {
+	client *awss3.S3
+	bucket string
+	prefix string
+	logger *zap.Logger
+
+	mu    sync.Mutex
+	etags map[string]objectVersion
+}
+
+// objectVersion is the ETag of a checkpoint object as this checkpointer last
+// saw it; an empty etag means the object did not exist.
+type objectVersion struct {
+	etag string
+}
+
+// errPreconditionFailed is returned by putObject when the object changed
+// since its ETag was read.
+var errPreconditionFailed = errors.New("precondition failed")
+
+// newClient applies the repository options to a client for a bucket which
+// must be set.
+func newClient(opts ...Option) (*awss3.S3, *Repository, error) {
+	r := &Repository{logger: zap.NewNop()}
+	for _, o := range opts {
+		o(r)
+	}
+	if r.Bucket == "" {
+		return nil, nil, errors.New("bucket is required")
+	}
+
+	sess, err := session.NewSession(r.awsConfig())
+	if err != nil {
+		return nil, nil, err
+	}
+	return awss3.New(sess), r, nil
+}
+
+func NewCheckpointer(opts ...Option) (*Checkpointer, error) {
+	client, r, err := newClient(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Checkpointer{
+		client: client,
+		bucket: r.Bucket,
+		prefix: r.Prefix,
+		logger: r.logger,
+		etags:  make(map[string]objectVersion),
+	}, nil
+}
+
+func (c *Checkpointer) key(replicatorID string) string {
+	return path.Join(c.prefix, replicatorID+".checkpoint")
+}
+
+func (c *Checkpointer) Load(ctx context.Context, replicatorID string) (*replicator.Checkpoint, error) {
+	checkpoint, etag, err := c.get(ctx, c.key(replicatorID))
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.etags[replicatorID] = objectVersion{etag: etag}
+	c.mu.Unlock()
+
+	if checkpoint == nil {
+		c.logger.Info("No checkpoint found", zap.String("replicator_id", replicatorID))
+		return nil, nil
+	}
+
+	c.logger.Info("Checkpoint loaded",
+		zap.String("replicator_id", replicatorID),
+		zap.Time("timestamp", checkpoint.Timestamp),
+	)
+	return checkpoint, nil
+}
+
+// get reads a checkpoint object and its ETag. A missing object is not an
+// error: the checkpoint is nil.
+func (c *Checkpointer) get(ctx context.Context, key string) (*replicator.Checkpoint, string, error) {
+	out, err := c.client.GetObjectWithContext(ctx, &awss3.GetObjectInput{
+		Bucket: aws.String(c.bucket),
+		Key:    aws.String(key),
+	})
+	if isStatus(err, http.StatusNotFound) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	defer out.Body.Close()
+
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	var checkpoint replicator.Checkpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return nil, "", fmt.Errorf("invalid checkpoint %s: %w", key, err)
+	}
+	return &checkpoint, aws.StringValue(out.ETag), nil
+}
+
+// Save writes the checkpoint if its object is unchanged since this
+// checkpointer last loaded or saved it. A checkpoint saved without being
+// loaded first is conditional on the object as it is now.
+func (c *Checkpointer) Save(ctx context.Context, checkpoint *replicator.Checkpoint) error {
+	key := c.key(checkpoint.ReplicatorID)
+
+	c.mu.Lock()
+	version, ok := c.etags[checkpoint.ReplicatorID]
+	c.mu.Unlock()
+	if checkpoint.Epoch != 0 {
+		etag, older, err := c.fence(ctx, key, checkpoint)
+		if err != nil {
+			return err
+		}
+		if !ok || older {
+			version.etag = etag
+		}
+	} else if !ok {
+		out, err := c.client.HeadObjectWithContext(ctx, &awss3.HeadObjectInput{
+			Bucket: aws.String(c.bucket),
+			Key:    aws.String(key),
+		})
+		switch {
+		case isStatus(err, http.StatusNotFound):
+		case err != nil:
+			return err
+		default:
+			version.etag = aws.StringValue(out.ETag)
+		}
+	}
+
+	data, err := json.MarshalIndent(checkpoint, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	etag, err := putObject(ctx, c.client, c.bucket, key, data, version.etag)
+	if errors.Is(err, errPreconditionFailed) && checkpoint.Epoch != 0 {
+		// changed since it was read, by a newer holder which fences this
+		// one, or by an older one which is overwritten
+		current, older, ferr := c.fence(ctx, key, checkpoint)
+		if ferr != nil {
+			return ferr
+		}
+		if older {
+			etag, err = putObject(ctx, c.client, c.bucket, key, data, current)
+		}
+	}
+	if errors.Is(err, errPreconditionFailed) {
+		return fmt.Errorf("%w: s3://%s/%s", replicator.ErrCheckpointConflict, c.bucket, key)
+	}
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.etags[checkpoint.ReplicatorID] = objectVersion{etag: etag}
+	c.mu.Unlock()
+
+	c.logger.Debug("Checkpoint saved",
+		zap.String("replicator_id", checkpoint.ReplicatorID),
+		zap.Time("timestamp", checkpoint.Timestamp),
+	)
+	return nil
+}
+
+// fence reads the stored checkpoint before a checkpoint is saved under a
+// lease. It returns replicator.ErrStaleEpoch if a newer lease holder saved
+// it, and otherwise its ETag, and whether an older lease holder saved it.
+func (c *Checkpointer) fence(ctx context.Context, key string, checkpoint *replicator.Checkpoint) (string, bool, error) {
+	stored, etag, err := c.get(ctx, key)
+	if err != nil {
+		return "", false, err
+	}
+	if err := replicator.CheckEpoch(stored, checkpoint); err != nil {
+		return "", false, err
+	}
+	return etag, stored != nil && stored.Epoch < checkpoint.Epoch, nil
+}
+
+func (c *Checkpointer) Delete(ctx context.Context, replicatorID string) error {
+	_, err := c.client.DeleteObjectWithContext(ctx, &awss3.DeleteObjectInput{
+		Bucket: aws.String(c.bucket),
+		Key:    aws.String(c.key(replicatorID)),
+	})
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.etags[replicatorID] = objectVersion{}
+	c.mu.Unlock()
+
+	c.logger.Info("Checkpoint deleted", zap.String("replicator_id", replicatorID))
+	return nil
+}
+
+func (c *Checkpointer) List(ctx context.Context) ([]*replicator.Checkpoint, error) {
+	var keys []string
+	prefix := c.prefix
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	err := c.client.ListObjectsV2PagesWithContext(ctx, &awss3.ListObjectsV2Input{
+		Bucket: aws.String(c.bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *awss3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			if key := aws.StringValue(obj.Key); strings.HasSuffix(key, ".checkpoint") {
+				keys = append(keys, key)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var checkpoints []*replicator.Checkpoint
+	for _, key := range keys {
+		checkpoint, _, err := c.get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		// deleted since it was listed
+		if checkpoint != nil {
+			checkpoints = append(checkpoints, checkpoint)
+		}
+	}
+	return checkpoints, nil
+}
+
+// putObject writes a JSON object if its ETag is still etag, or if it does not
+// exist when etag is empty, and returns the ETag of the new object.
+func putObject(ctx context.Context, client *awss3.S3, bucket, key string, data []byte, etag string) (string, error) {
+	req, out := client.PutObjectRequest(&awss3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	})
+	req.SetContext(ctx)
+	// the SDK predates conditional writes, set their headers directly
+	if etag != "" {
+		req.HTTPRequest.Header.Set("If-Match", etag)
+	} else {
+		req.HTTPRequest.Header.Set("If-None-Match", "*")
+	}
+
+	if err := req.Send(); err != nil {
+		if isStatus(err, http.StatusPreconditionFailed) || isStatus(err, http.StatusConflict) {
+			return "", errPreconditionFailed
+		}
+		return "", err
+	}
+	return aws.StringValue(out.ETag), nil
+}
+
+func isStatus(err error, status int) bool {
+	var reqErr awserr.RequestFailure
+	return errors.As(err, &reqErr) && reqErr.StatusCode() == status
+}
@@ -0,0 +1,262 @@
+package s3
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/turbolytics/librarian/pkg/replicator"
+)
+
+// fakeS3 is a path-style S3 stand-in for a single bucket, supporting the
+// conditional puts used by the checkpointer.
+type fakeS3 struct {
+	bucket string
+
+	mu      sync.Mutex
+	objects map[string][]byte
+
+	// onPut, if set, is called once before the next put is handled, to
+	// write an object concurrently
+	onPut func()
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key, ok := strings.CutPrefix(r.URL.Path, "/"+f.bucket)
+	if !ok {
+		f.error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	key = strings.TrimPrefix(key, "/")
+
+	if key == "" && r.Method == http.MethodGet {
+		f.list(w, r.URL.Query().Get("prefix"))
+		return
+	}
+
+	data, exists := f.objects[key]
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if !exists {
+			f.error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("ETag", etag(data))
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	case http.MethodPut:
+		if f.onPut != nil {
+			f.onPut()
+			f.onPut = nil
+			data, exists = f.objects[key]
+		}
+		if match := r.Header.Get("If-Match"); match != "" && (!exists || match != etag(data)) {
+			f.error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		if r.Header.Get("If-None-Match") == "*" && exists {
+			f.error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		f.objects[key] = body
+		w.Header().Set("ETag", etag(body))
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, prefix string) {
+	type object struct {
+		Key  string `xml:"Key"`
+		Size int    `xml:"Size"`
+	}
+	result := struct {
+		XMLName  xml.Name `xml:"ListBucketResult"`
+		Name     string   `xml:"Name"`
+		Prefix   string   `xml:"Prefix"`
+		KeyCount int      `xml:"KeyCount"`
+		Contents []object `xml:"Contents"`
+	}{Name: f.bucket, Prefix: prefix}
+	for key, data := range f.objects {
+		if strings.HasPrefix(key, prefix) {
+			result.Contents = append(result.Contents, object{Key: key, Size: len(data)})
+		}
+	}
+	sort.Slice(result.Contents, func(i, j int) bool { return result.Contents[i].Key < result.Contents[j].Key })
+	result.KeyCount = len(result.Contents)
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+func (f *fakeS3) error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+func newTestCheckpointer(t *testing.T, endpoint string) *Checkpointer {
+	t.Helper()
+	c, err := NewCheckpointer(
+		WithEndpoint(endpoint),
+		WithRegion("us-east-1"),
+		WithBucket("checkpoints"),
+		WithPrefix("librarian"),
+		WithForcePathStyle(true),
+	)
+	require.NoError(t, err)
+	return c
+}
+
+func TestCheckpointer(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+
+	fake := &fakeS3{bucket: "checkpoints", objects: make(map[string][]byte)}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	ctx := context.Background()
+	c := newTestCheckpointer(t, srv.URL)
+
+	checkpoint, err := c.Load(ctx, "orders")
+	require.NoError(t, err)
+	assert.Nil(t, checkpoint)
+
+	for _, position := range []string{"0/16B3748", "0/16B3750"} {
+		require.NoError(t, c.Save(ctx, &replicator.Checkpoint{
+			ReplicatorID: "orders",
+			Position:     []byte(position),
+			Timestamp:    time.Now(),
+		}))
+	}
+	assert.Contains(t, fake.objects, "librarian/orders.checkpoint")
+
+	checkpoint, err = c.Load(ctx, "orders")
+	require.NoError(t, err)
+	assert.Equal(t, "0/16B3750", string(checkpoint.Position))
+
+	// saving without loading first is conditional on the current object
+	other := newTestCheckpointer(t, srv.URL)
+	require.NoError(t, other.Save(ctx, &replicator.Checkpoint{
+		ReplicatorID: "orders",
+		Position:     []byte("0/16B3760"),
+		Timestamp:    time.Now(),
+	}))
+
+	err = c.Save(ctx, &replicator.Checkpoint{
+		ReplicatorID: "orders",
+		Position:     []byte("0/16B3758"),
+		Timestamp:    time.Now(),
+	})
+	assert.ErrorIs(t, err, replicator.ErrCheckpointConflict)
+
+	// a checkpoint created concurrently is a conflict too
+	_, err = c.Load(ctx, "invoices")
+	require.NoError(t, err)
+	require.NoError(t, other.Save(ctx, &replicator.Checkpoint{ReplicatorID: "invoices", Position: []byte("1")}))
+	err = c.Save(ctx, &replicator.Checkpoint{ReplicatorID: "invoices", Position: []byte("2")})
+	assert.ErrorIs(t, err, replicator.ErrCheckpointConflict)
+
+	checkpoints, err := c.List(ctx)
+	require.NoError(t, err)
+	require.Len(t, checkpoints, 2)
+	assert.Equal(t, "invoices", checkpoints[0].ReplicatorID)
+	assert.Equal(t, "0/16B3760", string(checkpoints[1].Position))
+
+	require.NoError(t, c.Delete(ctx, "orders"))
+	checkpoint, err = c.Load(ctx, "orders")
+	require.NoError(t, err)
+	assert.Nil(t, checkpoint)
+}
+
+func TestCheckpointerEpochs(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+
+	fake := &fakeS3{bucket: "checkpoints", objects: make(map[string][]byte)}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	ctx := context.Background()
+	save := func(c *Checkpointer, position string, epoch uint64) error {
+		return c.Save(ctx, &replicator.Checkpoint{ReplicatorID: "orders", Position: []byte(position), Epoch: epoch})
+	}
+	stored := func() *replicator.Checkpoint {
+		checkpoint, _, err := newTestCheckpointer(t, srv.URL).get(ctx, "librarian/orders.checkpoint")
+		require.NoError(t, err)
+		return checkpoint
+	}
+	// write stores a checkpoint as another holder would
+	write := func(position string, epoch uint64) {
+		data, err := json.Marshal(&replicator.Checkpoint{ReplicatorID: "orders", Position: []byte(position), Epoch: epoch})
+		require.NoError(t, err)
+		fake.objects["librarian/orders.checkpoint"] = data
+	}
+	holders := func() (*Checkpointer, *Checkpointer) {
+		fake.objects = make(map[string][]byte)
+		old, current := newTestCheckpointer(t, srv.URL), newTestCheckpointer(t, srv.URL)
+		require.NoError(t, save(old, "1", 1))
+		for _, c := range []*Checkpointer{old, current} {
+			_, err := c.Load(ctx, "orders")
+			require.NoError(t, err)
+		}
+		return old, current
+	}
+
+	t.Run("the new holder saves first", func(t *testing.T) {
+		old, current := holders()
+		require.NoError(t, save(current, "3", 2))
+		assert.ErrorIs(t, save(old, "2", 1), replicator.ErrStaleEpoch)
+		assert.Equal(t, "3", string(stored().Position))
+	})
+
+	t.Run("the old holder saves first", func(t *testing.T) {
+		old, current := holders()
+		require.NoError(t, save(old, "2", 1))
+		require.NoError(t, save(current, "3", 2))
+		assert.ErrorIs(t, save(old, "4", 1), replicator.ErrStaleEpoch)
+		assert.Equal(t, "3", string(stored().Position))
+	})
+
+	t.Run("a save races an older holder", func(t *testing.T) {
+		old, current := holders()
+		fake.onPut = func() { write("2", 1) }
+		require.NoError(t, save(current, "3", 2))
+		assert.Equal(t, "3", string(stored().Position))
+		assert.ErrorIs(t, save(old, "4", 1), replicator.ErrStaleEpoch)
+	})
+
+	t.Run("a save races a newer holder", func(t *testing.T) {
+		_, current := holders()
+		fake.onPut = func() { write("4", 3) }
+		assert.ErrorIs(t, save(current, "3", 2), replicator.ErrStaleEpoch)
+		assert.Equal(t, "4", string(stored().Position))
+	})
+}
@@ -0,0 +1,216 @@
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/turbolytics/librarian/pkg/replicator"
+	"go.uber.org/zap"
+)
+
+const DefaultLeaseTTL = 30 * time.Second
+
+// Leaser leases replicator IDs with a JSON object per replicator under the
+// prefix of the bucket, recording the holder, its epoch and when the lease
+// expires. Every write is conditional on the ETag the writer last saw, so of
+// two instances acquiring an expired lease only one succeeds. The holder
+// renews its lease every third of the TTL and considers it lost once it can
+// no longer renew it in time. Instances must have roughly synchronized
+// clocks. It takes the same options as the repository.
+type Leaser struct {
+	client *awss3.S3
+	bucket string
+	prefix string
+	ttl    time.Duration
+	logger *zap.Logger
+}
+
+func NewLeaser(ttl time.Duration, opts ...Option) (*Leaser, error) {
+	client, r, err := newClient(opts...)
+	if err != nil {
+		return nil, err
+	}
+	if ttl <= 0 {
+		return nil, fmt.Errorf("invalid lease ttl: %s", ttl)
+	}
+
+	return &Leaser{
+		client: client,
+		bucket: r.Bucket,
+		prefix: r.Prefix,
+		ttl:    ttl,
+		logger: r.logger,
+	}, nil
+}
+
+// leaseRecord is the content of a lease object.
+type leaseRecord struct {
+	Epoch     uint64    `json:"epoch"`
+	Holder    string    `json:"holder"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (l *Leaser) Acquire(ctx context.Context, replicatorID, holder string) (replicator.Lease, error) {
+	key := path.Join(l.prefix, replicatorID+".lease")
+	record, etag, err := l.get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if etag != "" && record.Holder != holder && now.Before(record.ExpiresAt) {
+		return nil, fmt.Errorf("%w: %s until %s", replicator.ErrLeaseHeld, record.Holder, record.ExpiresAt.Format(time.RFC3339))
+	}
+
+	record = leaseRecord{
+		Epoch:     record.Epoch + 1,
+		Holder:    holder,
+		ExpiresAt: now.Add(l.ttl),
+	}
+	etag, err = l.put(ctx, key, record, etag)
+	if errors.Is(err, errPreconditionFailed) {
+		return nil, fmt.Errorf("%w: s3://%s/%s was acquired concurrently", replicator.ErrLeaseHeld, l.bucket, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	lease := &objectLease{
+		leaser: l,
+		key:    key,
+		epoch:  record.Epoch,
+		record: record,
+		etag:   etag,
+		lost:   make(chan struct{}),
+		stop:   make(chan struct{}),
+		logger: l.logger.With(zap.String("replicator_id", replicatorID)),
+	}
+	lease.wg.Add(1)
+	go lease.renew()
+	return lease, nil
+}
+
+// get reads a lease object and its ETag. A missing object has an empty
+// record and ETag.
+func (l *Leaser) get(ctx context.Context, key string) (leaseRecord, string, error) {
+	var record leaseRecord
+	out, err := l.client.GetObjectWithContext(ctx, &awss3.GetObjectInput{
+		Bucket: aws.String(l.bucket),
+		Key:    aws.String(key),
+	})
+	if isStatus(err, http.StatusNotFound) {
+		return record, "", nil
+	}
+	if err != nil {
+		return record, "", err
+	}
+	defer out.Body.Close()
+
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		return record, "", err
+	}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return record, "", fmt.Errorf("invalid lease %s: %w", key, err)
+	}
+	return record, aws.StringValue(out.ETag), nil
+}
+
+func (l *Leaser) put(ctx context.Context, key string, record leaseRecord, etag string) (string, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+	return putObject(ctx, l.client, l.bucket, key, data, etag)
+}
+
+// objectLease is renewed by its renew goroutine, which owns the record and
+// ETag until the lease is released.
+type objectLease struct {
+	leaser *Leaser
+	key    string
+	epoch  uint64
+	record leaseRecord
+	etag   string
+	lost   chan struct{}
+	stop   chan struct{}
+	wg     sync.WaitGroup
+	logger *zap.Logger
+}
+
+func (l *objectLease) Epoch() uint64 {
+	return l.epoch
+}
+
+func (l *objectLease) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// renew extends the lease every third of the TTL. The lease is lost once
+// another instance took it over, or once the next renewal would come after
+// it expired. A renewal in flight is never cancelled, so the ETag of the
+// object stays known to Release.
+func (l *objectLease) renew() {
+	defer l.wg.Done()
+	interval := l.leaser.ttl / 3
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+
+		record := l.record
+		record.ExpiresAt = time.Now().Add(l.leaser.ttl)
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		etag, err := l.leaser.put(ctx, l.key, record, l.etag)
+		cancel()
+		switch {
+		case err == nil:
+			l.record, l.etag = record, etag
+			continue
+		case errors.Is(err, errPreconditionFailed):
+			l.logger.Error("Lease taken over by another instance")
+		case time.Until(l.record.ExpiresAt) < interval:
+			l.logger.Error("Failed to renew lease before it expires", zap.Error(err))
+		default:
+			l.logger.Warn("Failed to renew lease", zap.Error(err))
+			continue
+		}
+		close(l.lost)
+		return
+	}
+}
+
+// Release stops renewing the lease and expires it, so another instance can
+// acquire it right away.
+func (l *objectLease) Release(ctx context.Context) error {
+	close(l.stop)
+	l.wg.Wait()
+
+	select {
+	case <-l.lost:
+		return nil
+	default:
+	}
+
+	record := l.record
+	record.ExpiresAt = time.Now()
+	_, err := l.leaser.put(ctx, l.key, record, l.etag)
+	if errors.Is(err, errPreconditionFailed) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,60 @@
+package s3
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/turbolytics/librarian/pkg/replicator"
+)
+
+func TestLeaser(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+
+	fake := &fakeS3{bucket: "checkpoints", objects: make(map[string][]byte)}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	ctx := context.Background()
+	l, err := NewLeaser(300*time.Millisecond,
+		WithEndpoint(srv.URL),
+		WithRegion("us-east-1"),
+		WithBucket("checkpoints"),
+		WithPrefix("librarian"),
+		WithForcePathStyle(true),
+	)
+	require.NoError(t, err)
+
+	lease, err := l.Acquire(ctx, "orders", "host-a:1")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), lease.Epoch())
+	fake.mu.Lock()
+	assert.Contains(t, fake.objects, "librarian/orders.lease")
+	fake.mu.Unlock()
+
+	// renewals keep the lease past its TTL
+	time.Sleep(500 * time.Millisecond)
+	_, err = l.Acquire(ctx, "orders", "host-b:2")
+	assert.ErrorIs(t, err, replicator.ErrLeaseHeld)
+	assert.ErrorContains(t, err, "host-a:1")
+
+	require.NoError(t, lease.Release(ctx))
+	next, err := l.Acquire(ctx, "orders", "host-b:2")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), next.Epoch())
+
+	// overwriting the lease object takes the lease away
+	fake.mu.Lock()
+	fake.objects["librarian/orders.lease"] = []byte(`{"epoch": 3, "holder": "host-c:3"}`)
+	fake.mu.Unlock()
+	select {
+	case <-next.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("lease not lost")
+	}
+	require.NoError(t, next.Release(ctx))
+}
@@ -68,6 +68,15 @@ func New(opts ...Option) *Repository {
 		o(r)
 	}
 
+	sess, _ := session.NewSession(r.awsConfig())
+	r.uploader = s3manager.NewUploader(sess)
+
+	return r
+}
+
+// awsConfig configures the region, endpoint and addressing style of the
+// clients of a repository.
+func (r *Repository) awsConfig() *aws.Config {
 	awsConfig := &aws.Config{
 		Region:           aws.String(r.Region),
 		S3ForcePathStyle: aws.Bool(r.ForcePathStyle),
@@ -76,11 +85,7 @@ func New(opts ...Option) *Repository {
 	if r.Endpoint != "" {
 		awsConfig.Endpoint = aws.String(r.Endpoint)
 	}
-
-	sess, _ := session.NewSession(awsConfig)
-	r.uploader = s3manager.NewUploader(sess)
-
-	return r
+	return awsConfig
 }
 
 func (r *Repository) Write(ctx context.Context, key string, reader io.Reader) error {
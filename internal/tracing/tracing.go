@@ -0,0 +1,79 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// NewTracerProvider creates a tracer provider which exports spans to uri:
+//
+//	http://localhost:4318    OTLP over HTTP, e.g. to a local collector
+//	https://collector:4318   OTLP over HTTPS
+//	file://./dev/traces.json JSON encoded spans appended to a file
+//
+// sampleRatio is the fraction of traces recorded. The returned shutdown
+// function flushes the remaining spans and releases the exporter.
+func NewTracerProvider(ctx context.Context, uri *url.URL, serviceName string, sampleRatio float64) (*sdktrace.TracerProvider, func(context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	var closeFn func() error
+
+	switch uri.Scheme {
+	case "http", "https":
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpointURL(uri.String())}
+		if uri.Scheme == "http" {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		e, err := otlptracehttp.New(ctx, opts...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+		}
+		exporter = e
+
+	case "file":
+		path := uri.Host + uri.Path
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, nil, err
+		}
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, nil, err
+		}
+		e, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, nil, fmt.Errorf("failed to create file exporter: %w", err)
+		}
+		exporter = e
+		closeFn = f.Close
+
+	default:
+		return nil, nil, fmt.Errorf("unsupported tracing protocol: %s", uri.Scheme)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", serviceName),
+		)),
+	)
+
+	shutdown := func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closeFn != nil {
+			err = errors.Join(err, closeFn())
+		}
+		return err
+	}
+	return provider, shutdown, nil
+}
@@ -0,0 +1,292 @@
+package boltdb
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/turbolytics/librarian/pkg/replicator"
+	bolt "go.etcd.io/bbolt"
+	"go.uber.org/zap"
+)
+
+const DefaultMaxHistory = 1000
+
+var (
+	checkpointsBucket = []byte("checkpoints")
+	historyBucket     = []byte("history")
+)
+
+// Checkpointer stores checkpoints in a single bbolt file. Besides the latest
+// checkpoint of each replicator it keeps the last maxHistory checkpoints, so
+// a replicator can be rolled back to an earlier position. It is configured
+// through its URL:
+//
+//	bolt://./dev/checkpoints.db?max_history=1000
+//
+// bbolt locks the file, so only one process can open it at a time.
+type Checkpointer struct {
+	db         *bolt.DB
+	maxHistory int
+	logger     *zap.Logger
+}
+
+// NewCheckpointer opens or creates the database at the path of the URL.
+func NewCheckpointer(uri *url.URL, logger *zap.Logger) (*Checkpointer, error) {
+	path := uri.Host + uri.Path
+	if path == "" {
+		return nil, errors.New("checkpoint database path is required")
+	}
+
+	maxHistory := DefaultMaxHistory
+	if v := uri.Query().Get("max_history"); v != "" {
+		var err error
+		maxHistory, err = strconv.Atoi(v)
+		if err != nil || maxHistory < 0 {
+			return nil, fmt.Errorf("invalid max_history parameter: %q", v)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("checkpoint database %s is locked by another process", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{checkpointsBucket, historyBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Checkpointer{
+		db:         db,
+		maxHistory: maxHistory,
+		logger:     logger,
+	}, nil
+}
+
+func (c *Checkpointer) Load(ctx context.Context, replicatorID string) (*replicator.Checkpoint, error) {
+	var checkpoint *replicator.Checkpoint
+	err := c.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(checkpointsBucket).Get([]byte(replicatorID))
+		if data == nil {
+			return nil
+		}
+		checkpoint = &replicator.Checkpoint{}
+		return json.Unmarshal(data, checkpoint)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if checkpoint == nil {
+		c.logger.Info("No checkpoint found", zap.String("replicator_id", replicatorID))
+		return nil, nil
+	}
+
+	c.logger.Info("Checkpoint loaded",
+		zap.String("replicator_id", replicatorID),
+		zap.Time("timestamp", checkpoint.Timestamp),
+	)
+	return checkpoint, nil
+}
+
+// Save stores the checkpoint, unless a newer lease holder saved the current
+// one, and appends it to the history of the replicator, dropping its oldest
+// checkpoints beyond maxHistory.
+func (c *Checkpointer) Save(ctx context.Context, checkpoint *replicator.Checkpoint) error {
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+
+	err = c.db.Update(func(tx *bolt.Tx) error {
+		checkpoints := tx.Bucket(checkpointsBucket)
+		if stored := checkpoints.Get([]byte(checkpoint.ReplicatorID)); stored != nil {
+			var current replicator.Checkpoint
+			if err := json.Unmarshal(stored, &current); err != nil {
+				return err
+			}
+			if err := replicator.CheckEpoch(&current, checkpoint); err != nil {
+				return err
+			}
+		}
+		if err := checkpoints.Put([]byte(checkpoint.ReplicatorID), data); err != nil {
+			return err
+		}
+		if c.maxHistory == 0 {
+			return nil
+		}
+
+		history, err := tx.Bucket(historyBucket).CreateBucketIfNotExists([]byte(checkpoint.ReplicatorID))
+		if err != nil {
+			return err
+		}
+		seq, err := history.NextSequence()
+		if err != nil {
+			return err
+		}
+		if err := history.Put(itob(seq), data); err != nil {
+			return err
+		}
+
+		// keys are sequential, so everything up to seq-maxHistory is beyond
+		// the limit
+		cursor := history.Cursor()
+		for k, _ := cursor.First(); k != nil && binary.BigEndian.Uint64(k)+uint64(c.maxHistory) <= seq; k, _ = cursor.First() {
+			if err := history.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	c.logger.Debug("Checkpoint saved",
+		zap.String("replicator_id", checkpoint.ReplicatorID),
+		zap.Time("timestamp", checkpoint.Timestamp),
+	)
+	return nil
+}
+
+// Delete removes the checkpoint of a replicator. Its history is kept, so it
+// can still be rolled back.
+func (c *Checkpointer) Delete(ctx context.Context, replicatorID string) error {
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(checkpointsBucket).Delete([]byte(replicatorID))
+	})
+	if err != nil {
+		return err
+	}
+
+	c.logger.Info("Checkpoint deleted", zap.String("replicator_id", replicatorID))
+	return nil
+}
+
+func (c *Checkpointer) List(ctx context.Context) ([]*replicator.Checkpoint, error) {
+	var checkpoints []*replicator.Checkpoint
+	err := c.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(checkpointsBucket).ForEach(func(k, v []byte) error {
+			var checkpoint replicator.Checkpoint
+			if err := json.Unmarshal(v, &checkpoint); err != nil {
+				return fmt.Errorf("invalid checkpoint %s: %w", k, err)
+			}
+			checkpoints = append(checkpoints, &checkpoint)
+			return nil
+		})
+	})
+	return checkpoints, err
+}
+
+// History returns the checkpoints of a replicator saved since the given
+// time, oldest first.
+func (c *Checkpointer) History(ctx context.Context, replicatorID string, since time.Time) ([]*replicator.Checkpoint, error) {
+	var checkpoints []*replicator.Checkpoint
+	err := c.db.View(func(tx *bolt.Tx) error {
+		history := tx.Bucket(historyBucket).Bucket([]byte(replicatorID))
+		if history == nil {
+			return nil
+		}
+		return history.ForEach(func(k, v []byte) error {
+			var checkpoint replicator.Checkpoint
+			if err := json.Unmarshal(v, &checkpoint); err != nil {
+				return err
+			}
+			if !checkpoint.Timestamp.Before(since) {
+				checkpoints = append(checkpoints, &checkpoint)
+			}
+			return nil
+		})
+	})
+	return checkpoints, err
+}
+
+// Rollback makes the latest checkpoint in the history saved at or before to
+// the current checkpoint, under the epoch given by replicator.RestoredEpoch.
+// The history itself is left as is.
+func (c *Checkpointer) Rollback(ctx context.Context, replicatorID string, to time.Time, epoch uint64) (*replicator.Checkpoint, error) {
+	var checkpoint *replicator.Checkpoint
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		history := tx.Bucket(historyBucket).Bucket([]byte(replicatorID))
+		if history == nil {
+			return nil
+		}
+
+		var current *replicator.Checkpoint
+		checkpoints := tx.Bucket(checkpointsBucket)
+		if stored := checkpoints.Get([]byte(replicatorID)); stored != nil {
+			current = &replicator.Checkpoint{}
+			if err := json.Unmarshal(stored, current); err != nil {
+				return err
+			}
+		}
+
+		cursor := history.Cursor()
+		for k, v := cursor.Last(); k != nil; k, v = cursor.Prev() {
+			var candidate replicator.Checkpoint
+			if err := json.Unmarshal(v, &candidate); err != nil {
+				return err
+			}
+			if candidate.Timestamp.After(to) {
+				continue
+			}
+
+			candidate.Epoch = replicator.RestoredEpoch(current, epoch)
+			if err := replicator.CheckEpoch(current, &candidate); err != nil {
+				return err
+			}
+			data, err := json.Marshal(&candidate)
+			if err != nil {
+				return err
+			}
+			checkpoint = &candidate
+			return checkpoints.Put([]byte(replicatorID), data)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if checkpoint == nil {
+		return nil, fmt.Errorf("%w for %s at or before %s", replicator.ErrNoCheckpoint, replicatorID, to.Format(time.RFC3339))
+	}
+
+	c.logger.Info("Checkpoint rolled back",
+		zap.String("replicator_id", replicatorID),
+		zap.Time("to", to),
+		zap.Time("timestamp", checkpoint.Timestamp),
+	)
+	return checkpoint, nil
+}
+
+// Close closes the database, releasing its lock.
+func (c *Checkpointer) Close() error {
+	return c.db.Close()
+}
+
+func itob(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
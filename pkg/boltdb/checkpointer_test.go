@@ -0,0 +1,94 @@
+package boltdb
+
+import (
+	"context"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/turbolytics/librarian/pkg/replicator"
+	"go.uber.org/zap"
+)
+
+func TestCheckpointer(t *testing.T) {
+	ctx := context.Background()
+	u, err := url.Parse("bolt://" + filepath.Join(t.TempDir(), "checkpoints", "librarian.db") + "?max_history=3")
+	require.NoError(t, err)
+
+	c, err := NewCheckpointer(u, zap.NewNop())
+	require.NoError(t, err)
+
+	checkpoint, err := c.Load(ctx, "orders")
+	require.NoError(t, err)
+	assert.Nil(t, checkpoint)
+
+	start := time.Date(2025, 11, 19, 8, 0, 0, 0, time.UTC)
+	for i, position := range []string{"0/1", "0/2", "0/3", "0/4"} {
+		require.NoError(t, c.Save(ctx, &replicator.Checkpoint{
+			ReplicatorID: "orders",
+			Position:     []byte(position),
+			Timestamp:    start.Add(time.Duration(i) * time.Minute),
+			Events:       10,
+		}))
+	}
+
+	// the oldest checkpoint is beyond max_history
+	history, err := c.History(ctx, "orders", time.Time{})
+	require.NoError(t, err)
+	require.Len(t, history, 3)
+	assert.Equal(t, "0/2", string(history[0].Position))
+	assert.Equal(t, 10, history[0].Events)
+
+	history, err = c.History(ctx, "orders", start.Add(2*time.Minute))
+	require.NoError(t, err)
+	assert.Len(t, history, 2)
+
+	checkpoint, err = c.Rollback(ctx, "orders", start.Add(90*time.Second), 0)
+	require.NoError(t, err)
+	assert.Equal(t, "0/2", string(checkpoint.Position))
+	assert.Equal(t, 10, checkpoint.Events)
+
+	_, err = c.Rollback(ctx, "orders", start, 0)
+	assert.ErrorIs(t, err, replicator.ErrNoCheckpoint)
+
+	// the database is locked while open
+	_, err = NewCheckpointer(u, zap.NewNop())
+	assert.ErrorContains(t, err, "locked by another process")
+
+	require.NoError(t, c.Close())
+	c, err = NewCheckpointer(u, zap.NewNop())
+	require.NoError(t, err)
+	defer c.Close()
+
+	checkpoint, err = c.Load(ctx, "orders")
+	require.NoError(t, err)
+	assert.Equal(t, "0/2", string(checkpoint.Position))
+
+	checkpoints, err := c.List(ctx)
+	require.NoError(t, err)
+	assert.Len(t, checkpoints, 1)
+
+	// deleting keeps the history to roll back to
+	require.NoError(t, c.Delete(ctx, "orders"))
+	checkpoint, err = c.Load(ctx, "orders")
+	require.NoError(t, err)
+	assert.Nil(t, checkpoint)
+	_, err = c.Rollback(ctx, "orders", start.Add(time.Hour), 0)
+	require.NoError(t, err)
+
+	// checkpoints of an older lease holder are fenced
+	require.NoError(t, c.Save(ctx, &replicator.Checkpoint{ReplicatorID: "invoices", Position: []byte("2"), Epoch: 2}))
+	err = c.Save(ctx, &replicator.Checkpoint{ReplicatorID: "invoices", Position: []byte("1"), Epoch: 1})
+	assert.ErrorIs(t, err, replicator.ErrStaleEpoch)
+
+	// rollbacks keep the fence of the current checkpoint
+	require.NoError(t, c.Save(ctx, &replicator.Checkpoint{ReplicatorID: "invoices", Position: []byte("3"), Epoch: 3}))
+	_, err = c.Rollback(ctx, "invoices", time.Now(), 2)
+	assert.ErrorIs(t, err, replicator.ErrStaleEpoch)
+	checkpoint, err = c.Rollback(ctx, "invoices", time.Now(), 0)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), checkpoint.Epoch)
+}
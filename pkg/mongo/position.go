@@ -0,0 +1,82 @@
+package mongo
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/turbolytics/librarian/pkg/replicator"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ResumeToken is a change stream resume token, stored in checkpoints as
+// base64 BSON. Tokens are rendered and ordered by their _data field, the hex
+// encoded sort key MongoDB orders change events by; tokens without one are
+// unordered.
+type ResumeToken bson.Raw
+
+func (t ResumeToken) Bytes() []byte {
+	return []byte(base64.StdEncoding.EncodeToString(t))
+}
+
+func (t ResumeToken) String() string {
+	if data, ok := t.data(); ok {
+		return data
+	}
+	return bson.Raw(t).String()
+}
+
+func (t ResumeToken) data() (string, bool) {
+	return bson.Raw(t).Lookup("_data").StringValueOK()
+}
+
+func (t ResumeToken) Compare(other replicator.Position) (int, error) {
+	o, ok := other.(ResumeToken)
+	if !ok {
+		return 0, replicator.ErrPositionsUnordered
+	}
+	a, ok := t.data()
+	if !ok {
+		return 0, replicator.ErrPositionsUnordered
+	}
+	b, ok := o.data()
+	if !ok {
+		return 0, replicator.ErrPositionsUnordered
+	}
+	return strings.Compare(strings.ToUpper(a), strings.ToUpper(b)), nil
+}
+
+type resumeTokenCodec struct{}
+
+func (resumeTokenCodec) Decode(data []byte) (replicator.Position, error) {
+	token, err := base64.StdEncoding.DecodeString(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("expected a base64 encoded resume token: %w", err)
+	}
+	if err := bson.Raw(token).Validate(); err != nil {
+		return nil, fmt.Errorf("resume token is not a BSON document: %w", err)
+	}
+	return ResumeToken(token), nil
+}
+
+// Parse accepts a base64 encoded resume token, or the hex _data of one as
+// rendered in logs and the API.
+func (c resumeTokenCodec) Parse(text string) (replicator.Position, error) {
+	text = strings.TrimSpace(text)
+	if _, err := hex.DecodeString(text); err == nil && text != "" {
+		token, err := bson.Marshal(bson.D{{Key: "_data", Value: strings.ToUpper(text)}})
+		if err != nil {
+			return nil, err
+		}
+		return ResumeToken(token), nil
+	}
+	return c.Decode([]byte(text))
+}
+
+// Positions decodes the resume tokens of the MongoDB source.
+var Positions replicator.PositionCodec = resumeTokenCodec{}
+
+func (s *Source) Positions() replicator.PositionCodec {
+	return Positions
+}
@@ -3,12 +3,14 @@ package mongo
 import (
 	"context"
 	"encoding/base64"
+	"fmt"
 	"net/url"
 	"sync"
 	"time"
 
 	"github.com/turbolytics/librarian/pkg/replicator"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.uber.org/zap"
@@ -24,6 +26,10 @@ type Source struct {
 	changeStream *mongo.ChangeStream
 	statsMu      sync.RWMutex
 	stats        replicator.SourceStats
+
+	// connected is set once Connect was called, so only later calls count
+	// as retries
+	connected bool
 }
 
 func NewSource(ctx context.Context, uri *url.URL, logger *zap.Logger) (*Source, error) {
@@ -48,7 +54,10 @@ func NewSource(ctx context.Context, uri *url.URL, logger *zap.Logger) (*Source,
 
 func (s *Source) Connect(ctx context.Context, checkpoint *replicator.Checkpoint) error {
 	s.statsMu.Lock()
-	s.stats.ConnectionRetries++
+	if s.connected {
+		s.stats.ConnectionRetries++
+	}
+	s.connected = true
 	s.statsMu.Unlock()
 
 	var err error
@@ -72,18 +81,16 @@ func (s *Source) Connect(ctx context.Context, checkpoint *replicator.Checkpoint)
 		SetMaxAwaitTime(5 * time.Second)
 
 	if checkpoint != nil {
-		var resumeToken bson.Raw
-		resumeTokenBytes, err := base64.StdEncoding.DecodeString(string(checkpoint.Position))
+		position, err := Positions.Decode(checkpoint.Position)
 		if err != nil {
 			s.logger.Error("Failed to decode resume token from checkpoint", zap.Error(err))
-			return err
+			return fmt.Errorf("%w: %v", replicator.ErrInvalidPosition, err)
 		}
-		resumeToken = bson.Raw(resumeTokenBytes)
-		opts.SetResumeAfter(resumeToken)
+		opts.SetResumeAfter(bson.Raw(position.(ResumeToken)))
 		s.logger.Info("Resuming from checkpoint",
 			zap.String("database", s.database),
 			zap.String("collection", s.collection),
-			zap.Any("resume_token", checkpoint.Position))
+			zap.Stringer("resume_token", position))
 	}
 
 	// SetFullDocument(options.UpdateLookup) // Include full document for updates
@@ -116,9 +123,36 @@ func (s *Source) Connect(ctx context.Context, checkpoint *replicator.Checkpoint)
 	return nil
 }
 
+// Fingerprint identifies the deployment by its replica set name, on a client
+// of its own, along with the database and collection. Sharded clusters have
+// no replica set name.
+func (s *Source) Fingerprint(ctx context.Context) (*replicator.SourceFingerprint, error) {
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(s.connURI.String()))
+	if err != nil {
+		return nil, err
+	}
+	defer client.Disconnect(context.Background())
+
+	var hello struct {
+		SetName string `bson:"setName"`
+	}
+	err = client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+	if err != nil {
+		return nil, fmt.Errorf("failed to identify deployment: %w", err)
+	}
+	return &replicator.SourceFingerprint{
+		Connector: s.Type(),
+		System:    hello.SetName,
+		Database:  s.database,
+		Stream:    s.collection,
+	}, nil
+}
+
 func (s *Source) Disconnect(ctx context.Context) error {
 	if s.changeStream != nil {
-		if err := s.changeStream.Close(context.Background()); err != nil {
+		err := s.changeStream.Close(context.Background())
+		s.changeStream = nil
+		if err != nil {
 			s.statsMu.Lock()
 			s.stats.LastError = err.Error()
 			s.statsMu.Unlock()
@@ -130,7 +164,13 @@ func (s *Source) Disconnect(ctx context.Context) error {
 	s.stats.ConnectionHealthy = false
 	s.statsMu.Unlock()
 
-	return s.client.Disconnect(ctx)
+	// Disconnect may be called again while reconnecting
+	if s.client == nil {
+		return nil
+	}
+	client := s.client
+	s.client = nil
+	return client.Disconnect(ctx)
 }
 
 func (s *Source) Close() error {
@@ -219,7 +259,7 @@ func (s *Source) Next(ctx context.Context) (replicator.Event, error) {
 				Version:   "1.0.0",
 				Connector: "mongodb",
 				Name:      s.database,
-				TsMs:      now.UnixMilli(),
+				TsMs:      commitTime(changeEvent, now).UnixMilli(),
 				Snapshot:  "false",
 				Db:        s.database,
 				Schema:    s.collection, // MongoDB doesn't have schemas, use collection
@@ -233,6 +273,23 @@ func (s *Source) Next(ctx context.Context) (replicator.Event, error) {
 	}, nil
 }
 
+// commitTime returns when the change was committed: its wallTime, reported by
+// MongoDB 6.0 and later, or else its clusterTime, which has a resolution of
+// seconds. now is returned if the event carries neither.
+func commitTime(changeEvent bson.M, now time.Time) time.Time {
+	if wallTime, ok := changeEvent["wallTime"].(primitive.DateTime); ok {
+		return wallTime.Time()
+	}
+	if clusterTime, ok := changeEvent["clusterTime"].(primitive.Timestamp); ok {
+		return time.Unix(int64(clusterTime.T), 0)
+	}
+	return now
+}
+
+func (s *Source) Type() string {
+	return "mongodb"
+}
+
 func (s *Source) Stats() replicator.SourceStats {
 	s.statsMu.RLock()
 	defer s.statsMu.RUnlock()
@@ -2,6 +2,7 @@ package mongo
 
 import (
 	"context"
+	"encoding/base64"
 	"fmt"
 	"net/url"
 	"testing"
@@ -14,6 +15,7 @@ import (
 	"github.com/testcontainers/testcontainers-go/wait"
 	"github.com/turbolytics/librarian/pkg/replicator"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.uber.org/zap"
@@ -185,6 +187,48 @@ func TestIntegrationMongoSource(t *testing.T) {
 	// Verify source stats
 	stats := source.Stats()
 	assert.True(t, stats.ConnectionHealthy, "connection should be healthy")
+	assert.Zero(t, stats.ConnectionRetries, "the first connection should not be a retry")
 	assert.Equal(t, int64(1), stats.TotalEvents, "should have received 1 event")
 	assert.Greater(t, stats.TotalBytes, int64(0), "should have received some bytes")
 }
+
+func TestCommitTime(t *testing.T) {
+	now := time.Now()
+	wallTime := time.UnixMilli(1700000000123)
+
+	assert.Equal(t, wallTime, commitTime(bson.M{
+		"wallTime":    primitive.NewDateTimeFromTime(wallTime),
+		"clusterTime": primitive.Timestamp{T: 1600000000},
+	}, now))
+	assert.Equal(t, time.Unix(1700000000, 0), commitTime(bson.M{
+		"clusterTime": primitive.Timestamp{T: 1700000000, I: 3},
+	}, now))
+	assert.Equal(t, now, commitTime(bson.M{}, now))
+}
+
+func TestPositions(t *testing.T) {
+	token, err := bson.Marshal(bson.M{"_data": "8263A1B2C3000000012B0229296E04"})
+	require.NoError(t, err)
+	encoded := base64.StdEncoding.EncodeToString(token)
+
+	a, err := Positions.Decode([]byte(encoded))
+	require.NoError(t, err)
+	assert.Equal(t, "8263A1B2C3000000012B0229296E04", a.String())
+	assert.Equal(t, encoded, string(a.Bytes()))
+
+	// the rendered _data parses back to the same token
+	b, err := Positions.Parse("8263a1b2c3000000012b0229296e04")
+	require.NoError(t, err)
+	assert.Equal(t, a.Bytes(), b.Bytes())
+
+	later, err := Positions.Parse("8263A1B2C4000000012B0229296E04")
+	require.NoError(t, err)
+	c, err := replicator.ComparePositions(a, later)
+	require.NoError(t, err)
+	assert.Equal(t, -1, c)
+
+	_, err = Positions.Decode([]byte("0/16B3748"))
+	assert.Error(t, err)
+	_, err = Positions.Decode([]byte(base64.StdEncoding.EncodeToString([]byte("not bson"))))
+	assert.Error(t, err)
+}
@@ -0,0 +1,51 @@
+package postgres
+
+import (
+	"cmp"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/turbolytics/librarian/pkg/replicator"
+)
+
+// LSN is a position in the WAL, stored in checkpoints as text such as
+// 0/16B3748. LSNs are ordered.
+type LSN pglogrepl.LSN
+
+func (l LSN) Bytes() []byte {
+	return []byte(l.String())
+}
+
+func (l LSN) String() string {
+	return pglogrepl.LSN(l).String()
+}
+
+func (l LSN) Compare(other replicator.Position) (int, error) {
+	o, ok := other.(LSN)
+	if !ok {
+		return 0, replicator.ErrPositionsUnordered
+	}
+	return cmp.Compare(l, o), nil
+}
+
+type lsnCodec struct{}
+
+func (c lsnCodec) Decode(data []byte) (replicator.Position, error) {
+	return c.Parse(string(data))
+}
+
+func (lsnCodec) Parse(text string) (replicator.Position, error) {
+	lsn, err := pglogrepl.ParseLSN(strings.TrimSpace(text))
+	if err != nil {
+		return nil, fmt.Errorf("expected a Postgres LSN such as 0/16B3748: %w", err)
+	}
+	return LSN(lsn), nil
+}
+
+// Positions decodes the LSNs of the Postgres source.
+var Positions replicator.PositionCodec = lsnCodec{}
+
+func (s *Source) Positions() replicator.PositionCodec {
+	return Positions
+}
@@ -0,0 +1,29 @@
+package postgres
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/turbolytics/librarian/pkg/replicator"
+)
+
+func TestPositions(t *testing.T) {
+	a, err := Positions.Decode([]byte("0/16B3748"))
+	require.NoError(t, err)
+	assert.Equal(t, "0/16B3748", a.String())
+	assert.Equal(t, []byte("0/16B3748"), a.Bytes())
+
+	// LSNs are compared as numbers, not text
+	b, err := Positions.Parse(" 1/0 ")
+	require.NoError(t, err)
+	c, err := replicator.ComparePositions(a, b)
+	require.NoError(t, err)
+	assert.Equal(t, -1, c)
+
+	_, err = replicator.ComparePositions(a, replicator.RawPosition("1/0"))
+	assert.ErrorIs(t, err, replicator.ErrPositionsUnordered)
+
+	_, err = Positions.Decode([]byte("gAAAAA=="))
+	assert.Error(t, err)
+}
@@ -31,12 +31,20 @@ type Source struct {
 	currentLSN pglogrepl.LSN
 	relations  map[uint32]*pglogrepl.RelationMessage
 
+	// commitTime is the commit time of the transaction being decoded, sent
+	// by the primary in the BEGIN message
+	commitTime time.Time
+
 	// Buffer for pending events
 	eventBuffer   []replicator.Event
 	lastHeartbeat time.Time
 
 	statsMu sync.RWMutex
 	stats   replicator.SourceStats
+
+	// connected is set once Connect was called, so only later calls count
+	// as retries
+	connected bool
 }
 
 func NewSource(uri *url.URL, logger *zap.Logger) (*Source, error) {
@@ -211,6 +219,7 @@ func (s *Source) processCopyData(ctx context.Context, data []byte) (replicator.E
 		return s.handleCommit(ctx, msg)
 
 	case *pglogrepl.BeginMessage:
+		s.commitTime = msg.CommitTime
 		s.logger.Debug("Transaction begin", zap.Uint32("xid", msg.Xid))
 		return replicator.Event{}, replicator.ErrNoEventsFound
 
@@ -248,7 +257,7 @@ func (s *Source) handleInsert(msg *pglogrepl.InsertMessage) (replicator.Event, e
 				Version:   "1.0.0",
 				Connector: "postgresql",
 				Name:      s.database,
-				TsMs:      now.UnixMilli(),
+				TsMs:      s.committedAt(now).UnixMilli(),
 				Snapshot:  "false",
 				Db:        s.database,
 				Schema:    rel.Namespace,
@@ -302,7 +311,7 @@ func (s *Source) handleUpdate(msg *pglogrepl.UpdateMessage) (replicator.Event, e
 				Version:   "1.0.0",
 				Connector: "postgresql",
 				Name:      s.database,
-				TsMs:      now.UnixMilli(),
+				TsMs:      s.committedAt(now).UnixMilli(),
 				Snapshot:  "false",
 				Db:        s.database,
 				Schema:    rel.Namespace,
@@ -354,7 +363,7 @@ func (s *Source) handleDelete(msg *pglogrepl.DeleteMessage) (replicator.Event, e
 				Version:   "1.0.0",
 				Connector: "postgresql",
 				Name:      s.database,
-				TsMs:      now.UnixMilli(),
+				TsMs:      s.committedAt(now).UnixMilli(),
 				Snapshot:  "false",
 				Db:        s.database,
 				Schema:    rel.Namespace,
@@ -400,6 +409,15 @@ func (s *Source) handleCommit(ctx context.Context, msg *pglogrepl.CommitMessage)
 	return replicator.Event{}, replicator.ErrNoEventsFound
 }
 
+// committedAt returns the commit time of the current transaction, or now if
+// it is unknown.
+func (s *Source) committedAt(now time.Time) time.Time {
+	if s.commitTime.IsZero() {
+		return now
+	}
+	return s.commitTime
+}
+
 func (s *Source) tupleToMap(rel *pglogrepl.RelationMessage, tuple *pglogrepl.TupleData) map[string]interface{} {
 	values := make(map[string]interface{})
 
@@ -446,7 +464,10 @@ func (s *Source) tupleToMap(rel *pglogrepl.RelationMessage, tuple *pglogrepl.Tup
 
 func (s *Source) Connect(ctx context.Context, checkpoint *replicator.Checkpoint) error {
 	s.statsMu.Lock()
-	s.stats.ConnectionRetries++
+	if s.connected {
+		s.stats.ConnectionRetries++
+	}
+	s.connected = true
 	s.statsMu.Unlock()
 
 	// Create regular connection for setup
@@ -530,6 +551,10 @@ func (s *Source) Close() error {
 	return s.Disconnect(context.Background())
 }
 
+func (s *Source) Type() string {
+	return "postgresql"
+}
+
 func (s *Source) Stats() replicator.SourceStats {
 	s.statsMu.RLock()
 	defer s.statsMu.RUnlock()
@@ -595,13 +620,13 @@ func (s *Source) setupReplication(ctx context.Context) error {
 }
 
 func (s *Source) getStartingLSN(ctx context.Context, checkpoint *replicator.Checkpoint) (pglogrepl.LSN, error) {
-	if checkpoint != nil {
-		if lsnStr := string(checkpoint.Position); lsnStr != "" {
-			if lsn, err := pglogrepl.ParseLSN(lsnStr); err == nil {
-				s.logger.Info("Resuming from checkpoint", zap.String("lsn", lsnStr))
-				return lsn, nil
-			}
+	if checkpoint != nil && len(checkpoint.Position) > 0 {
+		position, err := Positions.Decode(checkpoint.Position)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %v", replicator.ErrInvalidPosition, err)
 		}
+		s.logger.Info("Resuming from checkpoint", zap.Stringer("lsn", position))
+		return pglogrepl.LSN(position.(LSN)), nil
 	}
 
 	// Get current LSN
@@ -619,3 +644,30 @@ func (s *Source) getStartingLSN(ctx context.Context, checkpoint *replicator.Chec
 	s.logger.Info("Starting from current LSN", zap.String("lsn", currentLSNStr))
 	return lsn, nil
 }
+
+// Fingerprint identifies the cluster by the system identifier reported by
+// IDENTIFY_SYSTEM, on a replication connection of its own, along with the
+// database and slot.
+func (s *Source) Fingerprint(ctx context.Context) (*replicator.SourceFingerprint, error) {
+	config, err := pgconn.ParseConfig(s.connURI.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse replication config: %w", err)
+	}
+	config.RuntimeParams["replication"] = "database"
+	conn, err := pgconn.ConnectConfig(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create replication connection: %w", err)
+	}
+	defer conn.Close(context.Background())
+
+	system, err := pglogrepl.IdentifySystem(ctx, conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to identify system: %w", err)
+	}
+	return &replicator.SourceFingerprint{
+		Connector: s.Type(),
+		System:    system.SystemID,
+		Database:  s.database,
+		Stream:    s.slotName,
+	}, nil
+}
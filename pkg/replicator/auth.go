@@ -0,0 +1,272 @@
+package replicator
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.uber.org/zap"
+)
+
+// Role is what a client of the server is allowed to do. Operators can do
+// everything readers can.
+type Role string
+
+const (
+	// RoleReader reads replicators, their stats, checkpoints, events and
+	// metrics
+	RoleReader Role = "read"
+
+	// RoleOperator also sends signals and creates, updates and deletes
+	// replicators and checkpoints
+	RoleOperator Role = "operator"
+)
+
+func ParseRole(s string) (Role, error) {
+	switch Role(s) {
+	case RoleReader, RoleOperator:
+		return Role(s), nil
+	default:
+		return "", fmt.Errorf("unknown role %q: expected %q or %q", s, RoleReader, RoleOperator)
+	}
+}
+
+func (r Role) allows(required Role) bool {
+	return r == RoleOperator || r == required
+}
+
+// Credential is a static token which authenticates a client as Name. Clients
+// send it as a bearer token, "Authorization: Bearer <token>", or as an API
+// key, "X-API-Key: <token>".
+type Credential struct {
+	Name  string `yaml:"name" json:"name"`
+	Role  Role   `yaml:"role" json:"role"`
+	Token string `yaml:"token" json:"token"`
+}
+
+// Principal is the authenticated client of a request.
+type Principal struct {
+	Name string
+	Role Role
+}
+
+type principalKey struct{}
+
+// PrincipalFromContext returns the client authenticated for a request, if
+// any.
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// TLSOptions configure the certificate the server listens with. Clients must
+// present a certificate signed by ClientCAFile when it is set.
+type TLSOptions struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+}
+
+// WithTLS serves the API over HTTPS.
+func WithTLS(opts TLSOptions) ServerOption {
+	return func(s *Server) {
+		s.tls = &opts
+	}
+}
+
+// WithCredentials requires every request to authenticate with one of the
+// credentials. Without credentials the API is open to anyone who can reach
+// it, or to any client with a verified certificate when mutual TLS is
+// enabled.
+func WithCredentials(credentials ...Credential) ServerOption {
+	return func(s *Server) {
+		for _, c := range credentials {
+			s.credentials = append(s.credentials, credential{
+				principal: Principal{Name: c.Name, Role: c.Role},
+				digest:    sha256.Sum256([]byte(c.Token)),
+			})
+		}
+	}
+}
+
+// WithAuditLogger logs changes made through the API, and refused attempts,
+// to logger instead of the server logger.
+func WithAuditLogger(logger *zap.Logger) ServerOption {
+	return func(s *Server) {
+		s.audit = logger
+	}
+}
+
+// credential keeps a digest of the token so comparisons take the same time
+// whatever its length.
+type credential struct {
+	principal Principal
+	digest    [sha256.Size]byte
+}
+
+// ValidateCredentials checks that every credential has a name, a known role
+// and a token, and that names and tokens are unique.
+func ValidateCredentials(credentials []Credential) error {
+	names := make(map[string]struct{})
+	tokens := make(map[string]struct{})
+	for i, c := range credentials {
+		if c.Name == "" {
+			return fmt.Errorf("credential %d: name is required", i)
+		}
+		if _, err := ParseRole(string(c.Role)); err != nil {
+			return fmt.Errorf("credential %s: %w", c.Name, err)
+		}
+		if c.Token == "" {
+			return fmt.Errorf("credential %s: token is required", c.Name)
+		}
+		if _, ok := names[c.Name]; ok {
+			return fmt.Errorf("credential %s: duplicate name", c.Name)
+		}
+		if _, ok := tokens[c.Token]; ok {
+			return fmt.Errorf("credential %s: duplicate token", c.Name)
+		}
+		names[c.Name] = struct{}{}
+		tokens[c.Token] = struct{}{}
+	}
+	return nil
+}
+
+// authenticate identifies the client of a request from its token or, when
+// no credentials are configured, its client certificate. Requests are not
+// rejected here; require does that once the route is known.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p, ok := s.principal(r); ok {
+			r = r.WithContext(context.WithValue(r.Context(), principalKey{}, p))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) principal(r *http.Request) (Principal, bool) {
+	if len(s.credentials) == 0 {
+		if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+			return Principal{Name: r.TLS.PeerCertificates[0].Subject.CommonName, Role: RoleOperator}, true
+		}
+		return Principal{Name: "anonymous", Role: RoleOperator}, true
+	}
+
+	token := r.Header.Get("X-API-Key")
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		scheme, value, _ := strings.Cut(auth, " ")
+		if !strings.EqualFold(scheme, "Bearer") {
+			return Principal{}, false
+		}
+		token = strings.TrimSpace(value)
+	}
+	if token == "" {
+		return Principal{}, false
+	}
+
+	digest := sha256.Sum256([]byte(token))
+	var found *Principal
+	for i := range s.credentials {
+		// compare against every credential so the time taken does not
+		// reveal which one matched
+		if subtle.ConstantTimeCompare(digest[:], s.credentials[i].digest[:]) == 1 {
+			found = &s.credentials[i].principal
+		}
+	}
+	if found == nil {
+		return Principal{}, false
+	}
+	return *found, true
+}
+
+// require rejects requests from clients which are not authenticated with
+// the role.
+func (s *Server) require(role Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, ok := PrincipalFromContext(r.Context())
+			if !ok {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="librarian"`)
+				http.Error(w, "authentication required", http.StatusUnauthorized)
+				return
+			}
+			if !p.Role.allows(role) {
+				http.Error(w, fmt.Sprintf("%s role required", role), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// auditRequests logs who made each request and its outcome, including
+// requests refused for lack of authentication or role.
+func (s *Server) auditRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		start := time.Now()
+		next.ServeHTTP(ww, r)
+
+		principal := "unauthenticated"
+		var role Role
+		if p, ok := PrincipalFromContext(r.Context()); ok {
+			principal, role = p.Name, p.Role
+		}
+
+		// the route is only resolved once the request has been served
+		var route string
+		if rctx := chi.RouteContext(r.Context()); rctx != nil {
+			route = rctx.RoutePattern()
+		}
+
+		s.audit.Info("api request",
+			zap.String("principal", principal),
+			zap.String("role", string(role)),
+			zap.String("method", r.Method),
+			zap.String("route", route),
+			zap.String("replicator_id", chi.URLParam(r, "id")),
+			zap.Int("status", ww.Status()),
+			zap.String("remote_addr", r.RemoteAddr),
+			zap.String("request_id", middleware.GetReqID(r.Context())),
+			zap.Duration("duration", time.Since(start)))
+	})
+}
+
+// tlsConfig builds the TLS configuration of the server, requiring client
+// certificates when a client CA is set.
+func (o TLSOptions) tlsConfig() (*tls.Config, error) {
+	if o.CertFile == "" || o.KeyFile == "" {
+		return nil, errors.New("tls requires a certificate and a key")
+	}
+	cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tls certificate: %w", err)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if o.ClientCAFile != "" {
+		pem, err := os.ReadFile(o.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client ca: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", o.ClientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
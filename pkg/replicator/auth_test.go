@@ -0,0 +1,183 @@
+package replicator
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestServerAuth(t *testing.T) {
+	r, err := New(WithID("orders"), WithSource(newMemorySource()), WithTarget(&memoryTarget{}))
+	require.NoError(t, err)
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	s := NewServer(zap.NewNop(),
+		WithCredentials(
+			Credential{Name: "grafana", Role: RoleReader, Token: "read-token"},
+			Credential{Name: "oncall", Role: RoleOperator, Token: "operator-token"},
+		),
+		WithAuditLogger(zap.New(core)),
+	)
+	s.RegisterReplicator(r)
+	srv := httptest.NewServer(s.Routes())
+	defer srv.Close()
+
+	do := func(method, path string, header http.Header) int {
+		req, err := http.NewRequest(method, srv.URL+path, nil)
+		require.NoError(t, err)
+		req.Header = header
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	bearer := func(token string) http.Header {
+		return http.Header{"Authorization": []string{"Bearer " + token}}
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/api/v1/replicators", nil))
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/metrics", bearer("wrong")))
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/api/v1/replicators/orders", bearer("read-token")))
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/metrics", http.Header{"X-Api-Key": []string{"read-token"}}))
+
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodPost, "/api/v1/replicators/orders/stop", nil))
+	assert.Equal(t, http.StatusForbidden, do(http.MethodPost, "/api/v1/replicators/orders/stop", bearer("read-token")))
+	assert.Equal(t, http.StatusOK, do(http.MethodPost, "/api/v1/replicators/orders/pause", bearer("operator-token")))
+
+	// every attempt to control a replicator is audited, reads are not
+	entries := logs.FilterMessage("api request").All()
+	require.Len(t, entries, 3)
+
+	fields := entries[1].ContextMap()
+	assert.Equal(t, "grafana", fields["principal"])
+	assert.Equal(t, int64(http.StatusForbidden), fields["status"])
+
+	fields = entries[2].ContextMap()
+	assert.Equal(t, "oncall", fields["principal"])
+	assert.Equal(t, "operator", fields["role"])
+	assert.Equal(t, "/api/v1/replicators/{id}/pause", fields["route"])
+	assert.Equal(t, "orders", fields["replicator_id"])
+	assert.Equal(t, int64(http.StatusOK), fields["status"])
+}
+
+func TestValidateCredentials(t *testing.T) {
+	assert.NoError(t, ValidateCredentials([]Credential{
+		{Name: "a", Role: RoleReader, Token: "x"},
+		{Name: "b", Role: RoleOperator, Token: "y"},
+	}))
+	assert.Error(t, ValidateCredentials([]Credential{{Name: "a", Role: "admin", Token: "x"}}))
+	assert.Error(t, ValidateCredentials([]Credential{{Name: "a", Role: RoleReader}}))
+	assert.Error(t, ValidateCredentials([]Credential{
+		{Name: "a", Role: RoleReader, Token: "x"},
+		{Name: "b", Role: RoleReader, Token: "x"},
+	}))
+}
+
+func TestServerMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := newTestCertificate(t, "librarian-ca", nil, nil)
+	server, serverKey := newTestCertificate(t, "localhost", ca, caKey)
+	client, clientKey := newTestCertificate(t, "deploy-bot", ca, caKey)
+
+	write := func(name string, block *pem.Block) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(block), 0600))
+		return path
+	}
+	opts := TLSOptions{
+		CertFile:     write("server.crt", &pem.Block{Type: "CERTIFICATE", Bytes: server.Raw}),
+		KeyFile:      write("server.key", keyBlock(t, serverKey)),
+		ClientCAFile: write("ca.crt", &pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}),
+	}
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	s := NewServer(zap.NewNop(), WithTLS(opts), WithAuditLogger(zap.New(core)))
+	r, err := New(WithID("orders"), WithSource(newMemorySource()), WithTarget(&memoryTarget{}))
+	require.NoError(t, err)
+	s.RegisterReplicator(r)
+
+	config, err := opts.tlsConfig()
+	require.NoError(t, err)
+	srv := httptest.NewUnstartedServer(s.Routes())
+	srv.TLS = config
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	newClient := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      roots,
+			Certificates: certs,
+		}}}
+	}
+
+	_, err = newClient().Post(srv.URL+"/api/v1/replicators/orders/pause", "", nil)
+	assert.Error(t, err, "clients without a certificate are refused")
+
+	resp, err := newClient(tls.Certificate{
+		Certificate: [][]byte{client.Raw},
+		PrivateKey:  clientKey,
+	}).Post(srv.URL+"/api/v1/replicators/orders/pause", "", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	entries := logs.FilterMessage("api request").All()
+	require.Len(t, entries, 1)
+	assert.Equal(t, "deploy-bot", entries[0].ContextMap()["principal"])
+}
+
+// newTestCertificate creates a certificate for 127.0.0.1 signed by parent, or
+// a self-signed CA when parent is nil.
+func newTestCertificate(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert, key
+}
+
+func keyBlock(t *testing.T, key *ecdsa.PrivateKey) *pem.Block {
+	der, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+}
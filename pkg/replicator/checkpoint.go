@@ -3,18 +3,65 @@ package replicator
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"go.uber.org/zap"
 )
 
+// ErrCheckpointConflict is returned by checkpointers which detect that
+// another writer changed a checkpoint since it was loaded or saved, such as a
+// second replicator running with the same ID.
+var ErrCheckpointConflict = errors.New("checkpoint was changed by another writer")
+
+// ErrNoCheckpoint is returned when no checkpoint matches a request, such as
+// a rollback to a time before the first saved checkpoint.
+var ErrNoCheckpoint = errors.New("no checkpoint found")
+
+// ErrHistoryUnsupported is returned when the checkpoint history of a
+// replicator is requested from a checkpointer which does not keep one.
+var ErrHistoryUnsupported = errors.New("checkpointer does not keep a checkpoint history")
+
+// ErrStaleEpoch is returned by checkpointers which fence checkpoints when a
+// checkpoint is saved with an older lease epoch than the stored one, because
+// another instance has acquired the lease of the replicator since.
+var ErrStaleEpoch = errors.New("checkpoint was saved by a newer lease holder")
+
 type Checkpoint struct {
 	ReplicatorID string    `json:"replicator_id"`
 	Position     []byte    `json:"position"`
 	Timestamp    time.Time `json:"timestamp"`
+
+	// Events is the number of events acknowledged since the previous
+	// checkpoint
+	Events int `json:"events,omitempty"`
+
+	// Epoch is the lease epoch of the instance which saved the checkpoint,
+	// 0 without a lease
+	Epoch uint64 `json:"epoch,omitempty"`
+
+	// Source identifies the source the position belongs to, and Version
+	// the format of the checkpoint, CheckpointVersion when it was saved by
+	// this replicator
+	Source  *SourceFingerprint `json:"source,omitempty"`
+	Version int                `json:"version,omitempty"`
+}
+
+// CheckEpoch returns ErrStaleEpoch if saving checkpoint over stored would
+// overwrite the checkpoint of a newer lease holder. Checkpoints saved without
+// a lease are never fenced, so operators can still move them.
+func CheckEpoch(stored, checkpoint *Checkpoint) error {
+	if stored == nil || checkpoint.Epoch == 0 || checkpoint.Epoch >= stored.Epoch {
+		return nil
+	}
+	return fmt.Errorf("%w: epoch %d of %s is older than the stored epoch %d",
+		ErrStaleEpoch, checkpoint.Epoch, checkpoint.ReplicatorID, stored.Epoch)
 }
 
 type Checkpointer interface {
@@ -94,6 +141,15 @@ func (f *FilesystemCheckpointer) Save(ctx context.Context, checkpoint *Checkpoin
 	checkpointPath := filepath.Join(f.baseDir, checkpoint.ReplicatorID+".checkpoint")
 	tempPath := checkpointPath + ".tmp"
 
+	if data, err := os.ReadFile(checkpointPath); err == nil {
+		var stored Checkpoint
+		if err := json.Unmarshal(data, &stored); err == nil {
+			if err := CheckEpoch(&stored, checkpoint); err != nil {
+				return err
+			}
+		}
+	}
+
 	// Marshal checkpoint data
 	data, err := json.MarshalIndent(checkpoint, "", "  ")
 	if err != nil {
@@ -138,3 +194,72 @@ func (f *FilesystemCheckpointer) Delete(ctx context.Context, replicatorID string
 	f.logger.Info("Checkpoint deleted", zap.String("replicator_id", replicatorID))
 	return nil
 }
+
+// CheckpointLister is implemented by checkpointers which can enumerate the
+// checkpoints of every replicator, to export them.
+type CheckpointLister interface {
+	List(ctx context.Context) ([]*Checkpoint, error)
+}
+
+// CheckpointHistory is implemented by checkpointers which keep the earlier
+// checkpoints of each replicator.
+type CheckpointHistory interface {
+	// History returns the checkpoints saved since the given time, oldest
+	// first
+	History(ctx context.Context, replicatorID string, since time.Time) ([]*Checkpoint, error)
+}
+
+// CheckpointRollbacker is implemented by checkpointers which can restore a
+// checkpoint from their history.
+type CheckpointRollbacker interface {
+	// Rollback makes the latest checkpoint saved at or before to the
+	// current checkpoint and returns it, or ErrNoCheckpoint if there is
+	// none. The restored checkpoint is saved under epoch, see
+	// RestoredEpoch, and fenced like Save.
+	Rollback(ctx context.Context, replicatorID string, to time.Time, epoch uint64) (*Checkpoint, error)
+}
+
+// RestoredEpoch returns the epoch a checkpoint restored from the history is
+// saved under: the epoch of the lease of the rollback, or the epoch of the
+// current checkpoint when it holds none, so a rollback never lowers the
+// fence.
+func RestoredEpoch(current *Checkpoint, epoch uint64) uint64 {
+	if epoch == 0 && current != nil {
+		return current.Epoch
+	}
+	return epoch
+}
+
+func (f *FilesystemCheckpointer) List(ctx context.Context) ([]*Checkpoint, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	entries, err := os.ReadDir(f.baseDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var checkpoints []*Checkpoint
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".checkpoint") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(f.baseDir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var checkpoint Checkpoint
+		if err := json.Unmarshal(data, &checkpoint); err != nil {
+			return nil, fmt.Errorf("invalid checkpoint %s: %w", entry.Name(), err)
+		}
+		checkpoints = append(checkpoints, &checkpoint)
+	}
+
+	sort.Slice(checkpoints, func(i, j int) bool {
+		return checkpoints[i].ReplicatorID < checkpoints[j].ReplicatorID
+	})
+	return checkpoints, nil
+}
@@ -0,0 +1,171 @@
+package replicator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ErrorPolicy decides what happens to an event the replicator cannot
+// transform or deliver.
+type ErrorPolicy string
+
+const (
+	// ErrorPolicyFail stops the pipeline, which reconnects according to the
+	// RetryOptions and replays the event.
+	ErrorPolicyFail ErrorPolicy = "fail"
+	// ErrorPolicySkip logs and drops the event.
+	ErrorPolicySkip ErrorPolicy = "skip"
+	// ErrorPolicyDLQ writes the event to the dead letter queue.
+	ErrorPolicyDLQ ErrorPolicy = "dlq"
+)
+
+// Stages at which an event can fail.
+const (
+	StageFilter    = "filter"
+	StageTransform = "transform"
+	StageWrite     = "write"
+	StageDeliver   = "deliver"
+)
+
+type ErrorOptions struct {
+	// errors.policy
+	Policy ErrorPolicy
+
+	// errors.max_retries
+	// number of times a failed write or delivery is retried before the
+	// policy is applied. Filter and transform errors are never retried.
+	MaxRetries int
+
+	// errors.retry_backoff
+	// delay between retries of the same event
+	RetryBackoff time.Duration
+}
+
+// EventError marks a target error caused by a single event, such as a
+// serialization failure, rather than by the target connection. The replicator
+// applies its ErrorPolicy to event errors instead of reconnecting the target.
+type EventError struct {
+	Err error
+}
+
+func (e *EventError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *EventError) Unwrap() error {
+	return e.Err
+}
+
+// DeadLetter is an event that could not be transformed or delivered, along
+// with the error and the position it was read at, as rendered by the
+// source.
+type DeadLetter struct {
+	ReplicatorID string    `json:"replicator_id"`
+	Stage        string    `json:"stage"`
+	Error        string    `json:"error"`
+	Attempts     int       `json:"attempts"`
+	Position     string    `json:"position"`
+	Timestamp    time.Time `json:"timestamp"`
+	Event        Event     `json:"event"`
+}
+
+// MarshalJSON encodes the event on a best-effort basis: an event the target
+// could not serialize likely cannot be encoded either, and failing to write
+// its dead letter would replay it forever. Such an event is stored rendered
+// with %v, along with the encoding error.
+func (l DeadLetter) MarshalJSON() ([]byte, error) {
+	type deadLetter DeadLetter
+	encoded := struct {
+		deadLetter
+		Event              json.RawMessage `json:"event"`
+		EventEncodingError string          `json:"event_encoding_error,omitempty"`
+	}{deadLetter: deadLetter(l)}
+
+	event, err := json.Marshal(l.Event)
+	if err != nil {
+		encoded.EventEncodingError = err.Error()
+		if event, err = json.Marshal(fmt.Sprintf("%v", l.Event)); err != nil {
+			return nil, err
+		}
+	}
+	encoded.Event = event
+	return json.Marshal(encoded)
+}
+
+// DeadLetterQueue stores events the replicator gave up on. Write must not
+// return before the dead letter is durably stored, since the event's position
+// is checkpointed afterwards.
+type DeadLetterQueue interface {
+	Write(ctx context.Context, letter DeadLetter) error
+	Close(ctx context.Context) error
+}
+
+// FileDeadLetterQueue appends dead letters as JSON lines to a local file.
+type FileDeadLetterQueue struct {
+	path   string
+	logger *zap.Logger
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+func NewFileDeadLetterQueue(path string, logger *zap.Logger) *FileDeadLetterQueue {
+	return &FileDeadLetterQueue{
+		path:   path,
+		logger: logger,
+	}
+}
+
+func (f *FileDeadLetterQueue) Write(ctx context.Context, letter DeadLetter) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+			return err
+		}
+		file, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		f.file = file
+	}
+
+	data, err := json.Marshal(letter)
+	if err != nil {
+		return fmt.Errorf("failed to marshal dead letter: %w", err)
+	}
+
+	if _, err := f.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if err := f.file.Sync(); err != nil {
+		return err
+	}
+
+	f.logger.Debug("Dead letter written",
+		zap.String("path", f.path),
+		zap.String("replicator_id", letter.ReplicatorID),
+		zap.String("position", letter.Position))
+
+	return nil
+}
+
+func (f *FileDeadLetterQueue) Close(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
@@ -1,5 +1,12 @@
 package replicator
 
+import (
+	"encoding/json"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
 // Operation represents Debezium operation codes
 type Operation string
 
@@ -51,8 +58,52 @@ type Event struct {
 	// Payload contains the actual change data
 	Payload Payload `json:"payload"`
 
-	// Position is used internally for checkpointing (not part of Debezium format)
+	// Position is the encoded source position, used internally for
+	// checkpointing and decoded by the PositionCodec of the source (not part
+	// of Debezium format)
 	Position []byte `json:"-"`
+
+	// Sequence is assigned by the replicator when the event is handed to the
+	// target and identifies the event in delivery acknowledgements.
+	Sequence uint64 `json:"-"`
+
+	// Topic overrides the destination topic of targets that write to topics
+	Topic string `json:"-"`
+
+	// Headers are written as message headers by targets that support them
+	Headers map[string]string `json:"-"`
+
+	// Unwrapped events serialize to their after image only
+	Unwrapped bool `json:"-"`
+
+	// spanContext is the span the event was read in, which the spans
+	// recorded while writing the event are children of
+	spanContext trace.SpanContext
+
+	// readAt is when the replicator read the event from the source
+	readAt time.Time
+}
+
+// CommitTime returns when the change was committed to the source database,
+// as reported by the source in Payload.Source.TsMs, or the zero time if the
+// source does not report it.
+func (e Event) CommitTime() time.Time {
+	if e.Payload.Source.TsMs <= 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(e.Payload.Source.TsMs)
+}
+
+// MarshalJSON serializes the Debezium envelope, or only the after image when
+// the event has been unwrapped.
+func (e Event) MarshalJSON() ([]byte, error) {
+	if e.Unwrapped {
+		return json.Marshal(e.Payload.After)
+	}
+
+	// alias drops the MarshalJSON method to avoid recursing
+	type alias Event
+	return json.Marshal(alias(e))
 }
 
 func (e Event) IsZero() bool {
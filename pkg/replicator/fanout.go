@@ -0,0 +1,486 @@
+package replicator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// FanOutTarget is a target fed by a FanOut.
+type FanOutTarget struct {
+	// Name identifies the target in logs and stats
+	Name   string
+	Target Target
+
+	// BestEffort targets do not hold back the checkpoint. Their write,
+	// delivery and connection errors are logged and counted, and the event
+	// is not retried for them.
+	BestEffort bool
+
+	// FlushInterval is the minimum time between flushes of this target.
+	// Flushes happen on the replicator's flush ticker, so the effective
+	// interval is rounded up to a multiple of TargetOptions.FlushTimeout. 0
+	// flushes the target on every tick.
+	FlushInterval time.Duration
+}
+
+// FanOut is a Target that writes every event to several targets, so a single
+// source can feed them all. An event is acknowledged once every required
+// target has acknowledged it, which means the checkpoint only advances to the
+// lowest position delivered to all required targets.
+//
+// A failure of a required target fails the event as a whole; retries write it
+// again only to the targets which have not delivered it yet. Targets still see
+// an event more than once when the replicator reconnects and replays from the
+// last checkpoint.
+type FanOut struct {
+	targets []*fanOutTarget
+	logger  *zap.Logger
+
+	// route selects the targets an event is written to, all of them when
+	// nil
+	route func(event Event) []bool
+
+	mu         sync.Mutex
+	deliveries map[uint64]*fanOutDelivery
+	ackFn      AckFunc
+	acked      int64
+}
+
+// forgetter is implemented by targets which keep the state of failed events
+// for retries, to learn when the pipeline gives up on an event.
+type forgetter interface {
+	forget(seq uint64)
+}
+
+type fanOutTarget struct {
+	FanOutTarget
+
+	async     bool
+	connected bool
+	lastFlush time.Time
+
+	inFlight  int
+	delivered int64
+	failed    int64
+}
+
+type deliveryState int
+
+const (
+	deliveryPending deliveryState = iota
+	deliveryInFlight
+	deliveryDone
+)
+
+// fanOutDelivery tracks one event across the targets.
+type fanOutDelivery struct {
+	states []deliveryState
+
+	// writing is set while Write runs; failures reported by asynchronous
+	// targets meanwhile are passed on once it returns
+	writing bool
+	err     error
+
+	// reported is set once the outcome has been passed to the replicator
+	reported bool
+}
+
+func NewFanOut(targets []FanOutTarget, logger *zap.Logger) (*FanOut, error) {
+	if len(targets) == 0 {
+		return nil, errors.New("fan out requires at least one target")
+	}
+
+	f := &FanOut{
+		logger:     logger,
+		deliveries: make(map[uint64]*fanOutDelivery),
+	}
+
+	names := make(map[string]bool, len(targets))
+	required := 0
+	for i, t := range targets {
+		if t.Name == "" {
+			t.Name = fmt.Sprintf("target-%d", i)
+		}
+		if names[t.Name] {
+			return nil, fmt.Errorf("duplicate fan out target name: %q", t.Name)
+		}
+		names[t.Name] = true
+		if !t.BestEffort {
+			required++
+		}
+
+		ft := &fanOutTarget{FanOutTarget: t}
+		if a, ok := t.Target.(Acknowledger); ok {
+			ft.async = true
+			i := i
+			a.OnAck(func(seq uint64, err error) {
+				f.delivered(i, seq, err)
+			})
+		}
+		f.targets = append(f.targets, ft)
+	}
+
+	if required == 0 {
+		return nil, errors.New("fan out requires at least one target that is not best effort")
+	}
+
+	return f, nil
+}
+
+// OnAck implements Acknowledger. Targets which do not implement it are
+// acknowledged once their Write returns.
+func (f *FanOut) OnAck(fn AckFunc) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.ackFn = fn
+}
+
+// Connect connects every target. A best effort target which fails to connect
+// is skipped until a later Flush reconnects it.
+func (f *FanOut) Connect(ctx context.Context) error {
+	f.mu.Lock()
+	f.deliveries = make(map[uint64]*fanOutDelivery)
+	for _, t := range f.targets {
+		t.inFlight = 0
+	}
+	f.mu.Unlock()
+
+	for _, t := range f.targets {
+		err := t.Target.Connect(ctx)
+		if err != nil && !t.BestEffort {
+			return fmt.Errorf("%s: %w", t.Name, err)
+		}
+		if err != nil {
+			f.logger.Warn("Best effort target failed to connect",
+				zap.String("target", t.Name),
+				zap.Error(err))
+		}
+
+		f.mu.Lock()
+		t.connected = err == nil
+		t.lastFlush = time.Now()
+		f.mu.Unlock()
+	}
+	return nil
+}
+
+func (f *FanOut) Disconnect(ctx context.Context) error {
+	return f.each(func(t *fanOutTarget) error {
+		f.mu.Lock()
+		t.connected = false
+		f.mu.Unlock()
+		return t.Target.Disconnect(ctx)
+	})
+}
+
+func (f *FanOut) Close(ctx context.Context) error {
+	return f.each(func(t *fanOutTarget) error {
+		f.mu.Lock()
+		t.connected = false
+		f.mu.Unlock()
+		return t.Target.Close(ctx)
+	})
+}
+
+// each calls fn for every target, joining the errors.
+func (f *FanOut) each(fn func(t *fanOutTarget) error) error {
+	var errs []error
+	for _, t := range f.targets {
+		if err := fn(t); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", t.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Write writes the event to every connected target which has not delivered
+// it yet. Errors of required targets are returned, wrapped with the target
+// name, so EventErrors keep their meaning.
+func (f *FanOut) Write(ctx context.Context, event Event) error {
+	seq := event.Sequence
+
+	f.mu.Lock()
+	d, ok := f.deliveries[seq]
+	if !ok {
+		d = &fanOutDelivery{states: make([]deliveryState, len(f.targets))}
+		if f.route != nil {
+			for i, selected := range f.route(event) {
+				if !selected {
+					d.states[i] = deliveryDone
+				}
+			}
+		}
+		f.deliveries[seq] = d
+	}
+	d.writing = true
+	d.reported = false
+	d.err = nil
+	f.mu.Unlock()
+
+	var errs []error
+	for i, t := range f.targets {
+		f.mu.Lock()
+		if d.states[i] != deliveryPending {
+			f.mu.Unlock()
+			continue
+		}
+		if !t.connected {
+			// only best effort targets stay disconnected
+			d.states[i] = deliveryDone
+			t.failed++
+			f.mu.Unlock()
+			continue
+		}
+		d.states[i] = deliveryInFlight
+		t.inFlight++
+		f.mu.Unlock()
+
+		if err := t.Target.Write(ctx, event); err != nil {
+			if !t.BestEffort {
+				errs = append(errs, fmt.Errorf("%s: %w", t.Name, err))
+			}
+			f.settle(i, seq, err)
+			continue
+		}
+		if !t.async {
+			f.delivered(i, seq, nil)
+		}
+	}
+
+	f.mu.Lock()
+	d.writing = false
+	var ackFn AckFunc
+	var failure error
+	switch {
+	case len(errs) > 0:
+		// the replicator retries or gives up on the event as the error
+		// is returned, do not report it a second time
+		d.reported = true
+	case d.err != nil:
+		d.reported = true
+		ackFn, failure = f.ackFn, d.err
+	}
+	f.mu.Unlock()
+
+	if ackFn != nil {
+		ackFn(seq, failure)
+	}
+	f.report(seq)
+	return errors.Join(errs...)
+}
+
+// delivered is called when target i delivered, or failed to deliver, the
+// event with the given sequence.
+func (f *FanOut) delivered(i int, seq uint64, err error) {
+	if ackFn, err := f.settle(i, seq, err); ackFn != nil {
+		ackFn(seq, err)
+	}
+	f.report(seq)
+}
+
+// settle records the outcome of the delivery of seq to target i. When a
+// required target failed and the failure should be passed to the replicator,
+// it returns the AckFunc to call and the error.
+func (f *FanOut) settle(i int, seq uint64, err error) (AckFunc, error) {
+	t := f.targets[i]
+
+	f.mu.Lock()
+	d, ok := f.deliveries[seq]
+	if !ok || d.states[i] != deliveryInFlight {
+		f.mu.Unlock()
+		return nil, nil
+	}
+	t.inFlight--
+
+	switch {
+	case err == nil:
+		d.states[i] = deliveryDone
+		t.delivered++
+	case t.BestEffort:
+		d.states[i] = deliveryDone
+		t.failed++
+	default:
+		d.states[i] = deliveryPending
+		t.failed++
+	}
+
+	var ackFn AckFunc
+	if err != nil && !t.BestEffort {
+		err = fmt.Errorf("%s: %w", t.Name, err)
+		if d.writing {
+			d.err = err
+		} else if !d.reported {
+			d.reported = true
+			ackFn = f.ackFn
+		}
+	}
+	f.mu.Unlock()
+
+	if err != nil {
+		f.logger.Warn("Fan out target failed to deliver event",
+			zap.String("target", t.Name),
+			zap.Bool("best_effort", t.BestEffort),
+			zap.Uint64("sequence", seq),
+			zap.Error(err))
+	}
+	return ackFn, err
+}
+
+// report acknowledges seq once every required target has delivered it, and
+// forgets the delivery once no target has it in flight. Failed deliveries are
+// kept so a retry only writes to the targets which have not delivered the
+// event, until the pipeline gives up on it and calls forget.
+func (f *FanOut) report(seq uint64) {
+	f.mu.Lock()
+	d, ok := f.deliveries[seq]
+	if !ok {
+		f.mu.Unlock()
+		return
+	}
+
+	complete, settled := true, true
+	for i, state := range d.states {
+		if state == deliveryInFlight {
+			settled = false
+		}
+		if state != deliveryDone && !f.targets[i].BestEffort {
+			complete = false
+		}
+	}
+
+	var ackFn AckFunc
+	if complete && !d.reported {
+		d.reported = true
+		ackFn = f.ackFn
+		f.acked++
+	}
+	if settled && complete && !d.writing {
+		delete(f.deliveries, seq)
+	}
+	f.mu.Unlock()
+
+	if ackFn != nil {
+		ackFn(seq, nil)
+	}
+}
+
+// forget drops the delivery of an event the pipeline has given up on.
+func (f *FanOut) forget(seq uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.deliveries, seq)
+}
+
+// Flush flushes the targets whose FlushInterval has elapsed and reconnects
+// best effort targets which are disconnected.
+func (f *FanOut) Flush(ctx context.Context) error {
+	for _, t := range f.targets {
+		f.mu.Lock()
+		connected := t.connected
+		due := time.Since(t.lastFlush) >= t.FlushInterval
+		f.mu.Unlock()
+
+		if !connected {
+			if err := t.Target.Connect(ctx); err != nil {
+				f.logger.Warn("Best effort target failed to reconnect",
+					zap.String("target", t.Name),
+					zap.Error(err))
+				continue
+			}
+			f.logger.Info("Best effort target reconnected", zap.String("target", t.Name))
+			f.mu.Lock()
+			t.connected = true
+			t.lastFlush = time.Now()
+			f.mu.Unlock()
+			continue
+		}
+		if !due {
+			continue
+		}
+
+		err := t.Target.Flush(ctx)
+		if err != nil && !t.BestEffort {
+			return fmt.Errorf("%s: %w", t.Name, err)
+		}
+		if err != nil {
+			f.logger.Warn("Best effort target failed to flush",
+				zap.String("target", t.Name),
+				zap.Error(err))
+		}
+
+		f.mu.Lock()
+		t.lastFlush = time.Now()
+		f.mu.Unlock()
+	}
+	return nil
+}
+
+func (f *FanOut) Type() string {
+	return "fanout"
+}
+
+// FanOutTargetStats are the stats of a single target of a FanOut.
+type FanOutTargetStats struct {
+	Name            string      `json:"name"`
+	BestEffort      bool        `json:"best_effort"`
+	Connected       bool        `json:"connected"`
+	InFlightEvents  int         `json:"in_flight_events"`
+	DeliveredEvents int64       `json:"delivered_events"`
+	FailedEvents    int64       `json:"failed_events"`
+	Stats           TargetStats `json:"stats"`
+}
+
+// Stats aggregates the stats of the targets. The connection is healthy when
+// every required target is healthy. The stats of each target are listed under
+// TargetSpecific["targets"].
+func (f *FanOut) Stats() TargetStats {
+	stats := TargetStats{ConnectionHealthy: true}
+	targets := make([]FanOutTargetStats, 0, len(f.targets))
+
+	for _, t := range f.targets {
+		ts := t.Target.Stats()
+
+		f.mu.Lock()
+		targets = append(targets, FanOutTargetStats{
+			Name:            t.Name,
+			BestEffort:      t.BestEffort,
+			Connected:       t.connected,
+			InFlightEvents:  t.inFlight,
+			DeliveredEvents: t.delivered,
+			FailedEvents:    t.failed,
+			Stats:           ts,
+		})
+		f.mu.Unlock()
+
+		stats.ConnectionRetries += ts.ConnectionRetries
+		stats.EventErrorCount += ts.EventErrorCount
+		stats.WriteErrorCount += ts.WriteErrorCount
+		if ts.LastWriteAt.After(stats.LastWriteAt) {
+			stats.LastWriteAt = ts.LastWriteAt
+		}
+		if t.BestEffort {
+			continue
+		}
+		if !ts.ConnectionHealthy {
+			stats.ConnectionHealthy = false
+		}
+		if ts.LastError != "" && stats.LastError == "" {
+			stats.LastError = fmt.Sprintf("%s: %s", t.Name, ts.LastError)
+		}
+	}
+
+	// an event counts once it reached every required target
+	f.mu.Lock()
+	stats.TotalEvents = f.acked
+	f.mu.Unlock()
+
+	stats.TargetSpecific = map[string]interface{}{
+		"targets": targets,
+	}
+	return stats
+}
@@ -0,0 +1,148 @@
+package replicator
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// brokenTarget fails every write.
+type brokenTarget struct {
+	memoryTarget
+}
+
+func (t *brokenTarget) Write(ctx context.Context, event Event) error {
+	return errors.New("bucket not found")
+}
+
+func TestNewFanOut(t *testing.T) {
+	_, err := NewFanOut(nil, zap.NewNop())
+	assert.Error(t, err)
+
+	_, err = NewFanOut([]FanOutTarget{
+		{Name: "a", Target: &memoryTarget{}, BestEffort: true},
+	}, zap.NewNop())
+	assert.EqualError(t, err, "fan out requires at least one target that is not best effort")
+
+	_, err = NewFanOut([]FanOutTarget{
+		{Name: "a", Target: &memoryTarget{}},
+		{Name: "a", Target: &memoryTarget{}},
+	}, zap.NewNop())
+	assert.EqualError(t, err, `duplicate fan out target name: "a"`)
+}
+
+func TestFanOut(t *testing.T) {
+	run := func(t *testing.T, fanOut *FanOut, opts ...ReplicatorOption) (*Replicator, *memorySource, *memoryCheckpointer) {
+		source := newMemorySource()
+		checkpointer := &memoryCheckpointer{}
+		r, err := New(append([]ReplicatorOption{
+			WithSource(source),
+			WithTarget(fanOut),
+			WithCheckpointer(checkpointer),
+			WithSourceOptions(SourceOptions{CheckpointBatchSize: 1}),
+		}, opts...)...)
+		require.NoError(t, err)
+
+		cancel, done := runReplicator(t, r)
+		t.Cleanup(func() {
+			cancel()
+			<-done
+		})
+		return r, source, checkpointer
+	}
+
+	t.Run("checkpoints once every required target delivered", func(t *testing.T) {
+		sync := &memoryTarget{}
+		async := &asyncTarget{}
+		fanOut, err := NewFanOut([]FanOutTarget{
+			{Name: "s3", Target: sync},
+			{Name: "kafka", Target: async},
+		}, zap.NewNop())
+		require.NoError(t, err)
+
+		r, source, checkpointer := run(t, fanOut)
+		for i := 0; i < 3; i++ {
+			source.events <- testEvent(i)
+		}
+		require.Eventually(t, func() bool {
+			return len(sync.written()) == 3 && len(async.written()) == 3
+		}, time.Second, time.Millisecond)
+
+		time.Sleep(20 * time.Millisecond)
+		assert.Nil(t, checkpointer.last())
+
+		async.release(nil)
+		require.Eventually(t, func() bool {
+			c := checkpointer.last()
+			return c != nil && string(c.Position) == "2"
+		}, time.Second, time.Millisecond)
+
+		stats := r.Stats().Target
+		assert.Equal(t, int64(3), stats.TotalEvents)
+		targets := stats.TargetSpecific["targets"].([]FanOutTargetStats)
+		require.Len(t, targets, 2)
+		assert.Equal(t, "s3", targets[0].Name)
+		assert.Equal(t, int64(3), targets[1].DeliveredEvents)
+	})
+
+	t.Run("best effort targets do not hold back the checkpoint", func(t *testing.T) {
+		required := &memoryTarget{}
+		fanOut, err := NewFanOut([]FanOutTarget{
+			{Name: "kafka", Target: required},
+			{Name: "archive", Target: &brokenTarget{}, BestEffort: true},
+		}, zap.NewNop())
+		require.NoError(t, err)
+
+		r, source, checkpointer := run(t, fanOut)
+		for i := 0; i < 3; i++ {
+			source.events <- testEvent(i)
+		}
+		require.Eventually(t, func() bool {
+			c := checkpointer.last()
+			return c != nil && string(c.Position) == "2"
+		}, time.Second, time.Millisecond)
+
+		assert.Len(t, required.written(), 3)
+		require.Eventually(t, func() bool {
+			targets := r.Stats().Target.TargetSpecific["targets"].([]FanOutTargetStats)
+			return targets[1].FailedEvents == 3
+		}, time.Second, time.Millisecond)
+		assert.Equal(t, StateStreaming, r.State.Current())
+	})
+
+	t.Run("retries only the targets that failed", func(t *testing.T) {
+		sync := &memoryTarget{}
+		async := &asyncTarget{}
+		fanOut, err := NewFanOut([]FanOutTarget{
+			{Name: "s3", Target: sync},
+			{Name: "kafka", Target: async},
+		}, zap.NewNop())
+		require.NoError(t, err)
+
+		r, source, checkpointer := run(t, fanOut,
+			WithErrorOptions(ErrorOptions{Policy: ErrorPolicyFail, MaxRetries: 1}),
+		)
+		source.events <- testEvent(0)
+		require.Eventually(t, func() bool {
+			return len(async.written()) == 1
+		}, time.Second, time.Millisecond)
+
+		async.release(errors.New("broker unavailable"))
+		require.Eventually(t, func() bool {
+			return len(async.written()) == 2
+		}, time.Second, time.Millisecond)
+		assert.Len(t, sync.written(), 1)
+		assert.Nil(t, checkpointer.last())
+
+		async.release(nil)
+		require.Eventually(t, func() bool {
+			return checkpointer.last() != nil
+		}, time.Second, time.Millisecond)
+		assert.Equal(t, int64(1), r.Stats().Target.RetryCount)
+	})
+}
@@ -0,0 +1,123 @@
+package replicator
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// Filter decides which events are replicated. Events a filter does not match
+// are not transformed or written, but their position is still checkpointed.
+type Filter interface {
+	Match(event Event) (bool, error)
+}
+
+// FilterFunc adapts a function to the Filter interface.
+type FilterFunc func(event Event) (bool, error)
+
+func (f FilterFunc) Match(event Event) (bool, error) {
+	return f(event)
+}
+
+// ExprFilter matches events with a boolean expression in the expr language
+// (https://expr-lang.org), evaluated against the payload:
+//
+//	before, after   row images; missing fields, and the images of inserts and
+//	                deletes, evaluate to nil
+//	op              "c", "u", "d" or "r"
+//	source          db, schema, table, connector, name, snapshot, lsn, txId
+//	ts_ms           event timestamp
+//	changed()       sorted names of the fields that differ between before and
+//	                after
+//
+// For example:
+//
+//	after.tenant_id == 42
+//	op != "d"
+//	!(op == "u" && changed() == ["updated_at"])
+type ExprFilter struct {
+	expression string
+	program    *vm.Program
+}
+
+func NewExprFilter(expression string) (*ExprFilter, error) {
+	program, err := expr.Compile(expression,
+		expr.Env(filterEnv(Payload{})),
+		expr.AsBool(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("filter %q: %w", expression, err)
+	}
+	return &ExprFilter{
+		expression: expression,
+		program:    program,
+	}, nil
+}
+
+func (f *ExprFilter) Match(event Event) (bool, error) {
+	out, err := expr.Run(f.program, filterEnv(event.Payload))
+	if err != nil {
+		return false, fmt.Errorf("filter %q: %w", f.expression, err)
+	}
+	match, ok := out.(bool)
+	if !ok {
+		return false, fmt.Errorf("filter %q: expected bool, got %T", f.expression, out)
+	}
+	return match, nil
+}
+
+func (f *ExprFilter) String() string {
+	return f.expression
+}
+
+func filterEnv(payload Payload) map[string]interface{} {
+	before := payload.Before
+	if before == nil {
+		before = map[string]interface{}{}
+	}
+	after := payload.After
+	if after == nil {
+		after = map[string]interface{}{}
+	}
+
+	return map[string]interface{}{
+		"before": before,
+		"after":  after,
+		"op":     string(payload.Op),
+		"ts_ms":  payload.TsMs,
+		"source": map[string]interface{}{
+			"db":        payload.Source.Db,
+			"schema":    payload.Source.Schema,
+			"table":     payload.Source.Table,
+			"connector": payload.Source.Connector,
+			"name":      payload.Source.Name,
+			"snapshot":  payload.Source.Snapshot,
+			"lsn":       payload.Source.Lsn,
+			"txId":      payload.Source.TxId,
+		},
+		"changed": func() []string {
+			return changedFields(payload.Before, payload.After)
+		},
+	}
+}
+
+// changedFields returns the sorted names of the fields whose value differs
+// between the before and after images.
+func changedFields(before, after map[string]interface{}) []string {
+	changed := []string{}
+	for k, v := range after {
+		if old, ok := before[k]; !ok || !reflect.DeepEqual(old, v) {
+			changed = append(changed, k)
+		}
+	}
+	for k := range before {
+		if _, ok := after[k]; !ok {
+			changed = append(changed, k)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
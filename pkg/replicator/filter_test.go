@@ -0,0 +1,93 @@
+package replicator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExprFilter(t *testing.T) {
+	insert := Event{Payload: Payload{
+		Op:     OpCreate,
+		Source: EventSource{Db: "test", Schema: "public", Table: "orders"},
+		After:  map[string]interface{}{"id": int64(1), "tenant_id": int32(42)},
+	}}
+	touch := Event{Payload: Payload{
+		Op:     OpUpdate,
+		Source: EventSource{Db: "test", Schema: "public", Table: "orders"},
+		Before: map[string]interface{}{"id": int64(1), "status": "new", "updated_at": "2025-01-01"},
+		After:  map[string]interface{}{"id": int64(1), "status": "new", "updated_at": "2025-01-02"},
+	}}
+	remove := Event{Payload: Payload{
+		Op:     OpDelete,
+		Source: EventSource{Db: "test", Schema: "audit", Table: "log"},
+		Before: map[string]interface{}{"id": int64(1)},
+	}}
+
+	testCases := []struct {
+		expression string
+		event      Event
+		match      bool
+	}{
+		{expression: "after.tenant_id == 42", event: insert, match: true},
+		{expression: "after.tenant_id == 42", event: remove, match: false},
+		{expression: `op != "d"`, event: remove, match: false},
+		{expression: `source.schema == "audit" && source.table startsWith "lo"`, event: remove, match: true},
+		{expression: `!(op == "u" && changed() == ["updated_at"])`, event: touch, match: false},
+		{expression: `!(op == "u" && changed() == ["updated_at"])`, event: insert, match: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.expression, func(t *testing.T) {
+			f, err := NewExprFilter(tc.expression)
+			require.NoError(t, err)
+
+			match, err := f.Match(tc.event)
+			require.NoError(t, err)
+			assert.Equal(t, tc.match, match)
+		})
+	}
+
+	_, err := NewExprFilter("after.tenant_id +")
+	assert.Error(t, err)
+	_, err = NewExprFilter("op")
+	assert.Error(t, err, "expression must be boolean")
+
+	f, err := NewExprFilter("after.tenant_id")
+	require.NoError(t, err)
+	_, err = f.Match(insert)
+	assert.Error(t, err)
+}
+
+func TestReplicatorFilters(t *testing.T) {
+	source := newMemorySource()
+	target := &memoryTarget{}
+	checkpointer := &memoryCheckpointer{}
+	even, err := NewExprFilter("after.id % 2 == 0")
+	require.NoError(t, err)
+	r, err := New(
+		WithSource(source),
+		WithTarget(target),
+		WithCheckpointer(checkpointer),
+		WithSourceOptions(SourceOptions{CheckpointBatchSize: 100}),
+		WithFilters(even),
+	)
+	require.NoError(t, err)
+
+	cancel, done := runReplicator(t, r)
+	for i := 0; i < 4; i++ {
+		source.events <- testEvent(i)
+	}
+	require.Eventually(t, func() bool {
+		return r.Stats().Replicator.FilteredEvents == 2
+	}, time.Second, time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-done)
+
+	assert.Len(t, target.written(), 2)
+	// the filtered event at the end of the stream is still checkpointed
+	assert.Equal(t, "3", string(checkpointer.last().Position))
+}
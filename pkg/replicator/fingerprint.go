@@ -0,0 +1,122 @@
+package replicator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// CheckpointVersion is the format version of the checkpoints saved by this
+// replicator. Checkpoints saved before checkpoints were versioned have
+// version 0.
+const CheckpointVersion = 1
+
+var (
+	// ErrSourceMismatch is returned by Run when the checkpoint of the
+	// replicator was saved while reading from a different source
+	ErrSourceMismatch = errors.New("checkpoint was saved for a different source")
+
+	// ErrCheckpointVersion is returned by Run when the checkpoint was saved
+	// in a newer format than this replicator understands
+	ErrCheckpointVersion = errors.New("unsupported checkpoint version")
+)
+
+// SourceFingerprint identifies the source a checkpoint was saved for, so a
+// replicator ID reused against another database does not resume from a
+// position which means nothing there. Empty fields are unknown and match
+// anything.
+type SourceFingerprint struct {
+	// Connector is the type of the source, such as postgresql or mongodb
+	Connector string `json:"connector"`
+
+	// System identifies the server: the system identifier of a Postgres
+	// cluster or the replica set name of a MongoDB deployment
+	System string `json:"system,omitempty"`
+
+	Database string `json:"database,omitempty"`
+
+	// Stream is the Postgres replication slot or MongoDB collection
+	Stream string `json:"stream,omitempty"`
+}
+
+func (f SourceFingerprint) String() string {
+	return fmt.Sprintf("%s system=%q database=%q stream=%q", f.Connector, f.System, f.Database, f.Stream)
+}
+
+// mismatches lists the fields which are known in both fingerprints and
+// differ.
+func (f SourceFingerprint) mismatches(other SourceFingerprint) []string {
+	var fields []string
+	for _, field := range []struct{ name, a, b string }{
+		{"connector", f.Connector, other.Connector},
+		{"system", f.System, other.System},
+		{"database", f.Database, other.Database},
+		{"stream", f.Stream, other.Stream},
+	} {
+		if field.a != "" && field.b != "" && field.a != field.b {
+			fields = append(fields, fmt.Sprintf("%s %q != %q", field.name, field.a, field.b))
+		}
+	}
+	return fields
+}
+
+// Fingerprinter is implemented by sources which can identify the server and
+// stream they read from. Fingerprint is called before the source connects,
+// so it may need a connection of its own.
+type Fingerprinter interface {
+	Fingerprint(ctx context.Context) (*SourceFingerprint, error)
+}
+
+// sourceFingerprint identifies the source of the replicator. Sources which
+// are not Fingerprinters are only identified by their type, if they have
+// one.
+func (r *Replicator) sourceFingerprint(ctx context.Context) (*SourceFingerprint, error) {
+	if f, ok := r.Source.(Fingerprinter); ok {
+		fingerprint, err := f.Fingerprint(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fingerprint source: %w", err)
+		}
+		return fingerprint, nil
+	}
+	if t := typeOf(r.Source); t != "unknown" {
+		return &SourceFingerprint{Connector: t}, nil
+	}
+	return nil, nil
+}
+
+// checkSource refuses to resume from a checkpoint saved for another source
+// or in a newer format, unless SourceOptions.AllowSourceMismatch is set.
+// Checkpoints without a fingerprint are accepted; the next checkpoint
+// records it.
+func (r *Replicator) checkSource(checkpoint *Checkpoint, fingerprint *SourceFingerprint) error {
+	if checkpoint == nil {
+		return nil
+	}
+
+	var err error
+	switch {
+	case checkpoint.Version > CheckpointVersion:
+		err = fmt.Errorf("%w: checkpoint of %s has version %d, this replicator supports up to %d",
+			ErrCheckpointVersion, checkpoint.ReplicatorID, checkpoint.Version, CheckpointVersion)
+	case checkpoint.Source == nil || fingerprint == nil:
+		r.logger.Info("Checkpoint source not verified",
+			zap.String("replicator_id", r.ID),
+			zap.Bool("checkpoint_fingerprinted", checkpoint.Source != nil),
+			zap.Bool("source_fingerprinted", fingerprint != nil))
+		return nil
+	default:
+		if fields := checkpoint.Source.mismatches(*fingerprint); len(fields) > 0 {
+			err = fmt.Errorf("%w: checkpoint of %s was saved for %s, the source is %s (%s)",
+				ErrSourceMismatch, checkpoint.ReplicatorID, checkpoint.Source, fingerprint, strings.Join(fields, ", "))
+		}
+	}
+
+	if err != nil && r.SourceOptions.AllowSourceMismatch {
+		r.logger.Warn("Resuming from a mismatched checkpoint", zap.String("replicator_id", r.ID), zap.Error(err))
+		return nil
+	}
+	return err
+}
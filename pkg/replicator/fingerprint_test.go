@@ -0,0 +1,110 @@
+package replicator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fingerprintedSource is a memory source reading from a Postgres slot.
+type fingerprintedSource struct {
+	*memorySource
+	fingerprint SourceFingerprint
+}
+
+func (s fingerprintedSource) Fingerprint(ctx context.Context) (*SourceFingerprint, error) {
+	fingerprint := s.fingerprint
+	return &fingerprint, nil
+}
+
+func TestReplicatorSourceFingerprint(t *testing.T) {
+	orders := SourceFingerprint{Connector: "postgresql", System: "7301", Database: "shop", Stream: "orders"}
+
+	newReplicator := func(checkpoint *Checkpoint, opts SourceOptions) (*Replicator, *memorySource, *memoryCheckpointer) {
+		source := newMemorySource()
+		checkpointer := &memoryCheckpointer{}
+		if checkpoint != nil {
+			checkpointer.saved = append(checkpointer.saved, checkpoint)
+		}
+		opts.CheckpointBatchSize = 1
+		r, err := New(
+			WithID("orders"),
+			WithSource(fingerprintedSource{source, orders}),
+			WithTarget(&memoryTarget{}),
+			WithCheckpointer(checkpointer),
+			WithSourceOptions(opts),
+		)
+		require.NoError(t, err)
+		return r, source, checkpointer
+	}
+
+	t.Run("records the source in checkpoints", func(t *testing.T) {
+		// checkpoints saved before fingerprinting are accepted
+		r, source, checkpointer := newReplicator(&Checkpoint{ReplicatorID: "orders", Position: []byte("1")}, SourceOptions{})
+		cancel, done := runReplicator(t, r)
+		defer cancel()
+
+		source.events <- testEvent(2)
+		require.Eventually(t, func() bool {
+			return checkpointer.count() == 2
+		}, time.Second, time.Millisecond)
+		assert.Equal(t, &orders, checkpointer.last().Source)
+		assert.Equal(t, CheckpointVersion, checkpointer.last().Version)
+
+		cancel()
+		require.NoError(t, <-done)
+	})
+
+	t.Run("records the source of checkpoints set before the first run", func(t *testing.T) {
+		r, _, checkpointer := newReplicator(nil, SourceOptions{})
+
+		_, err := r.SetCheckpoint(context.Background(), "5")
+		require.NoError(t, err)
+		assert.Equal(t, &orders, checkpointer.last().Source)
+	})
+
+	t.Run("refuses a checkpoint of another source", func(t *testing.T) {
+		other := orders
+		other.System = "9999"
+		r, source, _ := newReplicator(&Checkpoint{ReplicatorID: "orders", Position: []byte("1"), Source: &other}, SourceOptions{})
+
+		err := r.Run(context.Background())
+		assert.ErrorIs(t, err, ErrSourceMismatch)
+		assert.ErrorContains(t, err, `system "9999" != "7301"`)
+		assert.Equal(t, StateError, r.State.Current())
+		assert.Zero(t, source.connects)
+	})
+
+	t.Run("refuses a checkpoint in a newer format", func(t *testing.T) {
+		r, _, _ := newReplicator(&Checkpoint{ReplicatorID: "orders", Position: []byte("1"), Version: CheckpointVersion + 1}, SourceOptions{})
+		assert.ErrorIs(t, r.Run(context.Background()), ErrCheckpointVersion)
+	})
+
+	t.Run("resumes from a mismatched checkpoint when allowed", func(t *testing.T) {
+		other := orders
+		other.Stream = "invoices"
+		r, source, _ := newReplicator(
+			&Checkpoint{ReplicatorID: "orders", Position: []byte("1"), Source: &other},
+			SourceOptions{AllowSourceMismatch: true},
+		)
+		cancel, done := runReplicator(t, r)
+		cancel()
+		require.NoError(t, <-done)
+
+		source.mu.Lock()
+		defer source.mu.Unlock()
+		assert.Equal(t, "1", string(source.checkpoints[0].Position))
+	})
+}
+
+func TestSourceFingerprintMismatches(t *testing.T) {
+	f := SourceFingerprint{Connector: "mongodb", System: "rs0", Database: "shop", Stream: "orders"}
+	assert.Empty(t, f.mismatches(f))
+	// unknown fields match anything
+	assert.Empty(t, f.mismatches(SourceFingerprint{Connector: "mongodb", Database: "shop"}))
+	assert.Equal(t, []string{`connector "mongodb" != "postgresql"`, `stream "orders" != "slot"`},
+		f.mismatches(SourceFingerprint{Connector: "postgresql", Stream: "slot"}))
+}
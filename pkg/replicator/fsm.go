@@ -21,8 +21,12 @@ const (
 	StatePaused       State = "paused"
 	StateStopped      State = "stopped"
 	StateReconnecting State = "reconnecting"
-	// StateDraining State = "draining"
-	StateError State = "error"
+	StateDraining     State = "draining"
+	StateError        State = "error"
+
+	// StateStandby waits for the lease of the replicator, held by another
+	// instance
+	StateStandby State = "standby"
 )
 
 type FSM struct {
@@ -56,6 +60,7 @@ func NewFSM(opts ...FSMOption) *FSM {
 			StateCreated: {
 				StateConnecting: {},
 				StateStopped:    {}, // Can stop before starting
+				StateStandby:    {}, // Wait for the lease
 			},
 			StateConnecting: {
 				StateStreaming: {},
@@ -63,27 +68,45 @@ func NewFSM(opts ...FSMOption) *FSM {
 				StateStopped:   {}, // Can stop during connection
 			},
 			StateStreaming: {
+				StateConnecting:   {}, // Restart
 				StatePaused:       {},
-				StateStopped:      {}, // Graceful stop
+				StateDraining:     {}, // Graceful stop
+				StateStopped:      {},
 				StateReconnecting: {},
 				StateError:        {},
+				StateStandby:      {}, // Lease lost
 			},
 			StatePaused: {
-				StateStreaming: {}, // Resume
-				StateStopped:   {}, // Stop while paused
-				StateError:     {},
+				StateStreaming:    {}, // Resume
+				StateConnecting:   {}, // Restart, or resume from a moved checkpoint
+				StateDraining:     {}, // Graceful stop while paused
+				StateStopped:      {}, // Stop while paused
+				StateReconnecting: {}, // Target failed while draining the queue
+				StateError:        {},
+				StateStandby:      {}, // Lease lost while paused
 			},
 			StateReconnecting: {
 				StateStreaming: {},
+				StatePaused:    {}, // Reconnected while paused
 				StateError:     {},
 				StateStopped:   {}, // Give up reconnecting
 			},
+			StateDraining: {
+				StateStopped: {}, // Drained
+				StateError:   {},
+			},
 			StateError: {
 				StateConnecting: {}, // Retry connection
 				StateStopped:    {}, // Give up and stop
+				StateStandby:    {}, // Run again, waiting for the lease
 			},
 			StateStopped: {
 				StateConnecting: {}, // Restart
+				StateStandby:    {}, // Restart, waiting for the lease
+			},
+			StateStandby: {
+				StateConnecting: {}, // Lease acquired
+				StateStopped:    {}, // Stop while waiting
 			},
 		},
 	}
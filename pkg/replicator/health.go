@@ -0,0 +1,184 @@
+package replicator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+// HealthOptions configure the liveness and readiness endpoints.
+type HealthOptions struct {
+	// StaleAfter is how long a replicator may hold undelivered events
+	// without delivering or checkpointing any before it is reported stale,
+	// and not live. 0 disables the check.
+	StaleAfter time.Duration
+}
+
+// WithHealthOptions configures the liveness and readiness endpoints.
+func WithHealthOptions(opts HealthOptions) ServerOption {
+	return func(s *Server) {
+		s.health = opts
+	}
+}
+
+// ReplicatorHealth is the health of a replicator as reported by /healthz and
+// /readyz. Reasons explains why a replicator is not live or not ready.
+type ReplicatorHealth struct {
+	ID             string    `json:"id"`
+	State          State     `json:"state"`
+	Live           bool      `json:"live"`
+	Ready          bool      `json:"ready"`
+	SourceHealthy  bool      `json:"source_healthy"`
+	TargetHealthy  bool      `json:"target_healthy"`
+	Stale          bool      `json:"stale"`
+	LastProgressAt time.Time `json:"last_progress_at"`
+	Reasons        []string  `json:"reasons,omitempty"`
+}
+
+type HealthReport struct {
+	Status      string             `json:"status"`
+	Replicators []ReplicatorHealth `json:"replicators"`
+}
+
+// health derives the health of a replicator from its state and stats.
+//
+// A replicator is live unless it is stale: it is running and holds queued or
+// in-flight events, so the source has changes, but has neither delivered nor
+// checkpointed any for StaleAfter, as when its run loop is stuck. Restarting
+// the process is the remedy. A replicator which failed or was stopped is
+// still live, since restarting the process would also restart the healthy
+// replicators it runs; it is not ready. A replicator is ready when it is
+// live, streaming or paused, and both its source and target are connected.
+func (o HealthOptions) health(id string, stats Stats, state State, now time.Time) ReplicatorHealth {
+	h := ReplicatorHealth{
+		ID:            id,
+		State:         state,
+		SourceHealthy: stats.Source.ConnectionHealthy,
+		TargetHealthy: stats.Target.ConnectionHealthy,
+	}
+
+	rs := stats.Replicator
+	h.LastProgressAt = rs.StartedAt
+	for _, t := range []time.Time{rs.Lag.LastAckAt, rs.LastCheckpointAt} {
+		if t.After(h.LastProgressAt) {
+			h.LastProgressAt = t
+		}
+	}
+
+	pending := rs.QueueDepth + rs.InFlightEvents
+	if o.StaleAfter > 0 && pending > 0 && !h.LastProgressAt.IsZero() && running(state) {
+		if idle := now.Sub(h.LastProgressAt); idle > o.StaleAfter {
+			h.Stale = true
+			h.Reasons = append(h.Reasons, fmt.Sprintf("%d events pending with no progress for %s", pending, idle.Round(time.Second)))
+		}
+	}
+
+	switch state {
+	case StateError:
+		// the probes are unauthenticated, the error itself is only shown by
+		// the API
+		h.Reasons = append(h.Reasons, "replicator in error state")
+	case StateStreaming, StatePaused:
+	default:
+		h.Reasons = append(h.Reasons, fmt.Sprintf("replicator is %s", state))
+	}
+	if !h.SourceHealthy {
+		h.Reasons = append(h.Reasons, "source is not connected")
+	}
+	if !h.TargetHealthy {
+		h.Reasons = append(h.Reasons, "target is not connected")
+	}
+
+	h.Live = !h.Stale
+	h.Ready = h.Live && len(h.Reasons) == 0
+	return h
+}
+
+// running reports whether a replicator in state is expected to make
+// progress.
+func running(state State) bool {
+	switch state {
+	case StateCreated, StateStopped, StateError:
+		return false
+	}
+	return true
+}
+
+func (s *Server) healthReport(now time.Time) []ReplicatorHealth {
+	s.mu.RLock()
+	replicators := make([]*Replicator, 0, len(s.replicators))
+	for _, rep := range s.replicators {
+		replicators = append(replicators, rep)
+	}
+	s.mu.RUnlock()
+
+	report := make([]ReplicatorHealth, 0, len(replicators))
+	for _, rep := range replicators {
+		report = append(report, s.health.health(rep.ID, rep.Stats(), rep.State.Current(), now))
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].ID < report[j].ID })
+	return report
+}
+
+// healthz reports whether every replicator is live. A failing liveness probe
+// restarts the process.
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	s.writeHealth(w, func(h ReplicatorHealth) bool { return h.Live })
+}
+
+// readyz reports whether every replicator is connected and replicating.
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	s.writeHealth(w, func(h ReplicatorHealth) bool { return h.Ready })
+}
+
+func (s *Server) writeHealth(w http.ResponseWriter, ok func(ReplicatorHealth) bool) {
+	report := HealthReport{
+		Status:      "ok",
+		Replicators: s.healthReport(time.Now()),
+	}
+	status := http.StatusOK
+	for _, h := range report.Replicators {
+		if !ok(h) {
+			report.Status = "unavailable"
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
+
+// HealthRoutes serves only the health endpoints, for probes which cannot
+// authenticate against the API, such as when it requires client
+// certificates.
+func (s *Server) HealthRoutes() chi.Router {
+	r := chi.NewRouter()
+	r.Get("/healthz", s.healthz)
+	r.Get("/readyz", s.readyz)
+	return r
+}
+
+// StartHealth serves the health endpoints over plain HTTP on addr until ctx
+// is cancelled.
+func (s *Server) StartHealth(ctx context.Context, addr string) error {
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: s.HealthRoutes(),
+	}
+
+	s.logger.Info("starting health server", zap.String("addr", addr))
+
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+
+	return srv.ListenAndServe()
+}
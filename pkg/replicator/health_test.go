@@ -0,0 +1,227 @@
+package replicator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestHealth(t *testing.T) {
+	now := time.Now()
+	connected := func(rs ReplicatorStats) Stats {
+		return Stats{
+			Source:     SourceStats{ConnectionHealthy: true},
+			Target:     TargetStats{ConnectionHealthy: true},
+			Replicator: rs,
+		}
+	}
+	opts := HealthOptions{StaleAfter: time.Minute}
+
+	tests := []struct {
+		name  string
+		opts  HealthOptions
+		stats Stats
+		state State
+		live  bool
+		ready bool
+		stale bool
+	}{
+		{
+			name:  "streaming",
+			opts:  opts,
+			stats: connected(ReplicatorStats{StartedAt: now.Add(-time.Hour)}),
+			state: StateStreaming,
+			live:  true,
+			ready: true,
+		},
+		{
+			name:  "paused",
+			opts:  opts,
+			stats: connected(ReplicatorStats{StartedAt: now}),
+			state: StatePaused,
+			live:  true,
+			ready: true,
+		},
+		{
+			name:  "source disconnected",
+			opts:  opts,
+			stats: Stats{Target: TargetStats{ConnectionHealthy: true}},
+			state: StateReconnecting,
+			live:  true,
+		},
+		{
+			name:  "standby",
+			opts:  opts,
+			stats: Stats{},
+			state: StateStandby,
+			live:  true,
+		},
+		{
+			name:  "failed",
+			opts:  opts,
+			stats: connected(ReplicatorStats{LastError: "boom"}),
+			state: StateError,
+			live:  true,
+		},
+		{
+			name: "failed with pending events",
+			opts: opts,
+			stats: connected(ReplicatorStats{
+				StartedAt:  now.Add(-time.Hour),
+				QueueDepth: 10,
+			}),
+			state: StateError,
+			live:  true,
+		},
+		{
+			name: "stale",
+			opts: opts,
+			stats: connected(ReplicatorStats{
+				StartedAt:        now.Add(-time.Hour),
+				LastCheckpointAt: now.Add(-2 * time.Minute),
+				Lag:              LagStats{LastAckAt: now.Add(-3 * time.Minute)},
+				InFlightEvents:   5,
+			}),
+			state: StateStreaming,
+			stale: true,
+		},
+		{
+			name: "idle",
+			opts: opts,
+			stats: connected(ReplicatorStats{
+				StartedAt:               now.Add(-time.Hour),
+				PendingCheckpointEvents: 3,
+			}),
+			state: StateStreaming,
+			live:  true,
+			ready: true,
+		},
+		{
+			name: "staleness disabled",
+			stats: connected(ReplicatorStats{
+				StartedAt:  now.Add(-time.Hour),
+				QueueDepth: 10,
+			}),
+			state: StateStreaming,
+			live:  true,
+			ready: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := tt.opts.health("orders", tt.stats, tt.state, now)
+			assert.Equal(t, tt.live, h.Live, h.Reasons)
+			assert.Equal(t, tt.ready, h.Ready, h.Reasons)
+			assert.Equal(t, tt.stale, h.Stale)
+			if !tt.ready {
+				assert.NotEmpty(t, h.Reasons)
+			}
+			assert.NotContains(t, h.Reasons, "boom")
+		})
+	}
+}
+
+// connectedSource and connectedTarget report healthy connections.
+type connectedSource struct{ *memorySource }
+
+func (connectedSource) Stats() SourceStats { return SourceStats{ConnectionHealthy: true} }
+
+type connectedTarget struct{ *memoryTarget }
+
+func (connectedTarget) Stats() TargetStats { return TargetStats{ConnectionHealthy: true} }
+
+func TestServerHealth(t *testing.T) {
+	r, err := New(
+		WithID("orders"),
+		WithSource(connectedSource{newMemorySource()}),
+		WithTarget(connectedTarget{&memoryTarget{}}),
+	)
+	require.NoError(t, err)
+
+	s := NewServer(zap.NewNop(), WithCredentials(Credential{Name: "a", Role: RoleOperator, Token: "t"}))
+	s.RegisterReplicator(r)
+	srv := httptest.NewServer(s.Routes())
+	defer srv.Close()
+
+	get := func(path string) (int, HealthReport) {
+		resp, err := http.Get(srv.URL + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var report HealthReport
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+		return resp.StatusCode, report
+	}
+
+	// created but not running yet
+	status, report := get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	require.Len(t, report.Replicators, 1)
+	assert.Equal(t, []string{"replicator is created"}, report.Replicators[0].Reasons)
+	status, _ = get("/healthz")
+	assert.Equal(t, http.StatusOK, status)
+
+	cancel, done := runReplicator(t, r)
+	defer func() {
+		cancel()
+		require.NoError(t, <-done)
+	}()
+
+	status, report = get("/readyz")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "ok", report.Status)
+	assert.True(t, report.Replicators[0].Ready)
+}
+
+func TestServerHealthFailedReplicator(t *testing.T) {
+	healthy, err := New(
+		WithID("orders"),
+		WithSource(connectedSource{newMemorySource()}),
+		WithTarget(connectedTarget{&memoryTarget{}}),
+	)
+	require.NoError(t, err)
+	failed, err := New(
+		WithID("invoices"),
+		WithSource(connectedSource{newMemorySource()}),
+		WithTarget(connectedTarget{&memoryTarget{}}),
+	)
+	require.NoError(t, err)
+	require.NoError(t, failed.State.Transition(StateConnecting))
+	require.NoError(t, failed.State.Transition(StateError))
+
+	s := NewServer(zap.NewNop())
+	s.RegisterReplicator(healthy)
+	s.RegisterReplicator(failed)
+	srv := httptest.NewServer(s.HealthRoutes())
+	defer srv.Close()
+
+	cancel, done := runReplicator(t, healthy)
+	defer func() {
+		cancel()
+		require.NoError(t, <-done)
+	}()
+
+	// the failed replicator does not restart the process, it is only not
+	// ready
+	resp, err := http.Get(srv.URL + "/healthz")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = http.Get(srv.URL + "/readyz")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	var report HealthReport
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+	require.Len(t, report.Replicators, 2)
+	assert.Equal(t, "invoices", report.Replicators[0].ID)
+	assert.False(t, report.Replicators[0].Ready)
+	assert.True(t, report.Replicators[1].Ready)
+}
@@ -0,0 +1,29 @@
+package replicator
+
+import "time"
+
+// observeLag records the lag of an event the target acknowledged at ackedAt.
+// Lags are clamped at zero, as the commit time comes from the database clock.
+func (r *Replicator) observeLag(event Event, ackedAt time.Time) {
+	lag := LagStats{
+		ReadToAckMs: max(ackedAt.Sub(event.readAt), 0).Milliseconds(),
+		LastAckAt:   ackedAt,
+	}
+	commit := event.CommitTime()
+	if !commit.IsZero() {
+		lag.CommitToReadMs = max(event.readAt.Sub(commit), 0).Milliseconds()
+		lag.CommitToAckMs = max(ackedAt.Sub(commit), 0).Milliseconds()
+		lag.LastCommitAt = commit
+	}
+	r.metrics.observeLag(event, commit, ackedAt)
+
+	table := tableLabel(event)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stats.Replicator.Lag = lag
+	if r.stats.Replicator.TableLag == nil {
+		r.stats.Replicator.TableLag = make(map[string]LagStats)
+	}
+	r.stats.Replicator.TableLag[table] = lag
+}
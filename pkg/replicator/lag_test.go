@@ -0,0 +1,49 @@
+package replicator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplicatorLag(t *testing.T) {
+	source := newMemorySource()
+	target := &memoryTarget{}
+	r, err := New(WithID("orders"), WithSource(source), WithTarget(target))
+	require.NoError(t, err)
+
+	cancel, done := runReplicator(t, r)
+	defer func() {
+		cancel()
+		require.NoError(t, <-done)
+	}()
+
+	// the source does not report commit times
+	source.events <- testEvent(1)
+	require.Eventually(t, func() bool {
+		return !r.Stats().Replicator.Lag.LastAckAt.IsZero()
+	}, time.Second, time.Millisecond)
+
+	lag := r.Stats().Replicator.Lag
+	assert.Zero(t, lag.CommitToReadMs)
+	assert.Zero(t, lag.CommitToAckMs)
+	assert.True(t, lag.LastCommitAt.IsZero())
+
+	committed := time.Now().Add(-2 * time.Second)
+	event := testEvent(2)
+	event.Payload.Source.TsMs = committed.UnixMilli()
+	event.Payload.Source.Schema = "sales"
+	source.events <- event
+	require.Eventually(t, func() bool {
+		return len(r.Stats().Replicator.TableLag) == 2
+	}, time.Second, time.Millisecond)
+
+	stats := r.Stats().Replicator
+	assert.GreaterOrEqual(t, stats.Lag.CommitToReadMs, int64(2000))
+	assert.GreaterOrEqual(t, stats.Lag.CommitToAckMs, stats.Lag.CommitToReadMs)
+	assert.Equal(t, committed.UnixMilli(), stats.Lag.LastCommitAt.UnixMilli())
+	assert.Equal(t, stats.Lag, stats.TableLag["sales.users"])
+	assert.Zero(t, stats.TableLag["public.users"].CommitToAckMs)
+}
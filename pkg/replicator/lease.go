@@ -0,0 +1,214 @@
+package replicator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ErrLeaseHeld is returned by a Leaser when another instance holds the lease
+// of a replicator.
+var ErrLeaseHeld = errors.New("lease is held by another instance")
+
+// ErrLeaseLost is returned when a replicator loses its lease while running.
+// The replicator moves to the standby state and waits to acquire it again.
+var ErrLeaseLost = errors.New("lease lost")
+
+// leaseReleaseTimeout bounds releasing a lease once a replicator stops
+// running.
+const leaseReleaseTimeout = 10 * time.Second
+
+// Leaser grants exclusive ownership of a replicator ID, so two instances
+// cannot stream the same source and overwrite each other's checkpoints.
+type Leaser interface {
+	// Acquire takes the lease of a replicator for the given holder, or
+	// returns ErrLeaseHeld if another holder has it
+	Acquire(ctx context.Context, replicatorID, holder string) (Lease, error)
+}
+
+// Lease is the ownership of a replicator ID granted by a Leaser.
+type Lease interface {
+	// Epoch increases every time the lease is acquired. Checkpoints are
+	// saved with it, so checkpointers can reject checkpoints of a previous
+	// holder.
+	Epoch() uint64
+
+	// Lost is closed once the lease can no longer be guaranteed, such as
+	// when it could not be renewed before it expired
+	Lost() <-chan struct{}
+
+	// Release gives up the lease so another instance can acquire it
+	Release(ctx context.Context) error
+}
+
+type LeaseOptions struct {
+	// lease.holder
+	// identifies this instance to other instances waiting for the lease.
+	// Defaults to hostname:pid.
+	Holder string
+
+	// lease.retry_interval
+	// how often a standby replicator tries to acquire the lease
+	RetryInterval time.Duration
+}
+
+func WithLeaser(leaser Leaser) ReplicatorOption {
+	return func(r *Replicator) {
+		r.Leaser = leaser
+	}
+}
+
+func WithLeaseOptions(leaseOptions LeaseOptions) ReplicatorOption {
+	return func(r *Replicator) {
+		r.LeaseOptions = leaseOptions
+	}
+}
+
+// defaultLeaseHolder identifies this process as hostname:pid.
+func defaultLeaseHolder() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	return fmt.Sprintf("%s:%d", hostname, os.Getpid())
+}
+
+// acquireLease waits in the standby state until the replicator acquires its
+// lease. It returns a nil lease once the replicator has been stopped while
+// waiting.
+func (r *Replicator) acquireLease(ctx context.Context) (Lease, error) {
+	if r.State.Current() != StateStandby {
+		if err := r.State.Transition(StateStandby); err != nil {
+			return nil, err
+		}
+	}
+
+	for {
+		lease, err := r.Leaser.Acquire(ctx, r.ID, r.LeaseOptions.Holder)
+		if err == nil {
+			r.mu.Lock()
+			r.lease = lease
+			r.stats.Replicator.LeaseEpoch = lease.Epoch()
+			r.mu.Unlock()
+
+			r.logger.Info("Lease acquired",
+				zap.String("replicator_id", r.ID),
+				zap.String("holder", r.LeaseOptions.Holder),
+				zap.Uint64("epoch", lease.Epoch()))
+			return lease, nil
+		}
+		if ctx.Err() != nil {
+			r.State.Transition(StateStopped)
+			return nil, nil
+		}
+
+		if errors.Is(err, ErrLeaseHeld) {
+			r.logger.Info("Waiting for lease", zap.String("replicator_id", r.ID), zap.Error(err))
+		} else {
+			r.logger.Warn("Failed to acquire lease", zap.String("replicator_id", r.ID), zap.Error(err))
+			r.mu.Lock()
+			r.stats.Replicator.LastError = err.Error()
+			r.mu.Unlock()
+		}
+
+		timer := time.NewTimer(r.LeaseOptions.RetryInterval)
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				r.State.Transition(StateStopped)
+				return nil, nil
+
+			case signal := <-r.controlChan:
+				r.mu.Lock()
+				r.stats.Replicator.SignalsReceived++
+				r.mu.Unlock()
+
+				if signal == SignalStop {
+					timer.Stop()
+					r.logger.Info("Stopping replicator in standby")
+					r.State.Transition(StateStopped)
+					return nil, nil
+				}
+				r.logger.Warn("Ignoring signal in standby", zap.String("signal", string(signal)))
+
+			case <-timer.C:
+				break wait
+			}
+		}
+	}
+}
+
+// releaseLease releases the lease once the replicator has stopped running.
+func (r *Replicator) releaseLease(lease Lease) {
+	r.mu.Lock()
+	r.lease = nil
+	r.stats.Replicator.LeaseEpoch = 0
+	r.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), leaseReleaseTimeout)
+	defer cancel()
+	if err := lease.Release(ctx); err != nil {
+		r.logger.Warn("Failed to release lease", zap.String("replicator_id", r.ID), zap.Error(err))
+		return
+	}
+	r.logger.Info("Lease released", zap.String("replicator_id", r.ID), zap.Uint64("epoch", lease.Epoch()))
+}
+
+// leaseLost returns the Lost channel of the lease, or nil without one, so
+// the control loop never selects it.
+func (r *Replicator) leaseLost() <-chan struct{} {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.lease == nil {
+		return nil
+	}
+	return r.lease.Lost()
+}
+
+// leaseEpoch returns the epoch checkpoints are saved with, 0 without a
+// lease. It returns ErrLeaseLost once the lease is lost, so a replicator
+// which no longer owns its ID stops saving checkpoints.
+func (r *Replicator) leaseEpoch() (uint64, error) {
+	r.mu.RLock()
+	lease := r.lease
+	r.mu.RUnlock()
+	if lease == nil {
+		return 0, nil
+	}
+
+	select {
+	case <-lease.Lost():
+		return 0, ErrLeaseLost
+	default:
+		return lease.Epoch(), nil
+	}
+}
+
+// standby stops replicating after the lease was lost, or a checkpoint was
+// fenced by a newer holder, and disconnects. Run then waits for the lease
+// again.
+func (r *Replicator) standby(p *pipeline, cause error) error {
+	p.stop()
+
+	r.logger.Warn("Lost lease, moving to standby", zap.String("replicator_id", r.ID), zap.Error(cause))
+	r.mu.Lock()
+	r.stats.Replicator.LastError = cause.Error()
+	r.mu.Unlock()
+
+	if err := r.disconnect(context.Background()); err != nil {
+		r.logger.Warn("Error disconnecting in standby", zap.Error(err))
+	}
+	if err := r.State.Transition(StateStandby); err != nil {
+		return err
+	}
+	if errors.Is(cause, ErrLeaseLost) {
+		return cause
+	}
+	return fmt.Errorf("%w: %w", ErrLeaseLost, cause)
+}
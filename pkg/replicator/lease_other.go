@@ -0,0 +1,21 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package replicator
+
+import (
+	"context"
+	"errors"
+
+	"go.uber.org/zap"
+)
+
+// FileLeaser needs flock(2), which this platform does not support.
+type FileLeaser struct{}
+
+func NewFileLeaser(baseDir string, logger *zap.Logger) *FileLeaser {
+	return &FileLeaser{}
+}
+
+func (l *FileLeaser) Acquire(ctx context.Context, replicatorID, holder string) (Lease, error) {
+	return nil, errors.New("file leases are not supported on this platform")
+}
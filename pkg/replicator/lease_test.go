@@ -0,0 +1,256 @@
+package replicator
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestFileLeaser(t *testing.T) {
+	ctx := context.Background()
+	leaser := NewFileLeaser(t.TempDir(), zap.NewNop())
+
+	lease, err := leaser.Acquire(ctx, "orders", "host-a:1")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), lease.Epoch())
+
+	_, err = leaser.Acquire(ctx, "orders", "host-b:2")
+	assert.ErrorIs(t, err, ErrLeaseHeld)
+	assert.ErrorContains(t, err, "host-a:1")
+
+	other, err := leaser.Acquire(ctx, "invoices", "host-b:2")
+	require.NoError(t, err)
+	require.NoError(t, other.Release(ctx))
+
+	require.NoError(t, lease.Release(ctx))
+	lease, err = leaser.Acquire(ctx, "orders", "host-b:2")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), lease.Epoch())
+	require.NoError(t, lease.Release(ctx))
+}
+
+func TestFilesystemCheckpointerFencing(t *testing.T) {
+	ctx := context.Background()
+	c := NewFilesystemCheckpointer(t.TempDir(), zap.NewNop())
+
+	require.NoError(t, c.Save(ctx, &Checkpoint{ReplicatorID: "orders", Position: []byte("2"), Epoch: 2}))
+	err := c.Save(ctx, &Checkpoint{ReplicatorID: "orders", Position: []byte("1"), Epoch: 1})
+	assert.ErrorIs(t, err, ErrStaleEpoch)
+
+	checkpoint, err := c.Load(ctx, "orders")
+	require.NoError(t, err)
+	assert.Equal(t, "2", string(checkpoint.Position))
+
+	// checkpoints saved without a lease are not fenced
+	require.NoError(t, c.Save(ctx, &Checkpoint{ReplicatorID: "orders", Position: []byte("3")}))
+}
+
+// memoryLeaser grants one lease at a time, which the test can take away.
+type memoryLeaser struct {
+	mu      sync.Mutex
+	epoch   uint64
+	current *memoryLease
+}
+
+type memoryLease struct {
+	leaser *memoryLeaser
+	epoch  uint64
+	lost   chan struct{}
+}
+
+func (l *memoryLeaser) Acquire(ctx context.Context, replicatorID, holder string) (Lease, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.current != nil {
+		return nil, ErrLeaseHeld
+	}
+	l.epoch++
+	l.current = &memoryLease{leaser: l, epoch: l.epoch, lost: make(chan struct{})}
+	return l.current, nil
+}
+
+// lose expires the current lease and hands it to another holder until
+// release is called.
+func (l *memoryLeaser) lose() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	close(l.current.lost)
+	l.epoch++
+	l.current = &memoryLease{leaser: l, epoch: l.epoch, lost: make(chan struct{})}
+}
+
+func (l *memoryLeaser) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.current = nil
+}
+
+func (l *memoryLease) Epoch() uint64         { return l.epoch }
+func (l *memoryLease) Lost() <-chan struct{} { return l.lost }
+
+func (l *memoryLease) Release(ctx context.Context) error {
+	l.leaser.mu.Lock()
+	defer l.leaser.mu.Unlock()
+	if l.leaser.current == l {
+		l.leaser.current = nil
+	}
+	return nil
+}
+
+func TestReplicatorLease(t *testing.T) {
+	t.Run("waits in standby while another instance holds the lease", func(t *testing.T) {
+		leaser := NewFileLeaser(t.TempDir(), zap.NewNop())
+		checkpointer := &memoryCheckpointer{}
+		newReplicator := func(source *memorySource) *Replicator {
+			r, err := New(
+				WithID("orders"),
+				WithSource(source),
+				WithTarget(&memoryTarget{}),
+				WithCheckpointer(checkpointer),
+				WithLeaser(leaser),
+				WithLeaseOptions(LeaseOptions{RetryInterval: 10 * time.Millisecond}),
+				WithSourceOptions(SourceOptions{CheckpointBatchSize: 1}),
+			)
+			require.NoError(t, err)
+			return r
+		}
+
+		active := newReplicator(newMemorySource())
+		cancelActive, doneActive := runReplicator(t, active)
+		defer cancelActive()
+		assert.Equal(t, uint64(1), active.Stats().Replicator.LeaseEpoch)
+
+		source := newMemorySource()
+		standby := newReplicator(source)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		done := make(chan error, 1)
+		go func() { done <- standby.Run(ctx) }()
+
+		require.Eventually(t, func() bool {
+			return standby.State.Current() == StateStandby
+		}, time.Second, time.Millisecond)
+		time.Sleep(50 * time.Millisecond)
+		assert.Equal(t, StateStandby, standby.State.Current())
+
+		cancelActive()
+		require.NoError(t, <-doneActive)
+
+		require.Eventually(t, func() bool {
+			return standby.State.Current() == StateStreaming
+		}, time.Second, time.Millisecond)
+
+		source.events <- testEvent(0)
+		require.Eventually(t, func() bool {
+			return checkpointer.count() == 1
+		}, time.Second, time.Millisecond)
+		assert.Equal(t, uint64(2), checkpointer.last().Epoch)
+
+		cancel()
+		require.NoError(t, <-done)
+	})
+
+	t.Run("moves to standby when the lease is lost", func(t *testing.T) {
+		leaser := &memoryLeaser{}
+		source := newMemorySource()
+		checkpointer := &memoryCheckpointer{}
+		r, err := New(
+			WithSource(source),
+			WithTarget(&memoryTarget{}),
+			WithCheckpointer(checkpointer),
+			WithLeaser(leaser),
+			WithLeaseOptions(LeaseOptions{RetryInterval: 10 * time.Millisecond}),
+			WithSourceOptions(SourceOptions{CheckpointBatchSize: 1}),
+		)
+		require.NoError(t, err)
+
+		cancel, done := runReplicator(t, r)
+		defer cancel()
+
+		source.events <- testEvent(0)
+		require.Eventually(t, func() bool {
+			return checkpointer.count() == 1
+		}, time.Second, time.Millisecond)
+		assert.Equal(t, uint64(1), checkpointer.last().Epoch)
+
+		leaser.lose()
+		require.Eventually(t, func() bool {
+			return r.State.Current() == StateStandby
+		}, time.Second, time.Millisecond)
+		assert.Zero(t, r.Stats().Replicator.LeaseEpoch)
+
+		// the other holder gives the lease up
+		leaser.release()
+		require.Eventually(t, func() bool {
+			return r.State.Current() == StateStreaming
+		}, time.Second, time.Millisecond)
+		assert.Equal(t, uint64(3), r.Stats().Replicator.LeaseEpoch)
+
+		source.events <- testEvent(1)
+		require.Eventually(t, func() bool {
+			return checkpointer.count() == 2
+		}, time.Second, time.Millisecond)
+		assert.Equal(t, uint64(3), checkpointer.last().Epoch)
+
+		cancel()
+		require.NoError(t, <-done)
+	})
+
+	t.Run("stops in standby", func(t *testing.T) {
+		leaser := &memoryLeaser{}
+		_, err := leaser.Acquire(context.Background(), "", "other")
+		require.NoError(t, err)
+
+		r, err := New(
+			WithSource(newMemorySource()),
+			WithTarget(&memoryTarget{}),
+			WithLeaser(leaser),
+			WithLeaseOptions(LeaseOptions{RetryInterval: 10 * time.Millisecond}),
+		)
+		require.NoError(t, err)
+
+		done := make(chan error, 1)
+		go func() { done <- r.Run(context.Background()) }()
+		require.Eventually(t, func() bool {
+			return r.State.Current() == StateStandby
+		}, time.Second, time.Millisecond)
+
+		r.SendSignal(SignalStop)
+		require.NoError(t, <-done)
+		assert.Equal(t, StateStopped, r.State.Current())
+	})
+}
+
+func TestReplicatorSetCheckpointLeased(t *testing.T) {
+	ctx := context.Background()
+	leaser := &memoryLeaser{}
+	checkpointer := &memoryCheckpointer{}
+	r, err := New(
+		WithID("orders"),
+		WithSource(newMemorySource()),
+		WithTarget(&memoryTarget{}),
+		WithCheckpointer(checkpointer),
+		WithLeaser(leaser),
+	)
+	require.NoError(t, err)
+
+	// a stopped replicator leases its ID for the change
+	checkpoint, err := r.SetCheckpoint(ctx, "42")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), checkpoint.Epoch)
+	assert.Nil(t, leaser.current)
+
+	// and is refused while another instance holds it
+	_, err = leaser.Acquire(ctx, "orders", "elsewhere")
+	require.NoError(t, err)
+	_, err = r.SetCheckpoint(ctx, "43")
+	assert.ErrorIs(t, err, ErrReplicatorRunning)
+	assert.ErrorIs(t, err, ErrLeaseHeld)
+	assert.ErrorIs(t, r.DeleteCheckpoint(ctx), ErrReplicatorRunning)
+	assert.Equal(t, "42", string(checkpointer.last().Position))
+}
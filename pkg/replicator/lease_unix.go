@@ -0,0 +1,125 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package replicator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// FileLeaser leases replicator IDs with flock(2) on a <id>.lease file in
+// baseDir, so it only excludes instances on the same host, or sharing the
+// directory on a filesystem which supports flock. The lock is released by
+// the kernel when the process exits, so a lease is never lost while the
+// process runs. The file keeps the epoch and holder of the last lease.
+type FileLeaser struct {
+	baseDir string
+	logger  *zap.Logger
+}
+
+func NewFileLeaser(baseDir string, logger *zap.Logger) *FileLeaser {
+	return &FileLeaser{
+		baseDir: baseDir,
+		logger:  logger,
+	}
+}
+
+// leaseRecord is the content of a lease file.
+type leaseRecord struct {
+	Epoch      uint64    `json:"epoch"`
+	Holder     string    `json:"holder"`
+	AcquiredAt time.Time `json:"acquired_at"`
+}
+
+func (l *FileLeaser) Acquire(ctx context.Context, replicatorID, holder string) (Lease, error) {
+	if err := os.MkdirAll(l.baseDir, 0755); err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(l.baseDir, replicatorID+".lease")
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			record, _ := readLeaseRecord(path)
+			return nil, fmt.Errorf("%w: %s is locked by %s", ErrLeaseHeld, path, record.Holder)
+		}
+		return nil, err
+	}
+
+	record, err := readLeaseRecord(path)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	record = leaseRecord{
+		Epoch:      record.Epoch + 1,
+		Holder:     holder,
+		AcquiredAt: time.Now(),
+	}
+	data, err := json.Marshal(record)
+	if err == nil {
+		err = file.Truncate(0)
+	}
+	if err == nil {
+		_, err = file.WriteAt(data, 0)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	l.logger.Debug("Lease file locked", zap.String("path", path), zap.Uint64("epoch", record.Epoch))
+	return &fileLease{
+		file:  file,
+		epoch: record.Epoch,
+		lost:  make(chan struct{}),
+	}, nil
+}
+
+func readLeaseRecord(path string) (leaseRecord, error) {
+	var record leaseRecord
+	data, err := os.ReadFile(path)
+	if err != nil || len(data) == 0 {
+		return record, err
+	}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return record, fmt.Errorf("invalid lease file %s: %w", path, err)
+	}
+	return record, nil
+}
+
+type fileLease struct {
+	file  *os.File
+	epoch uint64
+	lost  chan struct{}
+}
+
+func (l *fileLease) Epoch() uint64 {
+	return l.epoch
+}
+
+func (l *fileLease) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Release unlocks the lease file. The file is kept for its epoch.
+func (l *fileLease) Release(ctx context.Context) error {
+	return l.file.Close()
+}
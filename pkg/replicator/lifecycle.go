@@ -0,0 +1,313 @@
+package replicator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+// Factory builds a replicator, and its source and target, from a spec. The
+// server runs and stops the replicators it builds.
+type Factory func(ctx context.Context, spec Spec) (*Replicator, error)
+
+// WithFactory enables creating and updating replicators through the API.
+func WithFactory(factory Factory) ServerOption {
+	return func(s *Server) {
+		s.factory = factory
+	}
+}
+
+// WithSpecStore persists the specs of the replicators managed through the
+// API. Without a store they are lost when the process exits.
+func WithSpecStore(store SpecStore) ServerOption {
+	return func(s *Server) {
+		s.store = store
+	}
+}
+
+// managedReplicator is a replicator the server runs on behalf of the API.
+type managedReplicator struct {
+	spec       Spec
+	replicator *Replicator
+	cancel     context.CancelFunc
+	done       chan struct{}
+}
+
+// Restore starts the replicators of every stored spec. Replicators managed
+// through the API run until ctx is cancelled, when they drain; Wait returns
+// once they have all stopped. A spec which fails to build is logged and
+// skipped so it does not keep the others from starting.
+func (s *Server) Restore(ctx context.Context) error {
+	s.lifecycle.Lock()
+	defer s.lifecycle.Unlock()
+
+	s.ctx = ctx
+	if s.store == nil || s.factory == nil {
+		return nil
+	}
+
+	specs, err := s.store.List(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list replicator specs: %w", err)
+	}
+	for _, spec := range specs {
+		r, err := s.factory(ctx, spec)
+		if err != nil {
+			s.logger.Error("failed to restore replicator",
+				zap.String("replicator_id", spec.ID),
+				zap.Error(err))
+			continue
+		}
+		s.launch(spec, r)
+		s.logger.Info("replicator restored", zap.String("replicator_id", spec.ID))
+	}
+	return nil
+}
+
+// Wait blocks until every replicator managed through the API has stopped.
+func (s *Server) Wait() {
+	s.wg.Wait()
+}
+
+// launch registers and runs a managed replicator. The caller must hold
+// s.lifecycle.
+func (s *Server) launch(spec Spec, r *Replicator) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	m := &managedReplicator{
+		spec:       spec,
+		replicator: r,
+		cancel:     cancel,
+		done:       make(chan struct{}),
+	}
+
+	s.mu.Lock()
+	s.managed[spec.ID] = m
+	s.mu.Unlock()
+	s.RegisterReplicator(r)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer close(m.done)
+		if err := r.Run(ctx); err != nil {
+			s.logger.Error("replicator error",
+				zap.String("replicator_id", spec.ID),
+				zap.Error(err))
+		}
+	}()
+}
+
+// halt stops a managed replicator and waits for it to drain. The caller must
+// hold s.lifecycle.
+func (s *Server) halt(m *managedReplicator) {
+	m.cancel()
+	<-m.done
+}
+
+// discard releases the source and target of a replicator which was built
+// but never run.
+func (s *Server) discard(r *Replicator) {
+	ctx := context.Background()
+	if err := r.Source.Disconnect(ctx); err != nil {
+		s.logger.Warn("failed to disconnect source", zap.String("replicator_id", r.ID), zap.Error(err))
+	}
+	if err := r.Target.Close(ctx); err != nil {
+		s.logger.Warn("failed to close target", zap.String("replicator_id", r.ID), zap.Error(err))
+	}
+}
+
+// lookup returns the registered and the managed replicator with the given
+// ID, if any.
+func (s *Server) lookup(id string) (*Replicator, *managedReplicator) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.replicators[id], s.managed[id]
+}
+
+func decodeSpec(r *http.Request) (Spec, error) {
+	var spec Spec
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&spec); err != nil {
+		return spec, fmt.Errorf("invalid spec: %w", err)
+	}
+	return spec, nil
+}
+
+func (s *Server) writeInfo(w http.ResponseWriter, status int, r *Replicator) {
+	s.mu.RLock()
+	info := s.info(r)
+	s.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(info)
+}
+
+// createReplicator builds, persists and starts a replicator from the spec in
+// the request body.
+func (s *Server) createReplicator(w http.ResponseWriter, r *http.Request) {
+	if s.factory == nil {
+		http.Error(w, "creating replicators is not enabled", http.StatusNotImplemented)
+		return
+	}
+
+	spec, err := decodeSpec(r)
+	if err == nil {
+		err = spec.Validate()
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.lifecycle.Lock()
+	defer s.lifecycle.Unlock()
+
+	if existing, _ := s.lookup(spec.ID); existing != nil {
+		http.Error(w, "replicator already exists", http.StatusConflict)
+		return
+	}
+
+	rep, err := s.start(spec, nil)
+	if err != nil {
+		s.writeStartError(w, err)
+		return
+	}
+
+	s.logger.Info("replicator created", zap.String("replicator_id", spec.ID))
+	s.writeInfo(w, http.StatusCreated, rep)
+}
+
+// updateReplicator replaces a managed replicator with one built from the
+// spec in the request body, or creates it if it does not exist. The running
+// replicator is only stopped once the new one has been built, and drains
+// before its replacement starts from its checkpoint.
+func (s *Server) updateReplicator(w http.ResponseWriter, r *http.Request) {
+	if s.factory == nil {
+		http.Error(w, "updating replicators is not enabled", http.StatusNotImplemented)
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	spec, err := decodeSpec(r)
+	if err == nil {
+		if spec.ID == "" {
+			spec.ID = id
+		}
+		if spec.ID != id {
+			err = fmt.Errorf("spec id %q does not match %q", spec.ID, id)
+		} else {
+			err = spec.Validate()
+		}
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.lifecycle.Lock()
+	defer s.lifecycle.Unlock()
+
+	existing, m := s.lookup(id)
+	if existing != nil && m == nil {
+		http.Error(w, "replicator is not managed by the API", http.StatusConflict)
+		return
+	}
+
+	status := http.StatusOK
+	if m == nil {
+		status = http.StatusCreated
+	}
+
+	rep, err := s.start(spec, m)
+	if err != nil {
+		s.writeStartError(w, err)
+		return
+	}
+
+	s.logger.Info("replicator updated", zap.String("replicator_id", id))
+	s.writeInfo(w, status, rep)
+}
+
+// startError is returned by start when the spec could not be built into a
+// replicator.
+type startError struct {
+	err error
+}
+
+func (e *startError) Error() string { return e.err.Error() }
+func (e *startError) Unwrap() error { return e.err }
+
+func (s *Server) writeStartError(w http.ResponseWriter, err error) {
+	var serr *startError
+	if errors.As(err, &serr) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.logger.Error("failed to start replicator", zap.Error(err))
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// start builds and persists a replicator, stops the replicator it replaces,
+// if any, and runs it. The caller must hold s.lifecycle.
+func (s *Server) start(spec Spec, replaces *managedReplicator) (*Replicator, error) {
+	rep, err := s.factory(s.ctx, spec)
+	if err != nil {
+		return nil, &startError{err: err}
+	}
+	if s.store != nil {
+		if err := s.store.Save(s.ctx, spec); err != nil {
+			s.discard(rep)
+			return nil, fmt.Errorf("failed to save spec: %w", err)
+		}
+	}
+
+	if replaces != nil {
+		s.halt(replaces)
+	}
+	s.launch(spec, rep)
+	return rep, nil
+}
+
+// deleteReplicator stops a managed replicator and removes its spec. Its
+// checkpoint is kept, so a replicator created again with the same ID resumes
+// where it stopped.
+func (s *Server) deleteReplicator(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	s.lifecycle.Lock()
+	defer s.lifecycle.Unlock()
+
+	existing, m := s.lookup(id)
+	switch {
+	case existing == nil:
+		http.Error(w, "replicator not found", http.StatusNotFound)
+		return
+	case m == nil:
+		http.Error(w, "replicator is not managed by the API", http.StatusConflict)
+		return
+	}
+
+	if s.store != nil {
+		if err := s.store.Delete(r.Context(), id); err != nil {
+			s.logger.Error("failed to delete spec", zap.String("replicator_id", id), zap.Error(err))
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	s.halt(m)
+	s.mu.Lock()
+	delete(s.managed, id)
+	s.mu.Unlock()
+	s.UnregisterReplicator(id)
+
+	s.logger.Info("replicator deleted", zap.String("replicator_id", id))
+	w.WriteHeader(http.StatusNoContent)
+}
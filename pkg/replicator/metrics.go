@@ -0,0 +1,221 @@
+package replicator
+
+import (
+	"slices"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Typed is implemented by sources and targets which report their type, such
+// as "postgresql" or "kafka". The source type labels the metrics.
+type Typed interface {
+	Type() string
+}
+
+func typeOf(v interface{}) string {
+	if t, ok := v.(Typed); ok {
+		return t.Type()
+	}
+	return "unknown"
+}
+
+var states = []State{
+	StateCreated,
+	StateConnecting,
+	StateStreaming,
+	StatePaused,
+	StateStopped,
+	StateReconnecting,
+	StateDraining,
+	StateError,
+	StateStandby,
+}
+
+var metricLabels = []string{"replicator_id", "source_type"}
+
+func newDesc(name, help string, labels ...string) *prometheus.Desc {
+	return prometheus.NewDesc("librarian_"+name, help, slices.Concat(metricLabels, labels), nil)
+}
+
+var (
+	sourceEventsDesc            = newDesc("source_events_total", "Events read from the source.")
+	sourceBytesDesc             = newDesc("source_bytes_total", "Bytes read from the source.")
+	sourceErrorsDesc            = newDesc("source_errors_total", "Events the source failed to read.")
+	sourceConnectionHealthyDesc = newDesc("source_connection_healthy", "Whether the source is connected.")
+	sourceConnectionRetriesDesc = newDesc("source_connection_retries_total", "Source reconnection attempts.")
+
+	targetEventsDesc            = newDesc("target_events_total", "Events written to the target.")
+	targetErrorsDesc            = newDesc("target_errors_total", "Target errors by kind.", "kind")
+	targetConnectionHealthyDesc = newDesc("target_connection_healthy", "Whether the target is connected.")
+	targetConnectionRetriesDesc = newDesc("target_connection_retries_total", "Target reconnection attempts.")
+	targetRetriesDesc           = newDesc("target_retries_total", "Retries of events which failed to be written or delivered.")
+	targetSkippedDesc           = newDesc("target_skipped_events_total", "Events skipped by the error policy.")
+	targetDeadLetterDesc        = newDesc("target_dead_letter_events_total", "Events sent to the dead letter queue.")
+
+	stateDesc             = newDesc("replicator_state", "Current state of the replicator, 1 for the current state.", "state")
+	uptimeDesc            = newDesc("replicator_uptime_seconds", "Seconds since the replicator started.")
+	checkpointsDesc       = newDesc("replicator_checkpoints_total", "Checkpoints saved.")
+	checkpointAgeDesc     = newDesc("replicator_checkpoint_age_seconds", "Seconds since the last checkpoint was saved.")
+	queueDepthDesc        = newDesc("replicator_queue_depth", "Events queued between the source and the target.")
+	queueCapacityDesc     = newDesc("replicator_queue_capacity", "Capacity of the queue between the source and the target.")
+	inFlightDesc          = newDesc("replicator_in_flight_events", "Events written to the target and not yet acknowledged.")
+	pendingCheckpointDesc = newDesc("replicator_pending_checkpoint_events", "Acknowledged events not yet covered by a checkpoint.")
+	droppedDesc           = newDesc("replicator_dropped_events_total", "Events dropped by transforms.")
+	filteredDesc          = newDesc("replicator_filtered_events_total", "Events which did not match the filters.")
+	reconnectsDesc        = newDesc("replicator_reconnect_attempts_total", "Reconnects after source or target failures.")
+	signalsDesc           = newDesc("replicator_signals_received_total", "Control signals received.")
+	regressionsDesc       = newDesc("replicator_position_regressions_total", "Checkpoints saved behind the previous one.")
+	lagDesc               = newDesc("replicator_lag_seconds", "Seconds from the commit to the delivery of the most recently delivered event.")
+)
+
+// metrics are the per table metrics the pipeline records as events flow
+// through. Replicator wide metrics are derived from Stats when scraped.
+type metrics struct {
+	events        *prometheus.CounterVec
+	writeDuration *prometheus.HistogramVec
+
+	commitToRead *prometheus.HistogramVec
+	readToAck    *prometheus.HistogramVec
+	commitToAck  *prometheus.HistogramVec
+}
+
+func newMetrics(id, sourceType string) *metrics {
+	labels := prometheus.Labels{"replicator_id": id, "source_type": sourceType}
+	return &metrics{
+		events: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "librarian_table_events_total",
+			Help:        "Events read from the source by table and operation.",
+			ConstLabels: labels,
+		}, []string{"table", "op"}),
+		writeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:        "librarian_write_duration_seconds",
+			Help:        "Time taken to write an event to the target by table.",
+			ConstLabels: labels,
+			Buckets:     prometheus.ExponentialBuckets(0.0001, 4, 10),
+		}, []string{"table"}),
+		commitToRead: newLagHistogram(labels, "librarian_commit_to_read_lag_seconds",
+			"Time from the commit of a change to reading it from the source by table."),
+		readToAck: newLagHistogram(labels, "librarian_read_to_ack_lag_seconds",
+			"Time from reading an event to the target acknowledging it by table."),
+		commitToAck: newLagHistogram(labels, "librarian_commit_to_ack_lag_seconds",
+			"Time from the commit of a change to the target acknowledging it by table."),
+	}
+}
+
+func newLagHistogram(labels prometheus.Labels, name, help string) *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:        name,
+		Help:        help,
+		ConstLabels: labels,
+		Buckets:     prometheus.ExponentialBuckets(0.001, 4, 12),
+	}, []string{"table"})
+}
+
+// tableLabel returns the table label of an event: "schema.table", or
+// "db.table" for sources without schemas.
+func tableLabel(event Event) string {
+	source := event.Payload.Source
+	if source.Schema != "" {
+		return source.Schema + "." + source.Table
+	}
+	return source.Db + "." + source.Table
+}
+
+func (m *metrics) observeEvent(event Event) {
+	m.events.WithLabelValues(tableLabel(event), string(event.Payload.Op)).Inc()
+}
+
+func (m *metrics) observeWrite(event Event, d time.Duration) {
+	m.writeDuration.WithLabelValues(tableLabel(event)).Observe(d.Seconds())
+}
+
+// observeLag records the lags of an event acknowledged at ackedAt. The commit
+// lags are skipped when the source does not report commit times.
+func (m *metrics) observeLag(event Event, commit, ackedAt time.Time) {
+	table := tableLabel(event)
+	m.readToAck.WithLabelValues(table).Observe(max(ackedAt.Sub(event.readAt), 0).Seconds())
+	if commit.IsZero() {
+		return
+	}
+	m.commitToRead.WithLabelValues(table).Observe(max(event.readAt.Sub(commit), 0).Seconds())
+	m.commitToAck.WithLabelValues(table).Observe(max(ackedAt.Sub(commit), 0).Seconds())
+}
+
+// collect sends the replicator's metrics to ch.
+func (r *Replicator) collect(ch chan<- prometheus.Metric) {
+	stats := r.Stats()
+	labels := []string{r.ID, typeOf(r.Source)}
+
+	counter := func(desc *prometheus.Desc, v int64, extra ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(v), slices.Concat(labels, extra)...)
+	}
+	gauge := func(desc *prometheus.Desc, v float64, extra ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, slices.Concat(labels, extra)...)
+	}
+	boolean := func(ok bool) float64 {
+		if ok {
+			return 1
+		}
+		return 0
+	}
+
+	counter(sourceEventsDesc, stats.Source.TotalEvents)
+	counter(sourceBytesDesc, stats.Source.TotalBytes)
+	counter(sourceErrorsDesc, stats.Source.EventErrorCount)
+	gauge(sourceConnectionHealthyDesc, boolean(stats.Source.ConnectionHealthy))
+	counter(sourceConnectionRetriesDesc, stats.Source.ConnectionRetries)
+
+	counter(targetEventsDesc, stats.Target.TotalEvents)
+	counter(targetErrorsDesc, stats.Target.EventErrorCount, "event")
+	counter(targetErrorsDesc, int64(stats.Target.WriteErrorCount), "write")
+	gauge(targetConnectionHealthyDesc, boolean(stats.Target.ConnectionHealthy))
+	counter(targetConnectionRetriesDesc, stats.Target.ConnectionRetries)
+	counter(targetRetriesDesc, stats.Target.RetryCount)
+	counter(targetSkippedDesc, stats.Target.SkippedEvents)
+	counter(targetDeadLetterDesc, stats.Target.DeadLetterEvents)
+
+	for _, state := range states {
+		gauge(stateDesc, boolean(state == stats.Replicator.State), string(state))
+	}
+	gauge(uptimeDesc, float64(stats.Replicator.UptimeSeconds))
+	counter(checkpointsDesc, stats.Replicator.CheckpointCount)
+	if !stats.Replicator.LastCheckpointAt.IsZero() {
+		gauge(checkpointAgeDesc, time.Since(stats.Replicator.LastCheckpointAt).Seconds())
+	}
+	gauge(queueDepthDesc, float64(stats.Replicator.QueueDepth))
+	gauge(queueCapacityDesc, float64(stats.Replicator.QueueCapacity))
+	gauge(inFlightDesc, float64(stats.Replicator.InFlightEvents))
+	gauge(pendingCheckpointDesc, float64(stats.Replicator.PendingCheckpointEvents))
+	counter(droppedDesc, stats.Replicator.DroppedEvents)
+	counter(filteredDesc, stats.Replicator.FilteredEvents)
+	counter(reconnectsDesc, stats.Replicator.ReconnectAttempts)
+	counter(signalsDesc, stats.Replicator.SignalsReceived)
+	counter(regressionsDesc, stats.Replicator.PositionRegressions)
+	if !stats.Replicator.Lag.LastCommitAt.IsZero() {
+		gauge(lagDesc, float64(stats.Replicator.Lag.CommitToAckMs)/1000)
+	}
+
+	r.metrics.events.Collect(ch)
+	r.metrics.writeDuration.Collect(ch)
+	r.metrics.commitToRead.Collect(ch)
+	r.metrics.readToAck.Collect(ch)
+	r.metrics.commitToAck.Collect(ch)
+}
+
+// collector exposes the replicators registered with a Server. It is an
+// unchecked collector since replicators come and go.
+type collector struct {
+	s *Server
+}
+
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {}
+
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	c.s.mu.RLock()
+	defer c.s.mu.RUnlock()
+
+	for _, r := range c.s.replicators {
+		r.collect(ch)
+	}
+}
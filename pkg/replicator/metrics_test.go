@@ -0,0 +1,52 @@
+package replicator
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestServerMetrics(t *testing.T) {
+	source := newMemorySource()
+	target := &memoryTarget{}
+	r, err := New(WithID("orders"), WithSource(source), WithTarget(target))
+	require.NoError(t, err)
+
+	s := NewServer(zap.NewNop())
+	s.RegisterReplicator(r)
+	srv := httptest.NewServer(s.Routes())
+	defer srv.Close()
+
+	cancel, done := runReplicator(t, r)
+	for i := 0; i < 3; i++ {
+		source.events <- testEvent(i)
+	}
+	require.Eventually(t, func() bool {
+		return len(target.written()) == 3 && r.Stats().Replicator.InFlightEvents == 0
+	}, time.Second, time.Millisecond)
+
+	resp, err := http.Get(srv.URL + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	metrics := string(body)
+
+	assert.Contains(t, metrics, `librarian_replicator_state{replicator_id="orders",source_type="unknown",state="streaming"} 1`)
+	assert.Contains(t, metrics, `librarian_table_events_total{op="c",replicator_id="orders",source_type="unknown",table="public.users"} 3`)
+	assert.Contains(t, metrics, `librarian_write_duration_seconds_count{replicator_id="orders",source_type="unknown",table="public.users"} 3`)
+	assert.Contains(t, metrics, `librarian_read_to_ack_lag_seconds_count{replicator_id="orders",source_type="unknown",table="public.users"} 3`)
+	assert.NotContains(t, metrics, `librarian_commit_to_ack_lag_seconds_count`)
+	assert.Contains(t, metrics, `librarian_replicator_queue_capacity{replicator_id="orders",source_type="unknown"} 1024`)
+
+	cancel()
+	require.NoError(t, <-done)
+}
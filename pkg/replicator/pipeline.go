@@ -0,0 +1,606 @@
+package replicator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// pipeline moves events from the Source to the Target.
+//
+// A reader goroutine pulls events from the source into a bounded queue which
+// is consumed by a writer goroutine. When the target falls behind, the queue
+// fills up and the reader blocks, applying backpressure to the source instead
+// of buffering without limit.
+type pipeline struct {
+	r       *Replicator
+	events  chan Event
+	errs    chan pipelineError
+	tracker *ackTracker
+	wg      sync.WaitGroup
+
+	cancel     context.CancelFunc
+	readCancel context.CancelFunc
+
+	// failed is closed on the first pipeline error
+	failed     chan struct{}
+	failOnce   sync.Once
+	writerDone chan struct{}
+}
+
+// startPipeline starts reading and writing events. The pipeline is not
+// cancelled with ctx; the control loop decides whether to drain or stop it.
+func (r *Replicator) startPipeline(ctx context.Context) *pipeline {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	readCtx, readCancel := context.WithCancel(ctx)
+
+	p := &pipeline{
+		r:          r,
+		events:     make(chan Event, r.PipelineOptions.QueueSize),
+		errs:       make(chan pipelineError, 3),
+		tracker:    newAckTracker(),
+		cancel:     cancel,
+		readCancel: readCancel,
+		failed:     make(chan struct{}),
+		writerDone: make(chan struct{}),
+	}
+
+	p.wg.Add(2)
+	go p.read(readCtx)
+	go p.write(ctx)
+
+	r.mu.Lock()
+	r.pipeline = p
+	r.mu.Unlock()
+
+	return p
+}
+
+// stop cancels the reader and writer and waits for both to exit. Events
+// still in the queue are discarded; they have not been checkpointed and will
+// be redelivered by the source on the next connect.
+func (p *pipeline) stop() {
+	p.cancel()
+	p.wg.Wait()
+
+	p.r.mu.Lock()
+	if p.r.pipeline == p {
+		p.r.pipeline = nil
+	}
+	p.r.mu.Unlock()
+}
+
+// drain stops reading from the source and lets the writer deliver the queued
+// events, flush the target and wait for their acknowledgements. If ctx
+// expires first the pipeline is cancelled. Either way the writer saves a
+// final checkpoint of everything acknowledged before it exits.
+func (p *pipeline) drain(ctx context.Context) error {
+	p.readCancel()
+
+	var err error
+	select {
+	case <-p.writerDone:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	p.stop()
+	return err
+}
+
+// fail reports a fatal pipeline error to the control loop. Errors beyond the
+// capacity of the error channel are dropped; the first one stops the
+// pipeline.
+func (p *pipeline) fail(err error, target bool) {
+	p.failOnce.Do(func() { close(p.failed) })
+
+	select {
+	case p.errs <- pipelineError{err: err, target: target}:
+	default:
+	}
+}
+
+func (p *pipeline) read(ctx context.Context) {
+	defer p.wg.Done()
+	defer close(p.events)
+
+	idle := time.NewTimer(0)
+	if !idle.Stop() {
+		<-idle.C
+	}
+	defer idle.Stop()
+
+	for {
+		if err := p.r.gate.wait(ctx); err != nil {
+			return
+		}
+
+		start := time.Now()
+		event, err := p.r.Source.Next(ctx)
+		if errors.Is(err, ErrNoEventsFound) {
+			idle.Reset(p.r.SourceOptions.EmptyPollInterval)
+			select {
+			case <-ctx.Done():
+				return
+			case <-idle.C:
+			}
+			continue
+		}
+
+		if err != nil && ctx.Err() != nil {
+			return
+		}
+
+		// empty polls are not traced, the span is started once the
+		// outcome is known
+		_, span := p.r.tracer.Start(ctx, spanSourceNext, trace.WithTimestamp(start))
+		if err != nil {
+			endSpan(span, err)
+			p.r.logger.Error("Error reading from source", zap.Error(err))
+			p.fail(err, false)
+			return
+		}
+		event.readAt = time.Now()
+		span.SetAttributes(p.r.eventAttributes(event)...)
+		event.spanContext = span.SpanContext()
+		span.End()
+
+		select {
+		case p.events <- event:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (p *pipeline) write(ctx context.Context) {
+	defer p.wg.Done()
+	defer close(p.writerDone)
+
+	// Checkpoint whatever has been delivered when the writer exits so a
+	// stop or restart does not replay acknowledged events. The pipeline
+	// context is cancelled at this point.
+	defer func() {
+		if err := p.commit(context.Background()); err != nil {
+			p.r.logger.Error("Error saving final checkpoint", zap.Error(err))
+		}
+	}()
+
+	var flushChan <-chan time.Time
+	if p.r.TargetOptions.FlushTimeout > 0 {
+		flushTicker := time.NewTicker(p.r.TargetOptions.FlushTimeout)
+		defer flushTicker.Stop()
+		flushChan = flushTicker.C
+	}
+
+	var checkpointChan <-chan time.Time
+	if p.r.SourceOptions.CheckpointInterval > 0 {
+		checkpointTicker := time.NewTicker(p.r.SourceOptions.CheckpointInterval)
+		defer checkpointTicker.Stop()
+		checkpointChan = checkpointTicker.C
+	}
+
+	batchSize := p.r.SourceOptions.CheckpointBatchSize
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case event, ok := <-p.events:
+			if !ok {
+				// the reader has stopped; deliver what is in flight
+				p.finish(ctx)
+				return
+			}
+
+			if err := p.writeEvent(ctx, event); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				p.writeFailed(err)
+				return
+			}
+
+		case <-p.tracker.failed:
+			if err := p.retryFailures(ctx); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				p.writeFailed(err)
+				return
+			}
+
+		case <-p.tracker.notify:
+			if batchSize == 0 || p.tracker.acked() < batchSize {
+				continue
+			}
+			if err := p.commit(ctx); err != nil {
+				p.r.logger.Error("Error checkpointing", zap.Error(err))
+				p.fail(err, false)
+				return
+			}
+
+		case <-checkpointChan:
+			if err := p.commit(ctx); err != nil {
+				p.r.logger.Error("Error checkpointing", zap.Error(err))
+				p.fail(err, false)
+				return
+			}
+
+		case <-flushChan:
+			p.r.logger.Debug("Flushing to target")
+			if err := p.flush(ctx); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				p.r.logger.Error("Error flushing to target", zap.Error(err))
+				p.fail(err, true)
+				return
+			}
+		}
+	}
+}
+
+// finish flushes the target and waits until every written event has been
+// acknowledged, the pipeline fails or ctx is cancelled.
+func (p *pipeline) finish(ctx context.Context) {
+	if err := p.flush(ctx); err != nil {
+		p.r.logger.Error("Error flushing to target", zap.Error(err))
+		return
+	}
+
+	for p.tracker.inFlight() > 0 {
+		select {
+		case <-p.tracker.notify:
+		case <-p.tracker.failed:
+			if err := p.retryFailures(ctx); err != nil {
+				if ctx.Err() == nil {
+					p.writeFailed(err)
+				}
+				return
+			}
+		case <-p.failed:
+			return
+		case <-ctx.Done():
+			p.r.logger.Warn("Stopped waiting for target acknowledgements",
+				zap.Int("in_flight_events", p.tracker.inFlight()))
+			return
+		}
+	}
+}
+
+// commit checkpoints the highest contiguous acknowledged position, if any
+// events have been acknowledged since the previous checkpoint.
+func (p *pipeline) commit(ctx context.Context) error {
+	if !p.r.SourceOptions.checkpointing() {
+		return nil
+	}
+
+	position, count, ok := p.tracker.committable()
+	if !ok {
+		return nil
+	}
+	return p.r.checkpoint(ctx, position, count)
+}
+
+// flush flushes the target.
+func (p *pipeline) flush(ctx context.Context) error {
+	ctx, span := p.r.tracer.Start(ctx, spanTargetFlush)
+	err := p.r.Target.Flush(ctx)
+	endSpan(span, err)
+	return err
+}
+
+// writeEvent filters and transforms an event and hands it to the target. The
+// event is tracked until the target acknowledges delivery. Targets which do
+// not implement Acknowledger deliver synchronously and are acknowledged once
+// Write returns.
+func (p *pipeline) writeEvent(ctx context.Context, event Event) error {
+	event.Sequence = p.r.sequence.Add(1)
+	p.r.metrics.observeEvent(event)
+
+	matched, err := p.filter(event)
+	if err != nil || !matched {
+		p.tracker.track(event.Sequence, event)
+		if err != nil {
+			return p.giveUp(ctx, event, StageFilter, err, 1)
+		}
+		p.tracker.ack(event.Sequence)
+		p.r.mu.Lock()
+		p.r.stats.Replicator.FilteredEvents++
+		p.r.mu.Unlock()
+		return nil
+	}
+
+	event, err = p.transform(ctx, event)
+	p.tracker.track(event.Sequence, event)
+
+	if errors.Is(err, ErrDropEvent) {
+		// nothing to deliver, the position can be checkpointed right away
+		p.tracker.ack(event.Sequence)
+		p.r.mu.Lock()
+		p.r.stats.Replicator.DroppedEvents++
+		p.r.mu.Unlock()
+		return nil
+	}
+	if err != nil {
+		// transforms are deterministic, retrying would fail again
+		return p.giveUp(ctx, event, StageTransform, err, 1)
+	}
+
+	p.r.tail.publish(event)
+	return p.deliver(ctx, event, 0)
+}
+
+// transform applies the transforms to the event.
+func (p *pipeline) transform(ctx context.Context, event Event) (Event, error) {
+	if len(p.r.Transforms) == 0 {
+		return event, nil
+	}
+
+	ctx = trace.ContextWithSpanContext(ctx, event.spanContext)
+	_, span := p.r.tracer.Start(ctx, spanTransform, trace.WithAttributes(p.r.eventAttributes(event)...))
+	event, err := p.r.Transforms.Apply(event)
+	if errors.Is(err, ErrDropEvent) {
+		span.SetAttributes(attribute.Bool("librarian.dropped", true))
+		span.End()
+		return event, err
+	}
+	endSpan(span, err)
+	return event, err
+}
+
+// filter reports whether the event matches every filter.
+func (p *pipeline) filter(event Event) (bool, error) {
+	for _, f := range p.r.Filters {
+		if ok, err := f.Match(event); err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// deliver writes an event to the target, retrying event errors according to
+// the ErrorOptions. attempts is the number of failed attempts so far. Errors
+// which are not EventErrors are returned as is; they are connection level
+// failures which the replicator recovers from by reconnecting.
+func (p *pipeline) deliver(ctx context.Context, event Event, attempts int) error {
+	// the target sees the write span, so it can propagate the trace
+	ctx = trace.ContextWithSpanContext(ctx, event.spanContext)
+
+	for {
+		start := time.Now()
+		writeCtx, span := p.r.tracer.Start(ctx, spanTargetWrite, trace.WithAttributes(p.r.eventAttributes(event)...))
+		span.SetAttributes(attribute.Int("librarian.attempt", attempts+1))
+		err := p.r.Target.Write(writeCtx, event)
+		endSpan(span, err)
+		p.r.metrics.observeWrite(event, time.Since(start))
+		if err == nil {
+			if !p.r.acknowledges {
+				p.delivered(event.Sequence)
+			}
+			return nil
+		}
+
+		var eventErr *EventError
+		if !errors.As(err, &eventErr) {
+			return err
+		}
+
+		attempts++
+		if attempts > p.r.ErrorOptions.MaxRetries {
+			return p.giveUp(ctx, event, StageWrite, err, attempts)
+		}
+		if err := p.backoff(ctx, event, err, attempts); err != nil {
+			return err
+		}
+	}
+}
+
+// retryFailures handles the deliveries the target reported as failed: each is
+// written again until it runs out of retries, after which the error policy
+// applies.
+func (p *pipeline) retryFailures(ctx context.Context) error {
+	for _, f := range p.tracker.takeFailures() {
+		if f.attempts > p.r.ErrorOptions.MaxRetries {
+			if err := p.giveUp(ctx, f.event, StageDeliver, f.err, f.attempts); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := p.backoff(ctx, f.event, f.err, f.attempts); err != nil {
+			return err
+		}
+		if err := p.deliver(ctx, f.event, f.attempts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backoff waits before retrying a failed event.
+func (p *pipeline) backoff(ctx context.Context, event Event, cause error, attempts int) error {
+	p.r.logger.Warn("Retrying event",
+		zap.Uint64("sequence", event.Sequence),
+		zap.Int("attempt", attempts),
+		zap.Error(cause))
+
+	p.r.mu.Lock()
+	p.r.stats.Target.RetryCount++
+	p.r.mu.Unlock()
+
+	if p.r.ErrorOptions.RetryBackoff <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(p.r.ErrorOptions.RetryBackoff)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// giveUp applies the error policy to an event that could not be transformed
+// or delivered. Skipped and dead lettered events are acknowledged so the
+// checkpoint moves past them.
+func (p *pipeline) giveUp(ctx context.Context, event Event, stage string, cause error, attempts int) error {
+	switch p.r.ErrorOptions.Policy {
+	case ErrorPolicySkip:
+		p.r.logger.Warn("Skipping event",
+			zap.String("stage", stage),
+			zap.String("position", p.r.renderPosition(event.Position)),
+			zap.Error(cause))
+
+		p.r.mu.Lock()
+		p.r.stats.Target.SkippedEvents++
+		p.r.mu.Unlock()
+
+	case ErrorPolicyDLQ:
+		letter := DeadLetter{
+			ReplicatorID: p.r.ID,
+			Stage:        stage,
+			Error:        cause.Error(),
+			Attempts:     attempts,
+			Position:     p.r.renderPosition(event.Position),
+			Timestamp:    time.Now(),
+			Event:        event,
+		}
+		if err := p.r.DeadLetterQueue.Write(ctx, letter); err != nil {
+			return &stageError{stage: "dead letter queue", err: err}
+		}
+
+		p.r.logger.Warn("Event sent to dead letter queue",
+			zap.String("stage", stage),
+			zap.String("position", p.r.renderPosition(event.Position)),
+			zap.Error(cause))
+
+		p.r.mu.Lock()
+		p.r.stats.Target.DeadLetterEvents++
+		p.r.mu.Unlock()
+
+	default:
+		return &stageError{stage: stage, err: cause}
+	}
+
+	if f, ok := p.r.Target.(forgetter); ok {
+		f.forget(event.Sequence)
+	}
+	p.tracker.ack(event.Sequence)
+	return nil
+}
+
+// writeFailed reports an error raised while writing an event. Only write and
+// delivery errors are blamed on the target.
+func (p *pipeline) writeFailed(err error) {
+	target := true
+	var serr *stageError
+	if errors.As(err, &serr) {
+		target = serr.stage == StageWrite || serr.stage == StageDeliver
+	}
+
+	p.r.logger.Error("Error writing event", zap.Error(err))
+	p.fail(err, target)
+}
+
+// ack is called by the target once delivery of an event is confirmed or
+// has failed. Failed deliveries are handed to the writer, which retries them
+// or applies the error policy. The checkpoint does not advance past a failed
+// event in the meantime.
+func (p *pipeline) ack(seq uint64, err error) {
+	if err != nil {
+		p.r.logger.Error("Target failed to deliver event",
+			zap.Uint64("sequence", seq),
+			zap.Error(err))
+		p.tracker.nack(seq, err)
+		return
+	}
+	p.delivered(seq)
+}
+
+// delivered acknowledges a delivered event and records its lag.
+func (p *pipeline) delivered(seq uint64) {
+	if event, ok := p.tracker.ack(seq); ok {
+		p.r.observeLag(event, time.Now())
+	}
+}
+
+// stageError is an error raised while handling a single event, tagged with
+// the stage it failed at.
+type stageError struct {
+	stage string
+	err   error
+}
+
+func (e *stageError) Error() string {
+	return fmt.Sprintf("%s: %s", e.stage, e.err)
+}
+
+func (e *stageError) Unwrap() error {
+	return e.err
+}
+
+// depth returns the number of events waiting in the queue.
+func (p *pipeline) depth() int {
+	return len(p.events)
+}
+
+// gate blocks the pipeline reader while the replicator is paused without
+// polling the replicator state.
+type gate struct {
+	mu sync.Mutex
+	// ch is closed while the gate is open
+	ch chan struct{}
+}
+
+func newGate() *gate {
+	ch := make(chan struct{})
+	close(ch)
+	return &gate{ch: ch}
+}
+
+func (g *gate) open() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	select {
+	case <-g.ch:
+	default:
+		close(g.ch)
+	}
+}
+
+func (g *gate) close() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	select {
+	case <-g.ch:
+		g.ch = make(chan struct{})
+	default:
+	}
+}
+
+func (g *gate) wait(ctx context.Context) error {
+	g.mu.Lock()
+	ch := g.ch
+	g.mu.Unlock()
+
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
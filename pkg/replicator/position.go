@@ -0,0 +1,151 @@
+package replicator
+
+import (
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// ErrPositionsUnordered is returned when comparing positions which have no
+// order, such as positions of different sources or raw positions.
+var ErrPositionsUnordered = errors.New("positions are not ordered")
+
+// Position is a decoded source position. Events and checkpoints carry
+// positions in their encoded form, which sources decode with their
+// PositionCodec.
+type Position interface {
+	// Bytes is the encoded position, as stored in checkpoints
+	Bytes() []byte
+
+	// String renders the position for logs and the API
+	String() string
+}
+
+// OrderedPosition is implemented by positions of sources which read changes
+// in order, such as Postgres LSNs.
+type OrderedPosition interface {
+	Position
+
+	// Compare returns -1 if the position is before other, 0 if they are
+	// equal and +1 if it is after, or ErrPositionsUnordered if other is
+	// not a position of the same kind.
+	Compare(other Position) (int, error)
+}
+
+// PositionCodec decodes the positions of a source.
+type PositionCodec interface {
+	// Decode decodes an encoded position, such as the position of a
+	// checkpoint
+	Decode(data []byte) (Position, error)
+
+	// Parse parses a position given by a user, which may be in its encoded
+	// or rendered form
+	Parse(text string) (Position, error)
+}
+
+// PositionCoder is implemented by sources with typed positions. Positions of
+// other sources are RawPositions.
+type PositionCoder interface {
+	Positions() PositionCodec
+}
+
+// RawPosition is a position the replicator knows nothing about.
+type RawPosition []byte
+
+func (p RawPosition) Bytes() []byte {
+	return p
+}
+
+func (p RawPosition) String() string {
+	return string(p)
+}
+
+type rawPositions struct{}
+
+func (rawPositions) Decode(data []byte) (Position, error) {
+	return RawPosition(data), nil
+}
+
+func (rawPositions) Parse(text string) (Position, error) {
+	return RawPosition(text), nil
+}
+
+// RawPositions accepts any position.
+var RawPositions PositionCodec = rawPositions{}
+
+// ComparePositions compares a to b, or returns ErrPositionsUnordered if a is
+// not an OrderedPosition.
+func ComparePositions(a, b Position) (int, error) {
+	if o, ok := a.(OrderedPosition); ok {
+		return o.Compare(b)
+	}
+	return 0, ErrPositionsUnordered
+}
+
+// positions returns the codec of the source of the replicator.
+func (r *Replicator) positions() PositionCodec {
+	if c, ok := r.Source.(PositionCoder); ok {
+		return c.Positions()
+	}
+	return RawPositions
+}
+
+// renderPosition renders an encoded position for logs, as is if the source
+// cannot decode it.
+func (r *Replicator) renderPosition(data []byte) string {
+	return renderPosition(r.positions(), data)
+}
+
+func renderPosition(codec PositionCodec, data []byte) string {
+	if codec == nil {
+		return string(data)
+	}
+	position, err := codec.Decode(data)
+	if err != nil {
+		return string(data)
+	}
+	return position.String()
+}
+
+// checkPosition refuses to resume from a checkpoint whose position the
+// source cannot decode, rather than letting the source start over from its
+// default position. Empty positions are left to the source.
+func (r *Replicator) checkPosition(checkpoint *Checkpoint) error {
+	if checkpoint == nil || len(checkpoint.Position) == 0 {
+		return nil
+	}
+	if _, err := r.positions().Decode(checkpoint.Position); err != nil {
+		return fmt.Errorf("%w: checkpoint of %s: %v", ErrInvalidPosition, checkpoint.ReplicatorID, err)
+	}
+	return nil
+}
+
+// checkRegression warns when a checkpoint moves behind the previous one. The
+// ack tracker only releases positions in the order the source read them, so
+// a regression means the source handed out positions out of order; the
+// checkpoint is still saved, since replaying events is safe.
+func (r *Replicator) checkRegression(previous *Checkpoint, position []byte) {
+	if previous == nil {
+		return
+	}
+	codec := r.positions()
+	from, err := codec.Decode(previous.Position)
+	if err != nil {
+		return
+	}
+	to, err := codec.Decode(position)
+	if err != nil {
+		return
+	}
+	if c, err := ComparePositions(to, from); err == nil && c < 0 {
+		r.logger.Warn("Checkpoint position regressed",
+			zap.String("replicator_id", r.ID),
+			zap.Stringer("previous", from),
+			zap.Stringer("position", to))
+
+		r.mu.Lock()
+		r.stats.Replicator.PositionRegressions++
+		r.mu.Unlock()
+	}
+}
@@ -0,0 +1,128 @@
+package replicator
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// seqPosition is the sequence number of a test event.
+type seqPosition int
+
+func (p seqPosition) Bytes() []byte  { return []byte(strconv.Itoa(int(p))) }
+func (p seqPosition) String() string { return fmt.Sprintf("seq:%d", int(p)) }
+
+func (p seqPosition) Compare(other Position) (int, error) {
+	o, ok := other.(seqPosition)
+	if !ok {
+		return 0, ErrPositionsUnordered
+	}
+	switch {
+	case p < o:
+		return -1, nil
+	case p > o:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+type seqPositions struct{}
+
+func (seqPositions) Decode(data []byte) (Position, error) {
+	i, err := strconv.Atoi(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("expected a sequence number: %w", err)
+	}
+	return seqPosition(i), nil
+}
+
+func (c seqPositions) Parse(text string) (Position, error) {
+	return c.Decode([]byte(strings.TrimPrefix(text, "seq:")))
+}
+
+// sequencedSource is a memory source with sequence number positions.
+type sequencedSource struct {
+	*memorySource
+}
+
+func (sequencedSource) Positions() PositionCodec { return seqPositions{} }
+
+func TestReplicatorPositions(t *testing.T) {
+	newReplicator := func(checkpoint *Checkpoint) (*Replicator, *memorySource, *memoryCheckpointer) {
+		source := newMemorySource()
+		checkpointer := &memoryCheckpointer{}
+		if checkpoint != nil {
+			checkpointer.saved = append(checkpointer.saved, checkpoint)
+		}
+		r, err := New(
+			WithID("orders"),
+			WithSource(sequencedSource{source}),
+			WithTarget(&memoryTarget{}),
+			WithCheckpointer(checkpointer),
+			WithSourceOptions(SourceOptions{CheckpointBatchSize: 1}),
+		)
+		require.NoError(t, err)
+		return r, source, checkpointer
+	}
+
+	t.Run("refuses a checkpoint the source cannot decode", func(t *testing.T) {
+		r, source, _ := newReplicator(&Checkpoint{ReplicatorID: "orders", Position: []byte("0/16B3748")})
+
+		err := r.Run(context.Background())
+		assert.ErrorIs(t, err, ErrInvalidPosition)
+		assert.Equal(t, StateError, r.State.Current())
+		assert.Zero(t, source.connects)
+	})
+
+	t.Run("counts regressions", func(t *testing.T) {
+		r, source, checkpointer := newReplicator(&Checkpoint{ReplicatorID: "orders", Position: []byte("5")})
+		cancel, done := runReplicator(t, r)
+		defer cancel()
+
+		for i, seq := range []int{6, 3} {
+			source.events <- testEvent(seq)
+			require.Eventually(t, func() bool {
+				return checkpointer.count() == i+2
+			}, time.Second, time.Millisecond)
+		}
+
+		stats := r.Stats().Replicator
+		assert.Equal(t, int64(1), stats.PositionRegressions)
+		assert.Equal(t, "seq:3", stats.CheckpointPosition)
+
+		cancel()
+		require.NoError(t, <-done)
+	})
+
+	t.Run("parses rendered positions", func(t *testing.T) {
+		r, _, _ := newReplicator(nil)
+
+		checkpoint, err := r.SetCheckpoint(context.Background(), "seq:42")
+		require.NoError(t, err)
+		assert.Equal(t, "42", string(checkpoint.Position))
+
+		info := NewCheckpointInfo(checkpoint, r.positions())
+		assert.Equal(t, "42", info.Position)
+		assert.Equal(t, "seq:42", info.RenderedPosition)
+
+		_, err = r.SetCheckpoint(context.Background(), "latest")
+		assert.ErrorIs(t, err, ErrInvalidPosition)
+	})
+}
+
+func TestComparePositions(t *testing.T) {
+	c, err := ComparePositions(seqPosition(1), seqPosition(2))
+	require.NoError(t, err)
+	assert.Equal(t, -1, c)
+
+	_, err = ComparePositions(seqPosition(1), RawPosition("2"))
+	assert.ErrorIs(t, err, ErrPositionsUnordered)
+	_, err = ComparePositions(RawPosition("1"), RawPosition("2"))
+	assert.ErrorIs(t, err, ErrPositionsUnordered)
+}
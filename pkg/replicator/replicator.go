@@ -4,8 +4,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"maps"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
@@ -28,12 +34,24 @@ type TargetOptions struct {
 }
 
 type SourceOptions struct {
-	// interval to poll the source when no events are found
 	// source.checkpoint.batch_size
+	// checkpoint once this many events have been delivered since the last
+	// checkpoint. 0 disables count based checkpoints.
 	CheckpointBatchSize int
 
+	// source.checkpoint.interval
+	// checkpoint delivered events at least this often. 0 disables time based
+	// checkpoints.
+	CheckpointInterval time.Duration
+
 	// source.empty.poll_interval
+	// interval to poll the source when no events are found
 	EmptyPollInterval time.Duration
+
+	// source.checkpoint.allow_mismatch
+	// resume from a checkpoint saved for a different source, or in a newer
+	// format, instead of refusing to start
+	AllowSourceMismatch bool
 	/*
 	   - source.mongodb.batch_size
 	   - source.mongodb.starting_position
@@ -43,6 +61,23 @@ type SourceOptions struct {
 	*/
 }
 
+type PipelineOptions struct {
+	// pipeline.queue_size
+	// number of events buffered between the source reader and the target
+	// writer. The reader blocks once the queue is full.
+	QueueSize int
+
+	// pipeline.drain_timeout
+	// how long a stopping replicator waits for queued events to be delivered
+	// and acknowledged before it disconnects anyway
+	DrainTimeout time.Duration
+}
+
+// checkpointing reports whether any checkpoint trigger is configured.
+func (o SourceOptions) checkpointing() bool {
+	return o.CheckpointBatchSize > 0 || o.CheckpointInterval > 0
+}
+
 type Source interface {
 	Connect(context.Context, *Checkpoint) error
 	Disconnect(context.Context) error
@@ -59,21 +94,74 @@ type Target interface {
 	Stats() TargetStats
 }
 
+// AckFunc is called by a target once delivery of the event with the given
+// sequence has been confirmed (err == nil) or has failed.
+type AckFunc func(seq uint64, err error)
+
+// Acknowledger is implemented by targets that deliver events asynchronously,
+// such as producers which only enqueue in Write. The replicator registers an
+// AckFunc before connecting the target and only checkpoints positions once
+// the target has acknowledged them. Targets that do not implement
+// Acknowledger are treated as synchronous: an event is acknowledged as soon
+// as Write returns without error.
+type Acknowledger interface {
+	OnAck(fn AckFunc)
+}
+
 type Replicator struct {
-	Checkpointer  Checkpointer
-	Source        Source
-	SourceOptions SourceOptions
-	State         *FSM
-	Target        Target
-	TargetOptions TargetOptions
+	Checkpointer    Checkpointer
+	DeadLetterQueue DeadLetterQueue
+	ErrorOptions    ErrorOptions
+	Filters         []Filter
+	LeaseOptions    LeaseOptions
+	Leaser          Leaser
+	PipelineOptions PipelineOptions
+	RetryOptions    RetryOptions
+	Source          Source
+	SourceOptions   SourceOptions
+	State           *FSM
+	Target          Target
+	TargetOptions   TargetOptions
+	Transforms      Chain
+
+	// TailRedactedFields are masked in the events streamed by the tail
+	// endpoint
+	TailRedactedFields []string
 
 	ID string
 
 	// Control channel for receiving signals
 	controlChan    chan Signal
+	checkpointChan chan checkpointRequest
+	fingerprint    *SourceFingerprint
+	gate           *gate
 	lastCheckpoint *Checkpoint
+	metrics        *metrics
+	tail           *tail
+	tracerProvider trace.TracerProvider
+	tracer         trace.Tracer
 	logger         *zap.Logger
-	stats          Stats
+
+	// acknowledges is true when the target implements Acknowledger
+	acknowledges bool
+	sequence     atomic.Uint64
+
+	// retries counts consecutive reconnect attempts; only the control loop
+	// touches it
+	retries int
+
+	// rewind is set once the checkpoint of a paused replicator has been
+	// moved; the source reconnects from it on resume. Only the control loop
+	// touches it
+	rewind bool
+
+	// mu guards stats, the running pipeline, the held lease and running,
+	// which is closed when Run returns
+	mu       sync.RWMutex
+	lease    Lease
+	pipeline *pipeline
+	running  chan struct{}
+	stats    Stats
 }
 
 type ReplicatorOption func(*Replicator)
@@ -84,6 +172,30 @@ func WithCheckpointer(checkpointer Checkpointer) ReplicatorOption {
 	}
 }
 
+func WithDeadLetterQueue(dlq DeadLetterQueue) ReplicatorOption {
+	return func(r *Replicator) {
+		r.DeadLetterQueue = dlq
+	}
+}
+
+func WithErrorOptions(errorOptions ErrorOptions) ReplicatorOption {
+	return func(r *Replicator) {
+		r.ErrorOptions = errorOptions
+	}
+}
+
+func WithPipelineOptions(pipelineOptions PipelineOptions) ReplicatorOption {
+	return func(r *Replicator) {
+		r.PipelineOptions = pipelineOptions
+	}
+}
+
+func WithRetryOptions(retryOptions RetryOptions) ReplicatorOption {
+	return func(r *Replicator) {
+		r.RetryOptions = retryOptions
+	}
+}
+
 func WithSourceOptions(sourceOptions SourceOptions) ReplicatorOption {
 	return func(r *Replicator) {
 		r.SourceOptions = sourceOptions
@@ -114,6 +226,22 @@ func WithTarget(target Target) ReplicatorOption {
 	}
 }
 
+// WithFilters appends filters to the ones every event must match to be
+// replicated. Filters run before the transforms.
+func WithFilters(filters ...Filter) ReplicatorOption {
+	return func(r *Replicator) {
+		r.Filters = append(r.Filters, filters...)
+	}
+}
+
+// WithTransforms appends transforms to the chain applied to every event
+// before it is written to the target.
+func WithTransforms(transforms ...Transform) ReplicatorOption {
+	return func(r *Replicator) {
+		r.Transforms = append(r.Transforms, transforms...)
+	}
+}
+
 func WithTargetOptions(targetOptions TargetOptions) ReplicatorOption {
 	return func(r *Replicator) {
 		r.TargetOptions = targetOptions
@@ -123,21 +251,51 @@ func WithTargetOptions(targetOptions TargetOptions) ReplicatorOption {
 func New(opts ...ReplicatorOption) (*Replicator, error) {
 	r := &Replicator{
 		Checkpointer: &NoopCheckpointer{},
+		ErrorOptions: ErrorOptions{
+			Policy: ErrorPolicyFail,
+		},
+		LeaseOptions: LeaseOptions{
+			RetryInterval: 5 * time.Second,
+		},
+		PipelineOptions: PipelineOptions{
+			QueueSize:    1024,
+			DrainTimeout: 30 * time.Second,
+		},
+		RetryOptions: RetryOptions{
+			InitialBackoff: time.Second,
+			MaxBackoff:     30 * time.Second,
+			Multiplier:     2,
+			Jitter:         0.2,
+		},
 		SourceOptions: SourceOptions{
 			EmptyPollInterval: 100 * time.Millisecond,
 		},
-		logger:      zap.NewNop(),
-		controlChan: make(chan Signal, 1), // Buffered to prevent blocking
+		logger:         zap.NewNop(),
+		controlChan:    make(chan Signal, 1), // Buffered to prevent blocking
+		checkpointChan: make(chan checkpointRequest),
+		gate:           newGate(),
+		tail:           newTail(),
 	}
 
 	for _, opt := range opts {
 		opt(r)
 	}
+	if r.LeaseOptions.Holder == "" {
+		r.LeaseOptions.Holder = defaultLeaseHolder()
+	}
+	if r.LeaseOptions.RetryInterval <= 0 {
+		r.LeaseOptions.RetryInterval = 5 * time.Second
+	}
 
 	r.State = NewFSM(
 		FSMWithInitialState(StateCreated),
 		FSMWithLogger(r.logger.Named("fsm")),
 	)
+	r.metrics = newMetrics(r.ID, typeOf(r.Source))
+	if r.tracerProvider == nil {
+		r.tracerProvider = otel.GetTracerProvider()
+	}
+	r.tracer = r.tracerProvider.Tracer(tracerName)
 
 	r.logger.Info("Replicator created", zap.String("state", string(r.State.Current())))
 	return r, nil
@@ -150,21 +308,59 @@ func New(opts ...ReplicatorOption) (*Replicator, error) {
 - Ephemeral - As part of librarian process
 */
 
-// Run the replicator
+// Run the replicator. With a Leaser, the replicator waits in the standby
+// state until it acquires its lease, and returns to it whenever the lease is
+// lost.
 func (r *Replicator) Run(ctx context.Context) error {
+	if r.ErrorOptions.Policy == ErrorPolicyDLQ && r.DeadLetterQueue == nil {
+		return fmt.Errorf("error policy %q requires a dead letter queue", ErrorPolicyDLQ)
+	}
+
+	if r.Leaser == nil {
+		return r.run(ctx)
+	}
+
+	for {
+		lease, err := r.acquireLease(ctx)
+		if lease == nil {
+			return err
+		}
+
+		err = r.run(ctx)
+		r.releaseLease(lease)
+		if !errors.Is(err, ErrLeaseLost) {
+			return err
+		}
+	}
+}
+
+// run connects and streams until the replicator stops, fails or loses its
+// lease.
+func (r *Replicator) run(ctx context.Context) error {
 	if err := r.State.Transition(StateConnecting); err != nil {
 		return err
 	}
 
+	running := make(chan struct{})
+	defer close(running)
+	r.mu.Lock()
+	r.running = running
+	r.mu.Unlock()
+
 	r.logger.Info("Starting replicator",
 		zap.String("state", string(r.State.Current())),
+		zap.String("pipeline-options", fmt.Sprintf("%+v", r.PipelineOptions)),
+		zap.String("retry-options", fmt.Sprintf("%+v", r.RetryOptions)),
+		zap.String("error-options", fmt.Sprintf("%+v", r.ErrorOptions)),
 		zap.String("source-options", fmt.Sprintf("%+v", r.SourceOptions)),
 		zap.String("target-options", fmt.Sprintf("%+v", r.TargetOptions)),
 	)
 
 	// Initialize replicator stats
+	r.mu.Lock()
 	r.stats.Replicator.StartedAt = time.Now()
-	r.stats.Replicator.State = r.State.Current()
+	r.stats.Replicator.QueueCapacity = r.PipelineOptions.QueueSize
+	r.mu.Unlock()
 
 	// load the checkpoint from the Checkpointer
 	var checkpoint *Checkpoint
@@ -178,12 +374,36 @@ func (r *Replicator) Run(ctx context.Context) error {
 	if checkpoint != nil {
 		r.logger.Info("Loaded checkpoint",
 			zap.String("replicator_id", r.ID),
-			zap.String("position", string(checkpoint.Position)),
+			zap.String("position", r.renderPosition(checkpoint.Position)),
 			zap.Time("timestamp", checkpoint.Timestamp))
 	} else {
 		r.logger.Info("No checkpoint found, starting fresh",
 			zap.String("replicator_id", r.ID))
 	}
+	// the source must not connect from a position of another source
+	fingerprint, err := r.sourceFingerprint(ctx)
+	if err == nil {
+		err = r.checkSource(checkpoint, fingerprint)
+	}
+	if err == nil {
+		err = r.checkPosition(checkpoint)
+	}
+	if err != nil {
+		r.State.Transition(StateError)
+		return err
+	}
+	r.fingerprint = fingerprint
+	r.lastCheckpoint = checkpoint
+	if checkpoint != nil {
+		r.mu.Lock()
+		r.stats.Replicator.CheckpointPosition = r.renderPosition(checkpoint.Position)
+		r.mu.Unlock()
+	}
+
+	if acker, ok := r.Target.(Acknowledger); ok {
+		r.acknowledges = true
+		acker.OnAck(r.ack)
+	}
 
 	// connect to target
 	if err := r.Target.Connect(ctx); err != nil {
@@ -203,25 +423,40 @@ func (r *Replicator) Run(ctx context.Context) error {
 
 	r.logger.Info("Replicator started", zap.String("state", string(r.State.Current())))
 
-	var flushTicker *time.Ticker
-	var flushChan <-chan time.Time
-	if r.TargetOptions.FlushTimeout > 0 {
-		flushTicker = time.NewTicker(r.TargetOptions.FlushTimeout)
-		flushChan = flushTicker.C
-		defer flushTicker.Stop()
-	}
+	p := r.startPipeline(ctx)
 
-	// TODO add flush ticker based on target options
+	// The control loop only reacts to signals, cancellation and pipeline
+	// failures; events flow through the pipeline goroutines.
 	for {
 		select {
 		case <-ctx.Done():
 			r.logger.Info("Context cancelled, stopping replicator")
-			r.State.Transition(StateStopped)
-			return r.Source.Disconnect(ctx)
+			return r.shutdown(p)
+
+		case <-r.leaseLost():
+			return r.standby(p, ErrLeaseLost)
+
+		case perr := <-p.errs:
+			p.stop()
+			if errors.Is(perr.err, ErrLeaseLost) || errors.Is(perr.err, ErrStaleEpoch) {
+				return r.standby(p, perr.err)
+			}
+			next, err := r.reconnect(ctx, p, perr)
+			if next == nil {
+				if err == nil {
+					err = r.disconnect(context.Background())
+				}
+				return err
+			}
+			p = next
 
 		case signal := <-r.controlChan:
+			r.mu.Lock()
 			r.stats.Replicator.SignalsReceived++
-			if err := r.handleSignal(ctx, signal); err != nil {
+			r.mu.Unlock()
+
+			next, err := r.handleSignal(ctx, signal, p)
+			if err != nil {
 				r.logger.Error("Error handling signal",
 					zap.String("signal", string(signal)),
 					zap.Error(err))
@@ -232,54 +467,10 @@ func (r *Replicator) Run(ctx context.Context) error {
 			if r.State.Current() == StateStopped {
 				return nil
 			}
+			p = next
 
-			// If paused, wait for resume or other signals
-			if r.State.Current() == StatePaused {
-				continue
-			}
-		case <-flushChan:
-			r.logger.Debug("Flushing to target")
-			if err := r.Target.Flush(ctx); err != nil {
-				r.logger.Error("Error flushing to target", zap.Error(err))
-				return err
-			}
-		default:
-			// Only process events if we're in streaming state
-			if r.State.Current() != StateStreaming {
-				time.Sleep(100 * time.Millisecond) // Brief pause
-				continue
-			}
-
-			// Process next event with timeout to allow signal checking
-			event, err := r.Source.Next(ctx)
-
-			if err == ErrNoEventsFound {
-				time.Sleep(r.SourceOptions.EmptyPollInterval)
-				continue
-			}
-
-			if err != nil {
-				r.State.Transition(StateError)
-				return err
-			}
-
-			// Write event to target
-			if err := r.Target.Write(ctx, event); err != nil {
-				r.logger.Error("Error writing to target", zap.Error(err))
-				r.State.Transition(StateError)
-				return err
-			}
-
-			if err := r.checkpoint(ctx, event); err != nil {
-				r.logger.Error("Error checkpointing", zap.Error(err))
-				return err
-			}
-
-			// Update replicator stats (source stats are tracked in source itself)
-			r.stats.Replicator.State = r.State.Current()
-			if !r.stats.Replicator.StartedAt.IsZero() {
-				r.stats.Replicator.UptimeSeconds = int64(time.Since(r.stats.Replicator.StartedAt).Seconds())
-			}
+		case req := <-r.checkpointChan:
+			req.reply <- r.moveCheckpoint(ctx, p, req.change)
 		}
 	}
 }
@@ -294,79 +485,190 @@ func (r *Replicator) SendSignal(signal Signal) {
 	}
 }
 
-func (r *Replicator) handleSignal(ctx context.Context, signal Signal) error {
+// handleSignal applies a control signal and returns the pipeline that is
+// running once the signal has been handled.
+func (r *Replicator) handleSignal(ctx context.Context, signal Signal, p *pipeline) (*pipeline, error) {
 	currentState := r.State.Current()
 
 	switch signal {
 	case SignalPause:
 		if currentState == StateStreaming {
 			r.logger.Info("Pausing replicator")
-			return r.State.Transition(StatePaused)
+			r.gate.close()
+			return p, r.State.Transition(StatePaused)
 		}
 		r.logger.Warn("Cannot pause from current state", zap.String("state", string(currentState)))
 
 	case SignalResume:
 		if currentState == StatePaused {
+			if r.rewind {
+				r.logger.Info("Resuming replicator from the moved checkpoint")
+				return r.restart(ctx, p)
+			}
 			r.logger.Info("Resuming replicator")
-			return r.State.Transition(StateStreaming)
+			r.gate.open()
+			return p, r.State.Transition(StateStreaming)
 		}
 		r.logger.Warn("Cannot resume from current state", zap.String("state", string(currentState)))
 
 	case SignalStop:
 		r.logger.Info("Stopping replicator")
-		r.State.Transition(StateStopped)
-		return r.Source.Disconnect(ctx)
+		return p, r.shutdown(p)
 
 	case SignalRestart:
 		r.logger.Info("Restarting replicator")
+		return r.restart(ctx, p)
 
-		// Disconnect and reconnect
-		if err := r.Source.Disconnect(ctx); err != nil {
-			r.logger.Error("Error disconnecting during restart", zap.Error(err))
-		}
+	default:
+		r.logger.Warn("Unknown signal received", zap.String("signal", string(signal)))
+	}
 
-		if err := r.State.Transition(StateConnecting); err != nil {
-			return err
-		}
+	return p, nil
+}
 
-		if err := r.Source.Connect(ctx, r.lastCheckpoint); err != nil {
-			r.State.Transition(StateError)
-			return err
-		}
+// restart stops the pipeline, reconnects the source from the last checkpoint
+// and streams again.
+func (r *Replicator) restart(ctx context.Context, p *pipeline) (*pipeline, error) {
+	p.stop()
+	r.rewind = false
 
-		return r.State.Transition(StateStreaming)
+	// Disconnect and reconnect
+	if err := r.Source.Disconnect(ctx); err != nil {
+		r.logger.Error("Error disconnecting during restart", zap.Error(err))
+	}
 
-	default:
-		r.logger.Warn("Unknown signal received", zap.String("signal", string(signal)))
+	if err := r.State.Transition(StateConnecting); err != nil {
+		return p, err
 	}
 
-	return nil
+	if err := r.Source.Connect(ctx, r.lastCheckpoint); err != nil {
+		r.State.Transition(StateError)
+		return p, err
+	}
+
+	r.gate.open()
+	if err := r.State.Transition(StateStreaming); err != nil {
+		return p, err
+	}
+	return r.startPipeline(ctx), nil
+}
+
+// shutdown drains the pipeline, saving a final checkpoint, and disconnects
+// the source and target. Draining and disconnecting share the DrainTimeout
+// deadline.
+func (r *Replicator) shutdown(p *pipeline) error {
+	if err := r.State.Transition(StateDraining); err != nil {
+		p.stop()
+		r.State.Transition(StateStopped)
+		return r.disconnect(context.Background())
+	}
+
+	ctx := context.Background()
+	if r.PipelineOptions.DrainTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.PipelineOptions.DrainTimeout)
+		defer cancel()
+	}
+
+	r.logger.Info("Draining replicator",
+		zap.Int("queued_events", p.depth()),
+		zap.Int("in_flight_events", p.tracker.inFlight()),
+		zap.Duration("timeout", r.PipelineOptions.DrainTimeout))
+
+	if err := p.drain(ctx); err != nil {
+		r.logger.Warn("Drain timed out, undelivered events will be replayed", zap.Error(err))
+	}
+
+	err := r.disconnect(ctx)
+	r.State.Transition(StateStopped)
+	return err
+}
+
+// disconnect disconnects the source and closes the target and the dead letter
+// queue.
+func (r *Replicator) disconnect(ctx context.Context) error {
+	sourceErr := r.Source.Disconnect(ctx)
+	if sourceErr != nil {
+		r.logger.Error("Error disconnecting source", zap.Error(sourceErr))
+	}
+
+	targetErr := r.Target.Close(ctx)
+	if targetErr != nil {
+		r.logger.Error("Error closing target", zap.Error(targetErr))
+	}
+
+	var dlqErr error
+	if r.DeadLetterQueue != nil {
+		if dlqErr = r.DeadLetterQueue.Close(ctx); dlqErr != nil {
+			r.logger.Error("Error closing dead letter queue", zap.Error(dlqErr))
+		}
+	}
+
+	return errors.Join(sourceErr, targetErr, dlqErr)
+}
+
+// ack routes a delivery acknowledgement from the target to the running
+// pipeline. Acknowledgements that arrive while no pipeline is running belong
+// to events that will be redelivered and are ignored.
+func (r *Replicator) ack(seq uint64, err error) {
+	r.mu.RLock()
+	p := r.pipeline
+	r.mu.RUnlock()
+
+	if p != nil {
+		p.ack(seq, err)
+	}
 }
 
-func (r *Replicator) checkpoint(ctx context.Context, latestEvent Event) error {
-	if r.Checkpointer == nil || r.SourceOptions.CheckpointBatchSize == 0 {
+// checkpoint saves the position of the highest contiguous event the target
+// has acknowledged. count is the number of events acknowledged since the
+// previous checkpoint.
+func (r *Replicator) checkpoint(ctx context.Context, position []byte, count int) error {
+	if r.Checkpointer == nil {
 		return nil
 	}
 
+	epoch, err := r.leaseEpoch()
+	if err != nil {
+		return err
+	}
+	r.checkRegression(r.lastCheckpoint, position)
+
 	checkpoint := &Checkpoint{
 		ReplicatorID: r.ID,
-		Position:     latestEvent.Position,
+		Position:     position,
 		Timestamp:    time.Now(),
+		Events:       count,
+		Epoch:        epoch,
+		Source:       r.fingerprint,
+		Version:      CheckpointVersion,
 	}
 
-	if err := r.Checkpointer.Save(ctx, checkpoint); err != nil {
+	ctx, span := r.tracer.Start(ctx, spanCheckpointerSave, trace.WithAttributes(
+		attribute.String("librarian.replicator_id", r.ID),
+		attribute.String("librarian.position", r.renderPosition(position)),
+		attribute.Int("librarian.events", count),
+	))
+	err = r.Checkpointer.Save(ctx, checkpoint)
+	endSpan(span, err)
+	if err != nil {
 		return err
 	}
 
 	r.lastCheckpoint = checkpoint
+	rendered := r.renderPosition(checkpoint.Position)
 
 	// Update checkpoint stats
+	r.mu.Lock()
 	r.stats.Replicator.CheckpointCount++
 	r.stats.Replicator.LastCheckpointAt = time.Now()
+	r.stats.Replicator.CheckpointPosition = rendered
+	r.mu.Unlock()
 
 	r.logger.Info("Checkpoint saved",
 		zap.String("replicator_id", r.ID),
-		zap.String("position", string(checkpoint.Position)),
+		zap.String("position", rendered),
+		zap.Int("events", count),
 		zap.Time("timestamp", checkpoint.Timestamp))
 
 	return nil
@@ -374,11 +676,22 @@ func (r *Replicator) checkpoint(ctx context.Context, latestEvent Event) error {
 
 // Stats returns comprehensive stats including source and replicator metrics
 func (r *Replicator) Stats() Stats {
+	r.mu.RLock()
 	stats := Stats{
 		Source:     r.Source.Stats(),
 		Target:     r.Target.Stats(),
 		Replicator: r.stats.Replicator,
 	}
+	stats.Target.RetryCount = r.stats.Target.RetryCount
+	stats.Target.SkippedEvents = r.stats.Target.SkippedEvents
+	stats.Target.DeadLetterEvents = r.stats.Target.DeadLetterEvents
+	stats.Replicator.TableLag = maps.Clone(r.stats.Replicator.TableLag)
+	if r.pipeline != nil {
+		stats.Replicator.QueueDepth = r.pipeline.depth()
+		stats.Replicator.InFlightEvents = r.pipeline.tracker.inFlight()
+		stats.Replicator.PendingCheckpointEvents = r.pipeline.tracker.acked()
+	}
+	r.mu.RUnlock()
 
 	// Update runtime stats
 	stats.Replicator.State = r.State.Current()
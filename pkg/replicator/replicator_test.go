@@ -1,7 +1,19 @@
 package replicator
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
 )
 
 func TestNewReplicator(t *testing.T) {
@@ -13,3 +25,836 @@ func TestNewReplicator(t *testing.T) {
 		t.Fatalf("expected state %v, got %v", StateCreated, r.State)
 	}
 }
+
+// memorySource emits the events sent on its channel.
+type memorySource struct {
+	events chan Event
+
+	mu          sync.Mutex
+	connects    int
+	checkpoints []*Checkpoint
+	err         error
+}
+
+func newMemorySource() *memorySource {
+	return &memorySource{events: make(chan Event, 100)}
+}
+
+func (s *memorySource) Connect(ctx context.Context, checkpoint *Checkpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connects++
+	s.checkpoints = append(s.checkpoints, checkpoint)
+	return nil
+}
+
+func (s *memorySource) Disconnect(ctx context.Context) error { return nil }
+
+func (s *memorySource) Next(ctx context.Context) (Event, error) {
+	s.mu.Lock()
+	err := s.err
+	s.mu.Unlock()
+	if err != nil {
+		return Event{}, err
+	}
+
+	select {
+	case e := <-s.events:
+		return e, nil
+	case <-ctx.Done():
+		return Event{}, ctx.Err()
+	case <-time.After(10 * time.Millisecond):
+		return Event{}, ErrNoEventsFound
+	}
+}
+
+func (s *memorySource) Stats() SourceStats { return SourceStats{} }
+
+func (s *memorySource) setErr(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+// memoryTarget records every event written to it.
+type memoryTarget struct {
+	mu     sync.Mutex
+	events []Event
+	closed bool
+	// block, when set, stalls writes until it is closed
+	block chan struct{}
+}
+
+func (t *memoryTarget) Close(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	return nil
+}
+
+func (t *memoryTarget) Connect(ctx context.Context) error    { return nil }
+func (t *memoryTarget) Disconnect(ctx context.Context) error { return nil }
+func (t *memoryTarget) Flush(ctx context.Context) error      { return nil }
+func (t *memoryTarget) Stats() TargetStats                   { return TargetStats{} }
+
+func (t *memoryTarget) Write(ctx context.Context, event Event) error {
+	if t.block != nil {
+		select {
+		case <-t.block:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.events = append(t.events, event)
+	return nil
+}
+
+func (t *memoryTarget) written() []Event {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Event(nil), t.events...)
+}
+
+// asyncTarget acknowledges writes only when release is called.
+type asyncTarget struct {
+	memoryTarget
+	ackFn AckFunc
+}
+
+func (t *asyncTarget) OnAck(fn AckFunc) { t.ackFn = fn }
+
+func (t *asyncTarget) release(err error) {
+	for _, e := range t.written() {
+		t.ackFn(e.Sequence, err)
+	}
+}
+
+// memoryCheckpointer keeps every saved checkpoint.
+type memoryCheckpointer struct {
+	mu    sync.Mutex
+	saved []*Checkpoint
+}
+
+func (c *memoryCheckpointer) Load(ctx context.Context, replicatorID string) (*Checkpoint, error) {
+	return c.last(), nil
+}
+
+func (c *memoryCheckpointer) Save(ctx context.Context, checkpoint *Checkpoint) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.saved = append(c.saved, checkpoint)
+	return nil
+}
+
+func (c *memoryCheckpointer) Delete(ctx context.Context, replicatorID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.saved = nil
+	return nil
+}
+
+func (c *memoryCheckpointer) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.saved)
+}
+
+func (c *memoryCheckpointer) last() *Checkpoint {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.saved) == 0 {
+		return nil
+	}
+	return c.saved[len(c.saved)-1]
+}
+
+func testEvent(i int) Event {
+	return Event{
+		Payload: Payload{
+			Source: EventSource{Db: "test", Schema: "public", Table: "users"},
+			Op:     OpCreate,
+			After:  map[string]interface{}{"id": i},
+		},
+		Position: []byte(fmt.Sprintf("%d", i)),
+	}
+}
+
+func runReplicator(t *testing.T, r *Replicator) (context.CancelFunc, chan error) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- r.Run(ctx) }()
+
+	require.Eventually(t, func() bool {
+		return r.State.Current() == StateStreaming
+	}, time.Second, time.Millisecond)
+	return cancel, done
+}
+
+func TestReplicatorPipeline(t *testing.T) {
+	t.Run("delivers events in order", func(t *testing.T) {
+		source := newMemorySource()
+		target := &memoryTarget{}
+		r, err := New(WithID("test"), WithSource(source), WithTarget(target))
+		require.NoError(t, err)
+
+		cancel, done := runReplicator(t, r)
+		defer cancel()
+
+		for i := 0; i < 10; i++ {
+			source.events <- testEvent(i)
+		}
+
+		require.Eventually(t, func() bool {
+			return len(target.written()) == 10
+		}, time.Second, time.Millisecond)
+		for i, e := range target.written() {
+			assert.Equal(t, []byte(fmt.Sprintf("%d", i)), e.Position)
+		}
+
+		cancel()
+		require.NoError(t, <-done)
+		assert.Equal(t, StateStopped, r.State.Current())
+	})
+
+	t.Run("backpressure bounds the queue", func(t *testing.T) {
+		source := newMemorySource()
+		target := &memoryTarget{block: make(chan struct{})}
+		r, err := New(
+			WithSource(source),
+			WithTarget(target),
+			WithPipelineOptions(PipelineOptions{QueueSize: 2}),
+		)
+		require.NoError(t, err)
+
+		cancel, done := runReplicator(t, r)
+		defer cancel()
+
+		for i := 0; i < 10; i++ {
+			source.events <- testEvent(i)
+		}
+
+		// one event is held by the writer, two wait in the queue and one is
+		// held by the blocked reader
+		require.Eventually(t, func() bool {
+			return len(source.events) == 6
+		}, time.Second, time.Millisecond)
+		assert.Equal(t, 2, r.Stats().Replicator.QueueDepth)
+
+		close(target.block)
+		require.Eventually(t, func() bool {
+			return len(target.written()) == 10
+		}, time.Second, time.Millisecond)
+
+		cancel()
+		require.NoError(t, <-done)
+	})
+
+	t.Run("pause stops reading until resumed", func(t *testing.T) {
+		source := newMemorySource()
+		target := &memoryTarget{}
+		r, err := New(WithSource(source), WithTarget(target))
+		require.NoError(t, err)
+
+		cancel, done := runReplicator(t, r)
+		defer cancel()
+
+		r.SendSignal(SignalPause)
+		require.Eventually(t, func() bool {
+			return r.State.Current() == StatePaused
+		}, time.Second, time.Millisecond)
+
+		// let an in-flight Next call return before producing events
+		time.Sleep(50 * time.Millisecond)
+		source.events <- testEvent(1)
+		time.Sleep(50 * time.Millisecond)
+		assert.Empty(t, target.written())
+
+		r.SendSignal(SignalResume)
+		require.Eventually(t, func() bool {
+			return len(target.written()) == 1
+		}, time.Second, time.Millisecond)
+
+		r.SendSignal(SignalStop)
+		require.NoError(t, <-done)
+		assert.Equal(t, StateStopped, r.State.Current())
+	})
+
+	t.Run("source error stops the replicator", func(t *testing.T) {
+		source := newMemorySource()
+		target := &memoryTarget{}
+		r, err := New(WithSource(source), WithTarget(target))
+		require.NoError(t, err)
+
+		cancel, done := runReplicator(t, r)
+		defer cancel()
+
+		source.setErr(fmt.Errorf("boom"))
+		assert.EqualError(t, <-done, "boom")
+		assert.Equal(t, StateError, r.State.Current())
+	})
+}
+
+func TestReplicatorAcknowledgements(t *testing.T) {
+	t.Run("checkpoints only after delivery", func(t *testing.T) {
+		source := newMemorySource()
+		target := &asyncTarget{}
+		checkpointer := &memoryCheckpointer{}
+		r, err := New(
+			WithSource(source),
+			WithTarget(target),
+			WithCheckpointer(checkpointer),
+			WithSourceOptions(SourceOptions{CheckpointBatchSize: 1}),
+		)
+		require.NoError(t, err)
+
+		cancel, done := runReplicator(t, r)
+		defer cancel()
+
+		for i := 0; i < 3; i++ {
+			source.events <- testEvent(i)
+		}
+		require.Eventually(t, func() bool {
+			return len(target.written()) == 3
+		}, time.Second, time.Millisecond)
+
+		time.Sleep(20 * time.Millisecond)
+		assert.Nil(t, checkpointer.last())
+		assert.Equal(t, 3, r.Stats().Replicator.InFlightEvents)
+
+		target.release(nil)
+		require.Eventually(t, func() bool {
+			c := checkpointer.last()
+			return c != nil && string(c.Position) == "2"
+		}, time.Second, time.Millisecond)
+
+		cancel()
+		require.NoError(t, <-done)
+	})
+
+	t.Run("failed delivery stops the replicator", func(t *testing.T) {
+		source := newMemorySource()
+		target := &asyncTarget{}
+		checkpointer := &memoryCheckpointer{}
+		r, err := New(
+			WithSource(source),
+			WithTarget(target),
+			WithCheckpointer(checkpointer),
+			WithSourceOptions(SourceOptions{CheckpointBatchSize: 1}),
+		)
+		require.NoError(t, err)
+
+		cancel, done := runReplicator(t, r)
+		defer cancel()
+
+		source.events <- testEvent(0)
+		require.Eventually(t, func() bool {
+			return len(target.written()) == 1
+		}, time.Second, time.Millisecond)
+
+		target.release(fmt.Errorf("broker unavailable"))
+		assert.EqualError(t, <-done, "deliver: broker unavailable")
+		assert.Nil(t, checkpointer.last())
+	})
+}
+
+func TestReplicatorCheckpointing(t *testing.T) {
+	t.Run("checkpoints every batch and on stop", func(t *testing.T) {
+		source := newMemorySource()
+		target := &memoryTarget{}
+		checkpointer := &memoryCheckpointer{}
+		r, err := New(
+			WithSource(source),
+			WithTarget(target),
+			WithCheckpointer(checkpointer),
+			WithSourceOptions(SourceOptions{CheckpointBatchSize: 3}),
+		)
+		require.NoError(t, err)
+
+		cancel, done := runReplicator(t, r)
+		defer cancel()
+
+		for i := 0; i < 3; i++ {
+			source.events <- testEvent(i)
+		}
+		require.Eventually(t, func() bool {
+			c := checkpointer.last()
+			return c != nil && string(c.Position) == "2"
+		}, time.Second, time.Millisecond)
+
+		source.events <- testEvent(3)
+		source.events <- testEvent(4)
+		require.Eventually(t, func() bool {
+			return len(target.written()) == 5
+		}, time.Second, time.Millisecond)
+		assert.Equal(t, 1, checkpointer.count())
+		assert.Equal(t, 2, r.Stats().Replicator.PendingCheckpointEvents)
+
+		cancel()
+		require.NoError(t, <-done)
+		assert.Equal(t, 2, checkpointer.count())
+		assert.Equal(t, "4", string(checkpointer.last().Position))
+	})
+
+	t.Run("checkpoints on interval", func(t *testing.T) {
+		source := newMemorySource()
+		target := &memoryTarget{}
+		checkpointer := &memoryCheckpointer{}
+		r, err := New(
+			WithSource(source),
+			WithTarget(target),
+			WithCheckpointer(checkpointer),
+			WithSourceOptions(SourceOptions{CheckpointInterval: 20 * time.Millisecond}),
+		)
+		require.NoError(t, err)
+
+		cancel, done := runReplicator(t, r)
+		defer cancel()
+
+		source.events <- testEvent(0)
+		source.events <- testEvent(1)
+		require.Eventually(t, func() bool {
+			c := checkpointer.last()
+			return c != nil && string(c.Position) == "1"
+		}, time.Second, time.Millisecond)
+
+		// nothing new was delivered, so the ticker does not save again
+		saved := checkpointer.count()
+		time.Sleep(60 * time.Millisecond)
+		assert.Equal(t, saved, checkpointer.count())
+
+		cancel()
+		require.NoError(t, <-done)
+	})
+
+	t.Run("disabled without triggers", func(t *testing.T) {
+		source := newMemorySource()
+		target := &memoryTarget{}
+		checkpointer := &memoryCheckpointer{}
+		r, err := New(
+			WithSource(source),
+			WithTarget(target),
+			WithCheckpointer(checkpointer),
+			WithSourceOptions(SourceOptions{}),
+		)
+		require.NoError(t, err)
+
+		cancel, done := runReplicator(t, r)
+		source.events <- testEvent(0)
+		require.Eventually(t, func() bool {
+			return len(target.written()) == 1
+		}, time.Second, time.Millisecond)
+
+		cancel()
+		require.NoError(t, <-done)
+		assert.Nil(t, checkpointer.last())
+	})
+}
+
+func TestReplicatorReconnect(t *testing.T) {
+	retry := RetryOptions{
+		MaxAttempts:    3,
+		InitialBackoff: 5 * time.Millisecond,
+		Multiplier:     2,
+	}
+
+	t.Run("resumes from the last checkpoint", func(t *testing.T) {
+		source := newMemorySource()
+		target := &memoryTarget{}
+		checkpointer := &memoryCheckpointer{}
+		r, err := New(
+			WithSource(source),
+			WithTarget(target),
+			WithCheckpointer(checkpointer),
+			WithSourceOptions(SourceOptions{CheckpointBatchSize: 1}),
+			WithRetryOptions(retry),
+		)
+		require.NoError(t, err)
+
+		cancel, done := runReplicator(t, r)
+		defer cancel()
+
+		source.events <- testEvent(0)
+		require.Eventually(t, func() bool {
+			return checkpointer.last() != nil
+		}, time.Second, time.Millisecond)
+
+		source.setErr(fmt.Errorf("connection reset"))
+		require.Eventually(t, func() bool {
+			return r.Stats().Replicator.ReconnectAttempts > 0
+		}, time.Second, time.Millisecond)
+		source.setErr(nil)
+
+		require.Eventually(t, func() bool {
+			return r.State.Current() == StateStreaming
+		}, time.Second, time.Millisecond)
+
+		source.events <- testEvent(1)
+		require.Eventually(t, func() bool {
+			return len(target.written()) == 2
+		}, time.Second, time.Millisecond)
+
+		source.mu.Lock()
+		assert.GreaterOrEqual(t, source.connects, 2)
+		assert.Equal(t, "0", string(source.checkpoints[len(source.checkpoints)-1].Position))
+		source.mu.Unlock()
+
+		cancel()
+		require.NoError(t, <-done)
+	})
+
+	t.Run("gives up once attempts are exhausted", func(t *testing.T) {
+		source := newMemorySource()
+		target := &memoryTarget{}
+		r, err := New(
+			WithSource(source),
+			WithTarget(target),
+			WithRetryOptions(retry),
+		)
+		require.NoError(t, err)
+
+		cancel, done := runReplicator(t, r)
+		defer cancel()
+
+		source.setErr(fmt.Errorf("connection reset"))
+		err = <-done
+		assert.ErrorContains(t, err, "giving up after 3 reconnect attempts: connection reset")
+		assert.Equal(t, StateError, r.State.Current())
+		assert.Equal(t, int64(3), r.Stats().Replicator.ReconnectAttempts)
+	})
+
+	t.Run("stops when another writer owns the checkpoint", func(t *testing.T) {
+		source := newMemorySource()
+		r, err := New(
+			WithSource(source),
+			WithTarget(&memoryTarget{}),
+			WithCheckpointer(conflictingCheckpointer{&memoryCheckpointer{}}),
+			WithSourceOptions(SourceOptions{CheckpointBatchSize: 1}),
+			WithRetryOptions(retry),
+		)
+		require.NoError(t, err)
+
+		cancel, done := runReplicator(t, r)
+		defer cancel()
+
+		source.events <- testEvent(0)
+		err = <-done
+		assert.ErrorIs(t, err, ErrCheckpointConflict)
+		assert.Equal(t, StateError, r.State.Current())
+		assert.Zero(t, r.Stats().Replicator.ReconnectAttempts)
+	})
+}
+
+// conflictingCheckpointer fails every save as if another replicator had
+// written the checkpoint.
+type conflictingCheckpointer struct{ *memoryCheckpointer }
+
+func (conflictingCheckpointer) Save(ctx context.Context, checkpoint *Checkpoint) error {
+	return ErrCheckpointConflict
+}
+
+func TestRetryOptionsBackoff(t *testing.T) {
+	o := RetryOptions{
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     time.Second,
+		Multiplier:     2,
+	}
+	assert.Equal(t, 100*time.Millisecond, o.backoff(1))
+	assert.Equal(t, 400*time.Millisecond, o.backoff(3))
+	assert.Equal(t, time.Second, o.backoff(10))
+
+	o.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := o.backoff(1)
+		assert.GreaterOrEqual(t, d, 50*time.Millisecond)
+		assert.LessOrEqual(t, d, 150*time.Millisecond)
+	}
+}
+
+func TestReplicatorDrain(t *testing.T) {
+	t.Run("delivers queued events before stopping", func(t *testing.T) {
+		source := newMemorySource()
+		target := &memoryTarget{block: make(chan struct{})}
+		checkpointer := &memoryCheckpointer{}
+		r, err := New(
+			WithSource(source),
+			WithTarget(target),
+			WithCheckpointer(checkpointer),
+			WithSourceOptions(SourceOptions{CheckpointBatchSize: 100}),
+		)
+		require.NoError(t, err)
+
+		cancel, done := runReplicator(t, r)
+		for i := 0; i < 5; i++ {
+			source.events <- testEvent(i)
+		}
+		require.Eventually(t, func() bool {
+			return len(source.events) == 0
+		}, time.Second, time.Millisecond)
+
+		cancel()
+		require.Eventually(t, func() bool {
+			return r.State.Current() == StateDraining
+		}, time.Second, time.Millisecond)
+
+		close(target.block)
+		require.NoError(t, <-done)
+
+		assert.Equal(t, StateStopped, r.State.Current())
+		assert.Len(t, target.written(), 5)
+		assert.Equal(t, "4", string(checkpointer.last().Position))
+		assert.True(t, target.closed)
+	})
+
+	t.Run("waits for acknowledgements", func(t *testing.T) {
+		source := newMemorySource()
+		target := &asyncTarget{}
+		checkpointer := &memoryCheckpointer{}
+		r, err := New(
+			WithSource(source),
+			WithTarget(target),
+			WithCheckpointer(checkpointer),
+			WithSourceOptions(SourceOptions{CheckpointBatchSize: 100}),
+		)
+		require.NoError(t, err)
+
+		cancel, done := runReplicator(t, r)
+		for i := 0; i < 3; i++ {
+			source.events <- testEvent(i)
+		}
+		require.Eventually(t, func() bool {
+			return len(target.written()) == 3
+		}, time.Second, time.Millisecond)
+
+		r.SendSignal(SignalStop)
+		require.Eventually(t, func() bool {
+			return r.State.Current() == StateDraining
+		}, time.Second, time.Millisecond)
+		assert.Nil(t, checkpointer.last())
+
+		target.release(nil)
+		require.NoError(t, <-done)
+		cancel()
+		assert.Equal(t, "2", string(checkpointer.last().Position))
+	})
+
+	t.Run("gives up after the drain timeout", func(t *testing.T) {
+		source := newMemorySource()
+		target := &asyncTarget{}
+		checkpointer := &memoryCheckpointer{}
+		r, err := New(
+			WithSource(source),
+			WithTarget(target),
+			WithCheckpointer(checkpointer),
+			WithSourceOptions(SourceOptions{CheckpointBatchSize: 100}),
+			WithPipelineOptions(PipelineOptions{QueueSize: 10, DrainTimeout: 20 * time.Millisecond}),
+		)
+		require.NoError(t, err)
+
+		cancel, done := runReplicator(t, r)
+		source.events <- testEvent(0)
+		require.Eventually(t, func() bool {
+			return len(target.written()) == 1
+		}, time.Second, time.Millisecond)
+
+		cancel()
+		require.NoError(t, <-done)
+		assert.Equal(t, StateStopped, r.State.Current())
+		assert.Nil(t, checkpointer.last())
+	})
+}
+
+func TestReplicatorTransforms(t *testing.T) {
+	source := newMemorySource()
+	target := &memoryTarget{}
+	checkpointer := &memoryCheckpointer{}
+	dropOdd := TransformFunc(func(e Event) (Event, error) {
+		if e.Payload.After["id"].(int)%2 == 1 {
+			return e, ErrDropEvent
+		}
+		return e, nil
+	})
+	r, err := New(
+		WithSource(source),
+		WithTarget(target),
+		WithCheckpointer(checkpointer),
+		WithSourceOptions(SourceOptions{CheckpointBatchSize: 100}),
+		WithTransforms(dropOdd, InsertFields(map[string]interface{}{"env": "test"})),
+	)
+	require.NoError(t, err)
+
+	cancel, done := runReplicator(t, r)
+	for i := 0; i < 4; i++ {
+		source.events <- testEvent(i)
+	}
+	require.Eventually(t, func() bool {
+		return r.Stats().Replicator.DroppedEvents == 2
+	}, time.Second, time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-done)
+
+	written := target.written()
+	require.Len(t, written, 2)
+	assert.Equal(t, "test", written[0].Payload.After["env"])
+	// the dropped event at the end of the stream is still checkpointed
+	assert.Equal(t, "3", string(checkpointer.last().Position))
+}
+
+// rejectingTarget fails writes of the event with the given id with an
+// EventError, the first failures times or forever when failures is negative.
+type rejectingTarget struct {
+	memoryTarget
+	id       int
+	failures int
+
+	attempts int
+}
+
+func (t *rejectingTarget) Write(ctx context.Context, event Event) error {
+	t.mu.Lock()
+	if event.Payload.After["id"] == t.id && (t.failures < 0 || t.attempts < t.failures) {
+		t.attempts++
+		t.mu.Unlock()
+		return &EventError{Err: fmt.Errorf("message too large")}
+	}
+	t.mu.Unlock()
+	return t.memoryTarget.Write(ctx, event)
+}
+
+func TestReplicatorErrorPolicies(t *testing.T) {
+	run := func(t *testing.T, target Target, opts ...ReplicatorOption) (*Replicator, *memorySource, *memoryCheckpointer) {
+		source := newMemorySource()
+		checkpointer := &memoryCheckpointer{}
+		r, err := New(append([]ReplicatorOption{
+			WithID("test"),
+			WithSource(source),
+			WithTarget(target),
+			WithCheckpointer(checkpointer),
+			WithSourceOptions(SourceOptions{CheckpointBatchSize: 100}),
+		}, opts...)...)
+		require.NoError(t, err)
+
+		cancel, done := runReplicator(t, r)
+		for i := 0; i < 3; i++ {
+			source.events <- testEvent(i)
+		}
+		t.Cleanup(func() {
+			cancel()
+			<-done
+		})
+		return r, source, checkpointer
+	}
+
+	t.Run("retries event errors", func(t *testing.T) {
+		target := &rejectingTarget{id: 1, failures: 2}
+		r, _, _ := run(t, target, WithErrorOptions(ErrorOptions{Policy: ErrorPolicyFail, MaxRetries: 2}))
+
+		require.Eventually(t, func() bool {
+			return len(target.written()) == 3
+		}, time.Second, time.Millisecond)
+		assert.Equal(t, int64(2), r.Stats().Target.RetryCount)
+	})
+
+	t.Run("skip", func(t *testing.T) {
+		target := &rejectingTarget{id: 1, failures: -1}
+		r, _, checkpointer := run(t, target, WithErrorOptions(ErrorOptions{Policy: ErrorPolicySkip, MaxRetries: 1}))
+
+		require.Eventually(t, func() bool {
+			return len(target.written()) == 2
+		}, time.Second, time.Millisecond)
+		r.SendSignal(SignalStop)
+		require.Eventually(t, func() bool {
+			return r.State.Current() == StateStopped
+		}, time.Second, time.Millisecond)
+
+		assert.Equal(t, int64(1), r.Stats().Target.SkippedEvents)
+		assert.Equal(t, "2", string(checkpointer.last().Position))
+	})
+
+	t.Run("dead letter queue", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "dlq.jsonl")
+		dlq := NewFileDeadLetterQueue(path, zap.NewNop())
+		target := &rejectingTarget{id: 1, failures: -1}
+		r, _, _ := run(t, target,
+			WithErrorOptions(ErrorOptions{Policy: ErrorPolicyDLQ}),
+			WithDeadLetterQueue(dlq),
+		)
+
+		require.Eventually(t, func() bool {
+			return r.Stats().Target.DeadLetterEvents == 1
+		}, time.Second, time.Millisecond)
+
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		var letter struct {
+			ReplicatorID string          `json:"replicator_id"`
+			Stage        string          `json:"stage"`
+			Error        string          `json:"error"`
+			Attempts     int             `json:"attempts"`
+			Position     string          `json:"position"`
+			Event        json.RawMessage `json:"event"`
+		}
+		require.NoError(t, json.Unmarshal(data, &letter))
+		assert.Equal(t, "test", letter.ReplicatorID)
+		assert.Equal(t, StageWrite, letter.Stage)
+		assert.Equal(t, "message too large", letter.Error)
+		assert.Equal(t, 1, letter.Attempts)
+		assert.Equal(t, "1", letter.Position)
+		assert.NotEmpty(t, letter.Event)
+	})
+
+	t.Run("dead letter of an event which cannot be encoded", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "dlq.jsonl")
+		source := newMemorySource()
+		target := &rejectingTarget{id: 1, failures: -1}
+		r, err := New(
+			WithID("test"),
+			WithSource(source),
+			WithTarget(target),
+			WithCheckpointer(&memoryCheckpointer{}),
+			WithErrorOptions(ErrorOptions{Policy: ErrorPolicyDLQ}),
+			WithDeadLetterQueue(NewFileDeadLetterQueue(path, zap.NewNop())),
+		)
+		require.NoError(t, err)
+		cancel, done := runReplicator(t, r)
+		defer func() {
+			cancel()
+			<-done
+		}()
+
+		for i := 0; i < 3; i++ {
+			event := testEvent(i)
+			event.Payload.After["score"] = math.NaN()
+			source.events <- event
+		}
+		require.Eventually(t, func() bool {
+			return len(target.written()) == 2
+		}, time.Second, time.Millisecond)
+		assert.Equal(t, int64(1), r.Stats().Target.DeadLetterEvents)
+
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		var letter struct {
+			Position           string `json:"position"`
+			Event              string `json:"event"`
+			EventEncodingError string `json:"event_encoding_error"`
+		}
+		require.NoError(t, json.Unmarshal(data, &letter))
+		assert.Equal(t, "1", letter.Position)
+		assert.Contains(t, letter.Event, "NaN")
+		assert.Contains(t, letter.EventEncodingError, "unsupported value")
+	})
+
+	t.Run("dlq policy requires a dead letter queue", func(t *testing.T) {
+		r, err := New(
+			WithSource(newMemorySource()),
+			WithTarget(&memoryTarget{}),
+			WithErrorOptions(ErrorOptions{Policy: ErrorPolicyDLQ}),
+		)
+		require.NoError(t, err)
+		assert.Error(t, r.Run(context.Background()))
+	})
+}
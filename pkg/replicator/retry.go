@@ -0,0 +1,191 @@
+package replicator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type RetryOptions struct {
+	// retry.max_attempts
+	// number of reconnect attempts after a source or target failure before
+	// the replicator gives up and moves to the error state. 0 disables
+	// reconnecting.
+	MaxAttempts int
+
+	// retry.initial_backoff
+	// delay before the first reconnect attempt
+	InitialBackoff time.Duration
+
+	// retry.max_backoff
+	// upper bound for the delay between attempts
+	MaxBackoff time.Duration
+
+	// retry.multiplier
+	// factor the delay grows by after every attempt
+	Multiplier float64
+
+	// retry.jitter
+	// fraction of the delay, between 0 and 1, that is randomized to avoid
+	// replicators reconnecting in lockstep
+	Jitter float64
+}
+
+// backoff returns the delay before the given reconnect attempt, starting at 1.
+func (o RetryOptions) backoff(attempt int) time.Duration {
+	if o.InitialBackoff <= 0 {
+		return 0
+	}
+
+	multiplier := o.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+
+	delay := float64(o.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if o.MaxBackoff > 0 && delay > float64(o.MaxBackoff) {
+		delay = float64(o.MaxBackoff)
+	}
+
+	if o.Jitter > 0 {
+		jitter := math.Min(o.Jitter, 1)
+		delay += delay * jitter * (2*rand.Float64() - 1)
+	}
+
+	return time.Duration(delay)
+}
+
+// pipelineError is a fatal error raised by the pipeline along with the side
+// of the pipeline that raised it.
+type pipelineError struct {
+	err    error
+	target bool
+}
+
+// reconnect recovers from a failure of pipeline p according to the
+// RetryOptions. The source is always reconnected from the last checkpoint,
+// since events that were queued or in flight have been discarded; the target
+// is only reconnected when it raised the error. Attempts accumulate across
+// pipelines that fail without delivering a single event, so a source that
+// connects but fails on every read still exhausts the policy.
+//
+// reconnect returns the new pipeline, or a nil pipeline when the replicator
+// has stopped or given up, in which case the returned error is the one Run
+// should return.
+func (r *Replicator) reconnect(ctx context.Context, p *pipeline, cause pipelineError) (*pipeline, error) {
+	r.mu.Lock()
+	r.stats.Replicator.LastError = cause.err.Error()
+	r.mu.Unlock()
+
+	// another process writes this replicator's checkpoints, reconnecting
+	// would only overwrite them
+	if r.RetryOptions.MaxAttempts <= 0 || errors.Is(cause.err, ErrCheckpointConflict) {
+		r.State.Transition(StateError)
+		return nil, cause.err
+	}
+
+	paused := r.State.Current() == StatePaused
+	if err := r.State.Transition(StateReconnecting); err != nil {
+		r.State.Transition(StateError)
+		return nil, cause.err
+	}
+
+	if p.tracker.deliveredCount() > 0 {
+		r.retries = 0
+	}
+
+	reconnectTarget := cause.target
+	lastErr := cause.err
+
+	for r.retries < r.RetryOptions.MaxAttempts {
+		r.retries++
+		attempt := r.retries
+		delay := r.RetryOptions.backoff(attempt)
+
+		r.logger.Warn("Reconnecting replicator",
+			zap.Int("attempt", attempt),
+			zap.Int("max_attempts", r.RetryOptions.MaxAttempts),
+			zap.Duration("backoff", delay),
+			zap.Bool("target", reconnectTarget),
+			zap.Error(lastErr))
+
+		r.mu.Lock()
+		r.stats.Replicator.ReconnectAttempts++
+		r.mu.Unlock()
+
+		timer := time.NewTimer(delay)
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				r.State.Transition(StateStopped)
+				return nil, nil
+
+			case signal := <-r.controlChan:
+				r.mu.Lock()
+				r.stats.Replicator.SignalsReceived++
+				r.mu.Unlock()
+
+				switch signal {
+				case SignalStop:
+					timer.Stop()
+					r.logger.Info("Stopping replicator while reconnecting")
+					r.State.Transition(StateStopped)
+					return nil, nil
+				case SignalPause:
+					paused = true
+				case SignalResume:
+					paused = false
+				default:
+					r.logger.Warn("Ignoring signal while reconnecting", zap.String("signal", string(signal)))
+				}
+
+			case <-timer.C:
+				break wait
+			}
+		}
+
+		if err := r.Source.Disconnect(ctx); err != nil {
+			r.logger.Warn("Error disconnecting source", zap.Error(err))
+		}
+
+		if reconnectTarget {
+			if err := r.Target.Disconnect(ctx); err != nil {
+				r.logger.Warn("Error disconnecting target", zap.Error(err))
+			}
+			if err := r.Target.Connect(ctx); err != nil {
+				lastErr = err
+				continue
+			}
+			reconnectTarget = false
+		}
+
+		if err := r.Source.Connect(ctx, r.lastCheckpoint); err != nil {
+			lastErr = err
+			continue
+		}
+
+		next := StateStreaming
+		if paused {
+			next = StatePaused
+			r.gate.close()
+		} else {
+			r.gate.open()
+		}
+		if err := r.State.Transition(next); err != nil {
+			return nil, err
+		}
+
+		r.logger.Info("Replicator reconnected", zap.Int("attempt", attempt))
+		return r.startPipeline(ctx), nil
+	}
+
+	r.State.Transition(StateError)
+	return nil, fmt.Errorf("giving up after %d reconnect attempts: %w", r.RetryOptions.MaxAttempts, lastErr)
+}
@@ -0,0 +1,420 @@
+package replicator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrReplicatorRunning is returned when the checkpoint of a replicator
+	// which is neither paused nor stopped is changed
+	ErrReplicatorRunning = errors.New("replicator must be paused or stopped to change its checkpoint")
+
+	// ErrInvalidPosition is returned when a position is not valid for the
+	// source of a replicator
+	ErrInvalidPosition = errors.New("invalid position")
+)
+
+// CheckpointInfo is a checkpoint as shown by the API, with its position as
+// text: a Postgres LSN or a base64 MongoDB resume token.
+type CheckpointInfo struct {
+	ReplicatorID string `json:"replicator_id"`
+	Position     string `json:"position"`
+
+	// RenderedPosition is the position as rendered by the source, such as
+	// the _data of a MongoDB resume token, when it differs from Position
+	RenderedPosition string `json:"rendered_position,omitempty"`
+
+	Timestamp time.Time `json:"timestamp"`
+	Events    int       `json:"events,omitempty"`
+	Epoch     uint64    `json:"epoch,omitempty"`
+
+	Source  *SourceFingerprint `json:"source,omitempty"`
+	Version int                `json:"version,omitempty"`
+}
+
+// NewCheckpointInfo converts a checkpoint for the API, rendering its
+// position with codec if it is not nil.
+func NewCheckpointInfo(checkpoint *Checkpoint, codec PositionCodec) CheckpointInfo {
+	info := CheckpointInfo{
+		ReplicatorID: checkpoint.ReplicatorID,
+		Position:     string(checkpoint.Position),
+		Timestamp:    checkpoint.Timestamp,
+		Events:       checkpoint.Events,
+		Epoch:        checkpoint.Epoch,
+		Source:       checkpoint.Source,
+		Version:      checkpoint.Version,
+	}
+	if rendered := renderPosition(codec, checkpoint.Position); rendered != info.Position {
+		info.RenderedPosition = rendered
+	}
+	return info
+}
+
+// NewCheckpointInfos converts checkpoints for the API.
+func NewCheckpointInfos(checkpoints []*Checkpoint, codec PositionCodec) []CheckpointInfo {
+	infos := make([]CheckpointInfo, 0, len(checkpoints))
+	for _, checkpoint := range checkpoints {
+		infos = append(infos, NewCheckpointInfo(checkpoint, codec))
+	}
+	return infos
+}
+
+// checkpointChange stores a new checkpoint and returns it, or returns nil
+// once the checkpoint is deleted.
+type checkpointChange func(ctx context.Context) (*Checkpoint, error)
+
+// checkpointRequest asks the control loop to change the checkpoint of a
+// running replicator.
+type checkpointRequest struct {
+	change checkpointChange
+	reply  chan error
+}
+
+// Checkpoint loads the saved checkpoint of the replicator, or nil if there
+// is none.
+func (r *Replicator) Checkpoint(ctx context.Context) (*Checkpoint, error) {
+	return r.Checkpointer.Load(ctx, r.ID)
+}
+
+// SetCheckpoint replaces the checkpoint of the replicator, which must be
+// paused or stopped. The position is parsed by the source, so it may be given
+// in its rendered form. A paused replicator reconnects its source from the
+// new position when it is resumed; events read before it was paused and not
+// yet acknowledged are discarded.
+func (r *Replicator) SetCheckpoint(ctx context.Context, text string) (*Checkpoint, error) {
+	if text == "" {
+		return nil, fmt.Errorf("%w: position is empty", ErrInvalidPosition)
+	}
+	position, err := r.positions().Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPosition, err)
+	}
+
+	checkpoint := &Checkpoint{
+		ReplicatorID: r.ID,
+		Position:     position.Bytes(),
+		Timestamp:    time.Now(),
+		Version:      CheckpointVersion,
+	}
+	err = r.changeCheckpoint(ctx, func(ctx context.Context) (*Checkpoint, error) {
+		// a paused replicator still holds its lease
+		epoch, err := r.leaseEpoch()
+		if err != nil {
+			return nil, err
+		}
+		checkpoint.Epoch = epoch
+
+		// a replicator which never ran has not fingerprinted its source
+		checkpoint.Source = r.fingerprint
+		if checkpoint.Source == nil {
+			if checkpoint.Source, err = r.sourceFingerprint(ctx); err != nil {
+				return nil, err
+			}
+		}
+		return checkpoint, r.Checkpointer.Save(ctx, checkpoint)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return checkpoint, nil
+}
+
+// DeleteCheckpoint deletes the checkpoint of the replicator, which must be
+// paused or stopped, so its source starts from its default position.
+func (r *Replicator) DeleteCheckpoint(ctx context.Context) error {
+	return r.changeCheckpoint(ctx, func(ctx context.Context) (*Checkpoint, error) {
+		return nil, r.Checkpointer.Delete(ctx, r.ID)
+	})
+}
+
+// CheckpointHistory returns the checkpoints of the replicator saved since the
+// given time, oldest first.
+func (r *Replicator) CheckpointHistory(ctx context.Context, since time.Time) ([]*Checkpoint, error) {
+	h, ok := r.Checkpointer.(CheckpointHistory)
+	if !ok {
+		return nil, ErrHistoryUnsupported
+	}
+	return h.History(ctx, r.ID, since)
+}
+
+// Rollback restores the latest checkpoint saved at or before to, such as the
+// checkpoint from before a bad deploy, so the replicator replays the events
+// since. Like SetCheckpoint, the replicator must be paused or stopped.
+func (r *Replicator) Rollback(ctx context.Context, to time.Time) (*Checkpoint, error) {
+	rb, ok := r.Checkpointer.(CheckpointRollbacker)
+	if !ok {
+		return nil, ErrHistoryUnsupported
+	}
+
+	var checkpoint *Checkpoint
+	err := r.changeCheckpoint(ctx, func(ctx context.Context) (*Checkpoint, error) {
+		epoch, err := r.leaseEpoch()
+		if err != nil {
+			return nil, err
+		}
+		checkpoint, err = rb.Rollback(ctx, r.ID, to, epoch)
+		return checkpoint, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return checkpoint, nil
+}
+
+// changeCheckpoint hands the change to the control loop while Run is active,
+// and stores it directly under the lease of the replicator otherwise.
+func (r *Replicator) changeCheckpoint(ctx context.Context, change checkpointChange) error {
+	r.mu.RLock()
+	running := r.running
+	r.mu.RUnlock()
+
+	if running != nil {
+		req := checkpointRequest{
+			change: change,
+			reply:  make(chan error, 1),
+		}
+		select {
+		case r.checkpointChan <- req:
+			select {
+			case err := <-req.reply:
+				return err
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		case <-running:
+			// Run returned, the replicator is stopped
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	switch r.State.Current() {
+	case StateCreated, StateStopped, StateError:
+		return r.storeLeased(ctx, change)
+	default:
+		return ErrReplicatorRunning
+	}
+}
+
+// storeLeased stores a change to the checkpoint of a replicator which is not
+// running. With a Leaser, the lease is held for the duration of the change,
+// so an instance running the replicator elsewhere cannot race it, and the
+// checkpoint is saved under its epoch.
+func (r *Replicator) storeLeased(ctx context.Context, change checkpointChange) error {
+	if r.Leaser == nil {
+		return r.storeCheckpoint(ctx, change)
+	}
+
+	lease, err := r.Leaser.Acquire(ctx, r.ID, r.LeaseOptions.Holder)
+	if errors.Is(err, ErrLeaseHeld) {
+		return fmt.Errorf("%w: %w", ErrReplicatorRunning, err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to acquire lease: %w", err)
+	}
+	r.mu.Lock()
+	r.lease = lease
+	r.mu.Unlock()
+	defer r.releaseLease(lease)
+
+	return r.storeCheckpoint(ctx, change)
+}
+
+// moveCheckpoint changes the checkpoint from the control loop. The pipeline
+// is stopped first so its final checkpoint does not overwrite the new one.
+func (r *Replicator) moveCheckpoint(ctx context.Context, p *pipeline, change checkpointChange) error {
+	if r.State.Current() != StatePaused {
+		return ErrReplicatorRunning
+	}
+
+	p.stop()
+	r.rewind = true
+	return r.storeCheckpoint(ctx, change)
+}
+
+func (r *Replicator) storeCheckpoint(ctx context.Context, change checkpointChange) error {
+	checkpoint, err := change(ctx)
+	if err != nil {
+		return err
+	}
+
+	r.lastCheckpoint = checkpoint
+	var rendered string
+	if checkpoint != nil {
+		rendered = r.renderPosition(checkpoint.Position)
+	}
+	r.mu.Lock()
+	r.stats.Replicator.CheckpointPosition = rendered
+	r.mu.Unlock()
+
+	if checkpoint == nil {
+		r.logger.Info("Checkpoint deleted", zap.String("replicator_id", r.ID))
+		return nil
+	}
+	r.logger.Info("Checkpoint moved",
+		zap.String("replicator_id", r.ID),
+		zap.String("position", rendered))
+	return nil
+}
+
+func (s *Server) getCheckpoint(w http.ResponseWriter, r *http.Request) {
+	rep, ok := s.replicator(w, r)
+	if !ok {
+		return
+	}
+
+	checkpoint, err := rep.Checkpoint(r.Context())
+	if err != nil {
+		s.writeCheckpointError(w, rep.ID, err)
+		return
+	}
+	if checkpoint == nil {
+		http.Error(w, "checkpoint not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(NewCheckpointInfo(checkpoint, rep.positions()))
+}
+
+// setCheckpoint moves the checkpoint of a paused or stopped replicator to
+// the position in the request body.
+func (s *Server) setCheckpoint(w http.ResponseWriter, r *http.Request) {
+	rep, ok := s.replicator(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Position string `json:"position"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	checkpoint, err := rep.SetCheckpoint(r.Context(), body.Position)
+	if err != nil {
+		s.writeCheckpointError(w, rep.ID, err)
+		return
+	}
+
+	s.logger.Info("checkpoint moved",
+		zap.String("replicator_id", rep.ID),
+		zap.String("position", rep.renderPosition(checkpoint.Position)))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(NewCheckpointInfo(checkpoint, rep.positions()))
+}
+
+func (s *Server) deleteCheckpoint(w http.ResponseWriter, r *http.Request) {
+	rep, ok := s.replicator(w, r)
+	if !ok {
+		return
+	}
+
+	if err := rep.DeleteCheckpoint(r.Context()); err != nil {
+		s.writeCheckpointError(w, rep.ID, err)
+		return
+	}
+
+	s.logger.Info("checkpoint deleted", zap.String("replicator_id", rep.ID))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getCheckpointHistory lists the checkpoints saved since the RFC 3339 time
+// in the since query parameter, or all of them.
+func (s *Server) getCheckpointHistory(w http.ResponseWriter, r *http.Request) {
+	rep, ok := s.replicator(w, r)
+	if !ok {
+		return
+	}
+
+	var since time.Time
+	if v := r.URL.Query().Get("since"); v != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, "invalid since parameter: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	checkpoints, err := rep.CheckpointHistory(r.Context(), since)
+	if err != nil {
+		s.writeCheckpointError(w, rep.ID, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(NewCheckpointInfos(checkpoints, rep.positions()))
+}
+
+// rollbackCheckpoint restores the checkpoint of a paused or stopped
+// replicator saved at or before the timestamp in the request body.
+func (s *Server) rollbackCheckpoint(w http.ResponseWriter, r *http.Request) {
+	rep, ok := s.replicator(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Timestamp time.Time `json:"timestamp"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body.Timestamp.IsZero() {
+		http.Error(w, "timestamp is required", http.StatusBadRequest)
+		return
+	}
+
+	checkpoint, err := rep.Rollback(r.Context(), body.Timestamp)
+	if err != nil {
+		s.writeCheckpointError(w, rep.ID, err)
+		return
+	}
+
+	s.logger.Info("checkpoint rolled back",
+		zap.String("replicator_id", rep.ID),
+		zap.Time("to", body.Timestamp),
+		zap.String("position", rep.renderPosition(checkpoint.Position)))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(NewCheckpointInfo(checkpoint, rep.positions()))
+}
+
+// replicator returns the replicator named in the request path, writing a 404
+// if it is not registered.
+func (s *Server) replicator(w http.ResponseWriter, r *http.Request) (*Replicator, bool) {
+	rep, _ := s.lookup(chi.URLParam(r, "id"))
+	if rep == nil {
+		http.Error(w, "replicator not found", http.StatusNotFound)
+		return nil, false
+	}
+	return rep, true
+}
+
+func (s *Server) writeCheckpointError(w http.ResponseWriter, id string, err error) {
+	switch {
+	case errors.Is(err, ErrInvalidPosition):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrReplicatorRunning):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrNoCheckpoint):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrHistoryUnsupported):
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+	default:
+		s.logger.Error("checkpoint request failed", zap.String("replicator_id", id), zap.Error(err))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
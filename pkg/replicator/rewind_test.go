@@ -0,0 +1,281 @@
+package replicator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestReplicatorSetCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	source := newMemorySource()
+	target := &memoryTarget{}
+	checkpointer := &memoryCheckpointer{}
+	r, err := New(
+		WithSource(source),
+		WithTarget(target),
+		WithCheckpointer(checkpointer),
+		WithSourceOptions(SourceOptions{CheckpointBatchSize: 1}),
+	)
+	require.NoError(t, err)
+
+	cancel, done := runReplicator(t, r)
+	defer cancel()
+
+	source.events <- testEvent(0)
+	require.Eventually(t, func() bool {
+		return checkpointer.count() == 1
+	}, time.Second, time.Millisecond)
+
+	_, err = r.SetCheckpoint(ctx, "42")
+	assert.ErrorIs(t, err, ErrReplicatorRunning)
+	_, err = r.SetCheckpoint(ctx, "")
+	assert.ErrorIs(t, err, ErrInvalidPosition)
+
+	r.SendSignal(SignalPause)
+	require.Eventually(t, func() bool {
+		return r.State.Current() == StatePaused
+	}, time.Second, time.Millisecond)
+
+	checkpoint, err := r.SetCheckpoint(ctx, "42")
+	require.NoError(t, err)
+	assert.Equal(t, "42", string(checkpoint.Position))
+	assert.Equal(t, checkpoint, checkpointer.last())
+
+	// resuming reconnects the source from the new checkpoint
+	r.SendSignal(SignalResume)
+	require.Eventually(t, func() bool {
+		return r.State.Current() == StateStreaming
+	}, time.Second, time.Millisecond)
+
+	source.mu.Lock()
+	require.Len(t, source.checkpoints, 2)
+	assert.Equal(t, "42", string(source.checkpoints[1].Position))
+	source.mu.Unlock()
+
+	source.events <- testEvent(43)
+	require.Eventually(t, func() bool {
+		return len(target.written()) == 2
+	}, time.Second, time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-done)
+
+	// a stopped replicator changes its checkpoint directly
+	require.NoError(t, r.DeleteCheckpoint(ctx))
+	assert.Nil(t, checkpointer.last())
+}
+
+func TestServerCheckpoint(t *testing.T) {
+	checkpointer := &memoryCheckpointer{}
+	r, err := New(
+		WithID("orders"),
+		WithSource(newMemorySource()),
+		WithTarget(&memoryTarget{}),
+		WithCheckpointer(checkpointer),
+	)
+	require.NoError(t, err)
+
+	s := NewServer(zap.NewNop())
+	s.RegisterReplicator(r)
+	srv := httptest.NewServer(s.Routes())
+	defer srv.Close()
+
+	do := func(method, path, body string) *http.Response {
+		req, err := http.NewRequest(method, srv.URL+"/api/v1/replicators"+path, bytes.NewBufferString(body))
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	resp := do(http.MethodGet, "/orders/checkpoint", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp = do(http.MethodGet, "/missing/checkpoint", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp = do(http.MethodPut, "/orders/checkpoint", `{"position": "0/16B3748"}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = do(http.MethodGet, "/orders/checkpoint", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var info CheckpointInfo
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&info))
+	assert.Equal(t, "orders", info.ReplicatorID)
+	assert.Equal(t, "0/16B3748", info.Position)
+
+	resp = do(http.MethodPut, "/orders/checkpoint", `{"position": ""}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	cancel, done := runReplicator(t, r)
+	resp = do(http.MethodPut, "/orders/checkpoint", `{"position": "0/16B3750"}`)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	resp = do(http.MethodDelete, "/orders/checkpoint", "")
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	cancel()
+	require.NoError(t, <-done)
+
+	resp = do(http.MethodDelete, "/orders/checkpoint", "")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Nil(t, checkpointer.last())
+}
+
+// historyCheckpointer keeps every saved checkpoint as its history.
+type historyCheckpointer struct{ *memoryCheckpointer }
+
+func (c historyCheckpointer) History(ctx context.Context, replicatorID string, since time.Time) ([]*Checkpoint, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var history []*Checkpoint
+	for _, checkpoint := range c.saved {
+		if !checkpoint.Timestamp.Before(since) {
+			history = append(history, checkpoint)
+		}
+	}
+	return history, nil
+}
+
+func (c historyCheckpointer) Rollback(ctx context.Context, replicatorID string, to time.Time, epoch uint64) (*Checkpoint, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := len(c.saved) - 1; i >= 0; i-- {
+		if checkpoint := c.saved[i]; !checkpoint.Timestamp.After(to) {
+			restored := *checkpoint
+			restored.Epoch = RestoredEpoch(c.saved[len(c.saved)-1], epoch)
+			c.saved = append(c.saved, &restored)
+			return &restored, nil
+		}
+	}
+	return nil, ErrNoCheckpoint
+}
+
+func TestReplicatorRollback(t *testing.T) {
+	ctx := context.Background()
+	source := newMemorySource()
+	checkpointer := historyCheckpointer{&memoryCheckpointer{}}
+	r, err := New(
+		WithSource(source),
+		WithTarget(&memoryTarget{}),
+		WithCheckpointer(checkpointer),
+		WithSourceOptions(SourceOptions{CheckpointBatchSize: 1}),
+	)
+	require.NoError(t, err)
+
+	cancel, done := runReplicator(t, r)
+	defer cancel()
+
+	source.events <- testEvent(0)
+	require.Eventually(t, func() bool {
+		return checkpointer.count() == 1
+	}, time.Second, time.Millisecond)
+	before := time.Now()
+	source.events <- testEvent(1)
+	require.Eventually(t, func() bool {
+		return checkpointer.count() == 2
+	}, time.Second, time.Millisecond)
+
+	history, err := r.CheckpointHistory(ctx, time.Time{})
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, 1, history[1].Events)
+
+	_, err = r.Rollback(ctx, before)
+	assert.ErrorIs(t, err, ErrReplicatorRunning)
+
+	r.SendSignal(SignalPause)
+	require.Eventually(t, func() bool {
+		return r.State.Current() == StatePaused
+	}, time.Second, time.Millisecond)
+
+	checkpoint, err := r.Rollback(ctx, before)
+	require.NoError(t, err)
+	assert.Equal(t, "0", string(checkpoint.Position))
+
+	// resuming replays the events since the restored checkpoint
+	r.SendSignal(SignalResume)
+	require.Eventually(t, func() bool {
+		return r.State.Current() == StateStreaming
+	}, time.Second, time.Millisecond)
+
+	source.mu.Lock()
+	require.Len(t, source.checkpoints, 2)
+	assert.Equal(t, "0", string(source.checkpoints[1].Position))
+	source.mu.Unlock()
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+func TestServerCheckpointRollback(t *testing.T) {
+	checkpointer := historyCheckpointer{&memoryCheckpointer{}}
+	start := time.Date(2025, 11, 19, 8, 0, 0, 0, time.UTC)
+	for i, position := range []string{"0/1", "0/2"} {
+		checkpointer.Save(context.Background(), &Checkpoint{
+			ReplicatorID: "orders",
+			Position:     []byte(position),
+			Timestamp:    start.Add(time.Duration(i) * time.Hour),
+		})
+	}
+	r, err := New(
+		WithID("orders"),
+		WithSource(newMemorySource()),
+		WithTarget(&memoryTarget{}),
+		WithCheckpointer(checkpointer),
+	)
+	require.NoError(t, err)
+	plain, err := New(
+		WithID("invoices"),
+		WithSource(newMemorySource()),
+		WithTarget(&memoryTarget{}),
+		WithCheckpointer(&memoryCheckpointer{}),
+	)
+	require.NoError(t, err)
+
+	s := NewServer(zap.NewNop())
+	s.RegisterReplicator(r)
+	s.RegisterReplicator(plain)
+	srv := httptest.NewServer(s.Routes())
+	defer srv.Close()
+
+	do := func(method, path, body string) *http.Response {
+		req, err := http.NewRequest(method, srv.URL+"/api/v1/replicators"+path, bytes.NewBufferString(body))
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	resp := do(http.MethodGet, "/orders/checkpoint/history?since=2025-11-19T08:30:00Z", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var infos []CheckpointInfo
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&infos))
+	require.Len(t, infos, 1)
+	assert.Equal(t, "0/2", infos[0].Position)
+
+	resp = do(http.MethodGet, "/orders/checkpoint/history?since=yesterday", "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = do(http.MethodGet, "/invoices/checkpoint/history", "")
+	assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+
+	resp = do(http.MethodPost, "/orders/checkpoint/rollback", `{"timestamp": "2025-11-19T08:30:00Z"}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var info CheckpointInfo
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&info))
+	assert.Equal(t, "0/1", info.Position)
+	assert.Equal(t, "0/1", string(checkpointer.last().Position))
+
+	resp = do(http.MethodPost, "/orders/checkpoint/rollback", `{"timestamp": "2025-11-19T07:00:00Z"}`)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp = do(http.MethodPost, "/orders/checkpoint/rollback", `{}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
@@ -0,0 +1,295 @@
+package replicator
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// Pattern matches a name, such as a table, with a glob (see path.Match), or
+// with a regular expression when prefixed with "re:". The zero Pattern
+// matches every name.
+type Pattern struct {
+	expr string
+	re   *regexp.Regexp
+}
+
+func ParsePattern(expr string) (Pattern, error) {
+	if re, ok := strings.CutPrefix(expr, "re:"); ok {
+		compiled, err := regexp.Compile(re)
+		if err != nil {
+			return Pattern{}, err
+		}
+		return Pattern{expr: expr, re: compiled}, nil
+	}
+
+	if _, err := path.Match(expr, ""); err != nil {
+		return Pattern{}, fmt.Errorf("invalid glob %q: %w", expr, err)
+	}
+	return Pattern{expr: expr}, nil
+}
+
+func (p Pattern) Match(name string) bool {
+	switch {
+	case p.re != nil:
+		return p.re.MatchString(name)
+	case p.expr == "":
+		return true
+	default:
+		ok, _ := path.Match(p.expr, name)
+		return ok
+	}
+}
+
+func (p Pattern) String() string {
+	return p.expr
+}
+
+// Route sends the events matching all of its patterns and operations to the
+// named targets.
+type Route struct {
+	// Name identifies the route in stats
+	Name string
+
+	Db     Pattern
+	Schema Pattern
+	Table  Pattern
+
+	// Ops matches every operation when empty
+	Ops []Operation
+
+	Targets []string
+}
+
+func (r Route) Match(event Event) bool {
+	source := event.Payload.Source
+	if !r.Db.Match(source.Db) || !r.Schema.Match(source.Schema) || !r.Table.Match(source.Table) {
+		return false
+	}
+	if len(r.Ops) == 0 {
+		return true
+	}
+	for _, op := range r.Ops {
+		if op == event.Payload.Op {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseRoute builds a route from a "match->targets" spec, as used by the
+// --route flag. match is a comma separated list of db, schema, table and op
+// conditions; targets is a comma separated list of target names:
+//
+//	schema=public,table=orders->kafka
+//	schema=audit,table=*->archive
+//	table=re:^(users|accounts)$,op=c|u->kafka,archive
+//	table=re:^orders_[0-9]{4,6}$->kafka
+//	->kafka
+//
+// A comma only ends a condition when the next one follows it, so regular
+// expressions may contain commas.
+func ParseRoute(spec string) (Route, error) {
+	i := strings.LastIndex(spec, "->")
+	if i < 0 {
+		return Route{}, fmt.Errorf("route %q: expected match->targets", spec)
+	}
+
+	route := Route{Name: spec}
+
+	targets, err := parseList(spec[i+2:])
+	if err != nil {
+		return Route{}, fmt.Errorf("route %q: expected a comma separated list of targets", spec)
+	}
+	route.Targets = targets
+
+	if strings.TrimSpace(spec[:i]) == "" {
+		return route, nil
+	}
+
+	conditions, err := parseConditions(spec[:i])
+	if err != nil {
+		return Route{}, fmt.Errorf("route %q: %w", spec, err)
+	}
+	for k, v := range conditions {
+		var err error
+		switch k {
+		case "db":
+			route.Db, err = ParsePattern(v)
+		case "schema":
+			route.Schema, err = ParsePattern(v)
+		case "table":
+			route.Table, err = ParsePattern(v)
+		case "op":
+			for _, op := range strings.Split(v, "|") {
+				switch Operation(op) {
+				case OpCreate, OpUpdate, OpDelete, OpRead:
+					route.Ops = append(route.Ops, Operation(op))
+				default:
+					err = fmt.Errorf("unknown operation: %q", op)
+				}
+			}
+		default:
+			err = fmt.Errorf("unknown condition: %q", k)
+		}
+		if err != nil {
+			return Route{}, fmt.Errorf("route %q: %w", spec, err)
+		}
+	}
+
+	return route, nil
+}
+
+// conditionStart matches the key of a condition at the start of the text.
+var conditionStart = regexp.MustCompile(`^\s*\w+=`)
+
+// parseConditions parses the comma separated key=value conditions of a
+// route. Commas not followed by a key are part of the value.
+func parseConditions(match string) (map[string]string, error) {
+	var items []string
+	start := 0
+	for i := 0; i < len(match); i++ {
+		if match[i] == ',' && conditionStart.MatchString(match[i+1:]) {
+			items = append(items, match[start:i])
+			start = i + 1
+		}
+	}
+	items = append(items, match[start:])
+
+	conditions := make(map[string]string, len(items))
+	for _, item := range items {
+		k, v, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("expected key=value, got %q", item)
+		}
+		conditions[k] = v
+	}
+	return conditions, nil
+}
+
+// Router is a Target that writes each event to the targets of the first
+// route it matches, or to the default targets when it matches none. Events
+// without a target are not written but are still checkpointed. Delivery and
+// checkpointing work as for a FanOut: an event is acknowledged once every
+// required target it was routed to has acknowledged it.
+type Router struct {
+	*FanOut
+
+	routes   []route
+	fallback []bool
+
+	mu       sync.Mutex
+	matched  []int64
+	defaults int64
+	unrouted int64
+}
+
+// route is a Route with its targets resolved to FanOut indexes.
+type route struct {
+	Route
+	targets []bool
+}
+
+func NewRouter(targets []FanOutTarget, routes []Route, defaultTargets []string, logger *zap.Logger) (*Router, error) {
+	fanOut, err := NewFanOut(targets, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	index := make(map[string]int, len(fanOut.targets))
+	for i, t := range fanOut.targets {
+		index[t.Name] = i
+	}
+	resolve := func(names []string) ([]bool, error) {
+		selected := make([]bool, len(fanOut.targets))
+		for _, name := range names {
+			i, ok := index[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown target: %q", name)
+			}
+			selected[i] = true
+		}
+		return selected, nil
+	}
+
+	r := &Router{
+		FanOut:  fanOut,
+		matched: make([]int64, len(routes)),
+	}
+	for i, rt := range routes {
+		if rt.Name == "" {
+			rt.Name = fmt.Sprintf("route-%d", i)
+		}
+		selected, err := resolve(rt.Targets)
+		if err != nil {
+			return nil, fmt.Errorf("route %q: %w", rt.Name, err)
+		}
+		r.routes = append(r.routes, route{Route: rt, targets: selected})
+	}
+	if r.fallback, err = resolve(defaultTargets); err != nil {
+		return nil, fmt.Errorf("default route: %w", err)
+	}
+
+	fanOut.route = r.match
+	return r, nil
+}
+
+// match selects the targets of the first route matching the event.
+func (r *Router) match(event Event) []bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, rt := range r.routes {
+		if rt.Match(event) {
+			r.matched[i]++
+			return rt.targets
+		}
+	}
+
+	for _, selected := range r.fallback {
+		if selected {
+			r.defaults++
+			return r.fallback
+		}
+	}
+	r.unrouted++
+	return r.fallback
+}
+
+func (r *Router) Type() string {
+	return "router"
+}
+
+// RouteStats counts the events matched by a route.
+type RouteStats struct {
+	Name    string   `json:"name"`
+	Targets []string `json:"targets"`
+	Events  int64    `json:"events"`
+}
+
+// Stats adds the route counters to the FanOut stats, under
+// TargetSpecific["routes"], ["default_route_events"] and
+// ["unrouted_events"].
+func (r *Router) Stats() TargetStats {
+	stats := r.FanOut.Stats()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	routes := make([]RouteStats, 0, len(r.routes))
+	for i, rt := range r.routes {
+		routes = append(routes, RouteStats{
+			Name:    rt.Name,
+			Targets: rt.Targets,
+			Events:  r.matched[i],
+		})
+	}
+	stats.TargetSpecific["routes"] = routes
+	stats.TargetSpecific["default_route_events"] = r.defaults
+	stats.TargetSpecific["unrouted_events"] = r.unrouted
+	return stats
+}
@@ -0,0 +1,162 @@
+package replicator
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestPattern(t *testing.T) {
+	testCases := []struct {
+		name    string
+		pattern string
+		input   string
+		match   bool
+	}{
+		{name: "empty", pattern: "", input: "orders", match: true},
+		{name: "exact", pattern: "orders", input: "orders", match: true},
+		{name: "exact mismatch", pattern: "orders", input: "orders_2024", match: false},
+		{name: "glob", pattern: "orders_*", input: "orders_2024", match: true},
+		{name: "regex", pattern: "re:^(users|accounts)$", input: "accounts", match: true},
+		{name: "regex mismatch", pattern: "re:^(users|accounts)$", input: "users_archive", match: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := ParsePattern(tc.pattern)
+			require.NoError(t, err)
+			assert.Equal(t, tc.match, p.Match(tc.input))
+		})
+	}
+
+	_, err := ParsePattern("re:(")
+	assert.Error(t, err)
+	_, err = ParsePattern("[")
+	assert.Error(t, err)
+}
+
+func TestParseRoute(t *testing.T) {
+	route, err := ParseRoute("schema=public,table=orders_*,op=c|u->kafka,archive")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"kafka", "archive"}, route.Targets)
+	assert.Equal(t, []Operation{OpCreate, OpUpdate}, route.Ops)
+
+	event := testEvent(1)
+	event.Payload.Source.Table = "orders_2024"
+	assert.True(t, route.Match(event))
+
+	event.Payload.Op = OpDelete
+	assert.False(t, route.Match(event))
+
+	// commas inside a regular expression do not end the condition
+	route, err = ParseRoute("table=re:^orders_[0-9]{4,6}$,op=c->kafka")
+	require.NoError(t, err)
+	assert.Equal(t, "re:^orders_[0-9]{4,6}$", route.Table.String())
+	assert.Equal(t, []Operation{OpCreate}, route.Ops)
+	event.Payload.Op = OpCreate
+	assert.True(t, route.Match(event))
+	event.Payload.Source.Table = "orders_24"
+	assert.False(t, route.Match(event))
+
+	route, err = ParseRoute("->kafka")
+	require.NoError(t, err)
+	assert.True(t, route.Match(event))
+
+	for _, spec := range []string{"table=orders", "table=orders->", "column=id->kafka", "op=x->kafka"} {
+		_, err := ParseRoute(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestRouter(t *testing.T) {
+	orders, _ := ParseRoute("schema=public,table=orders->kafka")
+	audit, _ := ParseRoute("schema=audit->archive")
+
+	t.Run("unknown target", func(t *testing.T) {
+		_, err := NewRouter([]FanOutTarget{
+			{Name: "kafka", Target: &memoryTarget{}},
+		}, []Route{audit}, nil, zap.NewNop())
+		assert.EqualError(t, err, `route "schema=audit->archive": unknown target: "archive"`)
+	})
+
+	t.Run("routes events", func(t *testing.T) {
+		kafka := &memoryTarget{}
+		archive := &memoryTarget{}
+		fallback := &memoryTarget{}
+		router, err := NewRouter([]FanOutTarget{
+			{Name: "kafka", Target: kafka},
+			{Name: "archive", Target: archive},
+			{Name: "fallback", Target: fallback},
+		}, []Route{orders, audit}, []string{"fallback"}, zap.NewNop())
+		require.NoError(t, err)
+
+		source := newMemorySource()
+		checkpointer := &memoryCheckpointer{}
+		r, err := New(
+			WithSource(source),
+			WithTarget(router),
+			WithCheckpointer(checkpointer),
+			WithSourceOptions(SourceOptions{CheckpointBatchSize: 1}),
+		)
+		require.NoError(t, err)
+		cancel, done := runReplicator(t, r)
+		defer cancel()
+
+		for i, table := range []string{"public.orders", "audit.log", "audit.logins", "public.users"} {
+			event := testEvent(i)
+			event.Payload.Source.Schema, event.Payload.Source.Table, _ = strings.Cut(table, ".")
+			source.events <- event
+		}
+		require.Eventually(t, func() bool {
+			c := checkpointer.last()
+			return c != nil && string(c.Position) == "3"
+		}, time.Second, time.Millisecond)
+
+		assert.Len(t, kafka.written(), 1)
+		assert.Len(t, archive.written(), 2)
+		assert.Len(t, fallback.written(), 1)
+
+		stats := r.Stats().Target
+		routes := stats.TargetSpecific["routes"].([]RouteStats)
+		assert.Equal(t, int64(2), routes[1].Events)
+		assert.Equal(t, int64(1), stats.TargetSpecific["default_route_events"])
+
+		cancel()
+		require.NoError(t, <-done)
+	})
+
+	t.Run("checkpoints unrouted events", func(t *testing.T) {
+		kafka := &memoryTarget{}
+		router, err := NewRouter([]FanOutTarget{
+			{Name: "kafka", Target: kafka},
+		}, []Route{orders}, nil, zap.NewNop())
+		require.NoError(t, err)
+
+		source := newMemorySource()
+		checkpointer := &memoryCheckpointer{}
+		r, err := New(
+			WithSource(source),
+			WithTarget(router),
+			WithCheckpointer(checkpointer),
+			WithSourceOptions(SourceOptions{CheckpointBatchSize: 1}),
+		)
+		require.NoError(t, err)
+		cancel, done := runReplicator(t, r)
+		defer cancel()
+
+		source.events <- testEvent(0)
+		require.Eventually(t, func() bool {
+			return checkpointer.last() != nil
+		}, time.Second, time.Millisecond)
+
+		assert.Empty(t, kafka.written())
+		assert.Equal(t, int64(1), r.Stats().Target.TargetSpecific["unrouted_events"])
+
+		cancel()
+		require.NoError(t, <-done)
+	})
+}
@@ -5,31 +5,79 @@ import (
 	"encoding/json"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 )
 
 type Server struct {
 	logger      *zap.Logger
+	registry    *prometheus.Registry
 	replicators map[string]*Replicator
 	mu          sync.RWMutex
+
+	// authentication and transport security, see auth.go
+	audit       *zap.Logger
+	credentials []credential
+	tls         *TLSOptions
+
+	health HealthOptions
+
+	// replicators managed through the API, see lifecycle.go. managed is
+	// guarded by mu; lifecycle serializes creating, updating and deleting
+	// them.
+	ctx       context.Context
+	factory   Factory
+	store     SpecStore
+	managed   map[string]*managedReplicator
+	lifecycle sync.Mutex
+	wg        sync.WaitGroup
 }
 
+type ServerOption func(*Server)
+
 type ReplicatorInfo struct {
 	ID     string `json:"id"`
 	State  State  `json:"state"`
 	Source string `json:"source,omitempty"`
 
+	// Spec is set for replicators managed through the API, with the
+	// passwords of its URLs masked
+	Spec *Spec `json:"spec,omitempty"`
+
 	Stats Stats `json:"stats,omitempty"`
 }
 
-func NewServer(logger *zap.Logger) *Server {
-	return &Server{
+func NewServer(logger *zap.Logger, opts ...ServerOption) *Server {
+	s := &Server{
 		logger:      logger,
+		registry:    prometheus.NewRegistry(),
 		replicators: make(map[string]*Replicator),
+		ctx:         context.Background(),
+		managed:     make(map[string]*managedReplicator),
+		health: HealthOptions{
+			StaleAfter: 5 * time.Minute,
+		},
 	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.audit == nil {
+		s.audit = logger.Named("audit")
+	}
+
+	s.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		&collector{s: s},
+	)
+	return s
 }
 
 func (s *Server) RegisterReplicator(r *Replicator) {
@@ -60,17 +108,39 @@ func (s *Server) Routes() chi.Router {
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.RequestID)
+	r.Use(s.authenticate)
+
+	// probes do not authenticate
+	r.Get("/healthz", s.healthz)
+	r.Get("/readyz", s.readyz)
+
+	r.With(s.require(RoleReader)).Handle("/metrics", promhttp.HandlerFor(s.registry, promhttp.HandlerOpts{}))
 
 	r.Route("/api/v1/replicators", func(r chi.Router) {
-		r.Get("/", s.listReplicators)
-		r.Get("/{id}", s.getReplicator)
-		// r.Delete("/{id}", s.deleteReplicator)
-
-		// Control endpoints
-		r.Post("/{id}/pause", s.signalHandler(SignalPause))
-		r.Post("/{id}/resume", s.signalHandler(SignalResume))
-		r.Post("/{id}/restart", s.signalHandler(SignalRestart))
-		r.Post("/{id}/stop", s.signalHandler(SignalStop))
+		r.Group(func(r chi.Router) {
+			r.Use(s.require(RoleReader))
+			r.Get("/", s.listReplicators)
+			r.Get("/{id}", s.getReplicator)
+			r.Get("/{id}/events/tail", s.tailEvents)
+			r.Get("/{id}/checkpoint", s.getCheckpoint)
+			r.Get("/{id}/checkpoint/history", s.getCheckpointHistory)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(s.auditRequests, s.require(RoleOperator))
+			r.Post("/", s.createReplicator)
+			r.Put("/{id}", s.updateReplicator)
+			r.Delete("/{id}", s.deleteReplicator)
+			r.Put("/{id}/checkpoint", s.setCheckpoint)
+			r.Delete("/{id}/checkpoint", s.deleteCheckpoint)
+			r.Post("/{id}/checkpoint/rollback", s.rollbackCheckpoint)
+
+			// Control endpoints
+			r.Post("/{id}/pause", s.signalHandler(SignalPause))
+			r.Post("/{id}/resume", s.signalHandler(SignalResume))
+			r.Post("/{id}/restart", s.signalHandler(SignalRestart))
+			r.Post("/{id}/stop", s.signalHandler(SignalStop))
+		})
 	})
 
 	return r
@@ -111,11 +181,7 @@ func (s *Server) listReplicators(w http.ResponseWriter, r *http.Request) {
 
 	replicators := make([]ReplicatorInfo, 0, len(s.replicators))
 	for _, rep := range s.replicators {
-		replicators = append(replicators, ReplicatorInfo{
-			ID:    rep.ID,
-			State: rep.State.Current(),
-			Stats: rep.Stats(),
-		})
+		replicators = append(replicators, s.info(rep))
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -130,6 +196,10 @@ func (s *Server) getReplicator(w http.ResponseWriter, r *http.Request) {
 
 	s.mu.RLock()
 	rep, exists := s.replicators[id]
+	var info ReplicatorInfo
+	if exists {
+		info = s.info(rep)
+	}
 	s.mu.RUnlock()
 
 	if !exists {
@@ -137,14 +207,22 @@ func (s *Server) getReplicator(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+// info describes a registered replicator. The caller must hold s.mu.
+func (s *Server) info(rep *Replicator) ReplicatorInfo {
 	info := ReplicatorInfo{
 		ID:    rep.ID,
 		State: rep.State.Current(),
 		Stats: rep.Stats(),
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(info)
+	if m, ok := s.managed[rep.ID]; ok && m.replicator == rep {
+		spec := m.spec.Redacted()
+		info.Spec = &spec
+	}
+	return info
 }
 
 func (s *Server) Start(ctx context.Context, addr string) error {
@@ -152,8 +230,19 @@ func (s *Server) Start(ctx context.Context, addr string) error {
 		Addr:    addr,
 		Handler: s.Routes(),
 	}
+	if s.tls != nil {
+		config, err := s.tls.tlsConfig()
+		if err != nil {
+			return err
+		}
+		srv.TLSConfig = config
+	}
 
-	s.logger.Info("starting replicator server", zap.String("addr", addr))
+	s.logger.Info("starting replicator server",
+		zap.String("addr", addr),
+		zap.Bool("tls", s.tls != nil),
+		zap.Bool("mtls", s.tls != nil && s.tls.ClientCAFile != ""),
+		zap.Int("credentials", len(s.credentials)))
 
 	go func() {
 		<-ctx.Done()
@@ -161,5 +250,8 @@ func (s *Server) Start(ctx context.Context, addr string) error {
 		srv.Shutdown(context.Background())
 	}()
 
+	if s.tls != nil {
+		return srv.ListenAndServeTLS("", "")
+	}
 	return srv.ListenAndServe()
 }
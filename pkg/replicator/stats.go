@@ -25,6 +25,11 @@ type TargetStats struct {
 	LastWriteAt       time.Time `json:"last_write_at"`
 	WriteErrorCount   int       `json:"write_error_count"`
 
+	// Error policy counters, maintained by the replicator
+	RetryCount       int64 `json:"retry_count"`
+	SkippedEvents    int64 `json:"skipped_events"`
+	DeadLetterEvents int64 `json:"dead_letter_events"`
+
 	// Target-specific metrics
 	TargetSpecific map[string]interface{} `json:"target_specific,omitempty"`
 }
@@ -36,6 +41,60 @@ type ReplicatorStats struct {
 	CheckpointCount  int64     `json:"checkpoint_count"`
 	LastCheckpointAt time.Time `json:"last_checkpoint_at"`
 	SignalsReceived  int64     `json:"signals_received"`
+	QueueDepth       int       `json:"queue_depth"`
+	QueueCapacity    int       `json:"queue_capacity"`
+	InFlightEvents   int       `json:"in_flight_events"`
+
+	// PendingCheckpointEvents counts delivered events not yet covered by a
+	// checkpoint
+	PendingCheckpointEvents int `json:"pending_checkpoint_events"`
+
+	// DroppedEvents counts events dropped by transforms
+	DroppedEvents int64 `json:"dropped_events"`
+
+	// FilteredEvents counts events which did not match the filters
+	FilteredEvents int64 `json:"filtered_events"`
+
+	// Lag is measured on the most recently delivered event. TableLag breaks
+	// it down by table, keyed as in the metrics: "schema.table", or
+	// "db.table" for sources without schemas.
+	Lag      LagStats            `json:"lag"`
+	TableLag map[string]LagStats `json:"table_lag,omitempty"`
+
+	// ReconnectAttempts counts reconnects after source or target failures
+	ReconnectAttempts int64  `json:"reconnect_attempts"`
+	LastError         string `json:"last_error,omitempty"`
+
+	// LeaseEpoch is the epoch of the lease held by the replicator, 0 when
+	// it holds none
+	LeaseEpoch uint64 `json:"lease_epoch,omitempty"`
+
+	// CheckpointPosition is the rendered position of the last checkpoint
+	CheckpointPosition string `json:"checkpoint_position,omitempty"`
+
+	// PositionRegressions counts checkpoints saved behind the previous one
+	PositionRegressions int64 `json:"position_regressions,omitempty"`
+}
+
+// LagStats measures how far behind the source database the replicator is,
+// in milliseconds. The commit lags are only reported for sources which carry
+// the commit time of their events.
+type LagStats struct {
+	// CommitToReadMs is the time from the commit of a change to the
+	// replicator reading it from the source
+	CommitToReadMs int64 `json:"commit_to_read_ms"`
+
+	// ReadToAckMs is the time from reading an event to the target
+	// acknowledging its delivery
+	ReadToAckMs int64 `json:"read_to_ack_ms"`
+
+	// CommitToAckMs is the end to end lag, from the commit of a change to its
+	// delivery
+	CommitToAckMs int64 `json:"commit_to_ack_ms"`
+
+	// LastCommitAt is the commit time of the most recently delivered event
+	LastCommitAt time.Time `json:"last_commit_at"`
+	LastAckAt    time.Time `json:"last_ack_at"`
 }
 
 type Stats struct {
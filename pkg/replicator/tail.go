@@ -0,0 +1,211 @@
+package replicator
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+// tailBufferSize is the number of events buffered for each tail subscriber.
+// Events are dropped for subscribers which fall further behind.
+const tailBufferSize = 64
+
+// tailKeepAlive is the interval of the comments sent to idle tail streams so
+// proxies do not close them.
+const tailKeepAlive = 15 * time.Second
+
+// WithTailRedaction masks fields in the events streamed by the tail
+// endpoint, on top of the fields each request asks to redact. It does not
+// change the events written to the target.
+func WithTailRedaction(fields ...string) ReplicatorOption {
+	return func(r *Replicator) {
+		r.TailRedactedFields = append(r.TailRedactedFields, fields...)
+	}
+}
+
+// tail samples the transformed events for the subscribers of the tail
+// endpoint. Publishing is a single atomic load while nobody is subscribed,
+// and never blocks the pipeline: subscribers that fall behind miss events.
+type tail struct {
+	active atomic.Int32
+
+	mu   sync.Mutex
+	subs map[*tailSubscriber]struct{}
+}
+
+type tailSubscriber struct {
+	table Pattern
+	ops   []Operation
+
+	events  chan Event
+	dropped atomic.Int64
+}
+
+func newTail() *tail {
+	return &tail{subs: make(map[*tailSubscriber]struct{})}
+}
+
+func (t *tail) subscribe(table Pattern, ops []Operation) *tailSubscriber {
+	sub := &tailSubscriber{
+		table:  table,
+		ops:    ops,
+		events: make(chan Event, tailBufferSize),
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.subs[sub] = struct{}{}
+	t.active.Add(1)
+	return sub
+}
+
+func (t *tail) unsubscribe(sub *tailSubscriber) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.subs[sub]; ok {
+		delete(t.subs, sub)
+		t.active.Add(-1)
+	}
+}
+
+func (t *tail) publish(event Event) {
+	if t.active.Load() == 0 {
+		return
+	}
+
+	// subscribers get a copy of the images, so redacting them does not
+	// change what is written to the target
+	event.Payload.Before = maps.Clone(event.Payload.Before)
+	event.Payload.After = maps.Clone(event.Payload.After)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for sub := range t.subs {
+		if !sub.match(event) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
+
+func (s *tailSubscriber) match(event Event) bool {
+	if !s.table.Match(event.Payload.Source.Table) {
+		return false
+	}
+	return len(s.ops) == 0 || slices.Contains(s.ops, event.Payload.Op)
+}
+
+// tailEvents streams the events of a replicator, after transforms, as
+// server-sent events:
+//
+//	GET /api/v1/replicators/{id}/events/tail?table=orders&op=c|u&limit=10&redact=email,ssn
+//
+// table is a table pattern as in routes, op a "|" separated list of
+// operations and redact a comma separated list of fields to mask. The stream
+// ends after limit events, or when the client disconnects. Events the client
+// is too slow to receive are dropped and reported in "dropped" events.
+func (s *Server) tailEvents(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	s.mu.RLock()
+	rep, exists := s.replicators[id]
+	s.mu.RUnlock()
+
+	if !exists {
+		http.Error(w, "replicator not found", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	table, err := ParsePattern(query.Get("table"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid table: %s", err), http.StatusBadRequest)
+		return
+	}
+	var ops []Operation
+	if v := query.Get("op"); v != "" {
+		for _, op := range strings.Split(v, "|") {
+			switch Operation(op) {
+			case OpCreate, OpUpdate, OpDelete, OpRead:
+				ops = append(ops, Operation(op))
+			default:
+				http.Error(w, fmt.Sprintf("unknown operation: %q", op), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	limit := 0
+	if v := query.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	redacted := slices.Clone(rep.TailRedactedFields)
+	if v := query.Get("redact"); v != "" {
+		redacted = append(redacted, strings.Split(v, ",")...)
+	}
+	redact := MaskFields("****", redacted...)
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	sub := rep.tail.subscribe(table, ops)
+	defer rep.tail.unsubscribe(sub)
+
+	s.logger.Info("tail started", zap.String("replicator_id", id))
+	defer s.logger.Info("tail stopped", zap.String("replicator_id", id))
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(tailKeepAlive)
+	defer keepAlive.Stop()
+
+	var dropped int64
+	for sent := 0; limit == 0 || sent < limit; {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+
+		case event := <-sub.events:
+			if n := sub.dropped.Load(); n > dropped {
+				fmt.Fprintf(w, "event: dropped\ndata: {\"dropped\":%d}\n\n", n-dropped)
+				dropped = n
+			}
+
+			event, _ = redact.Apply(event)
+			data, err := json.Marshal(event)
+			if err != nil {
+				s.logger.Error("failed to encode tailed event", zap.Error(err))
+				continue
+			}
+			fmt.Fprintf(w, "id: %d\nevent: change\ndata: %s\n\n", event.Sequence, data)
+			sent++
+		}
+		flusher.Flush()
+	}
+}
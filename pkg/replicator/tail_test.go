@@ -0,0 +1,118 @@
+package replicator
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestTail(t *testing.T) {
+	tl := newTail()
+	tl.publish(testEvent(0))
+
+	users, err := ParsePattern("users")
+	require.NoError(t, err)
+	sub := tl.subscribe(users, []Operation{OpCreate})
+
+	other := testEvent(1)
+	other.Payload.Source.Table = "orders"
+	tl.publish(other)
+	update := testEvent(2)
+	update.Payload.Op = OpUpdate
+	tl.publish(update)
+
+	for i := 0; i < tailBufferSize+5; i++ {
+		tl.publish(testEvent(i))
+	}
+	assert.Len(t, sub.events, tailBufferSize)
+	assert.Equal(t, int64(5), sub.dropped.Load())
+
+	tl.unsubscribe(sub)
+	assert.Zero(t, tl.active.Load())
+}
+
+func TestServerTailEvents(t *testing.T) {
+	source := newMemorySource()
+	target := &memoryTarget{}
+	r, err := New(
+		WithID("orders"),
+		WithSource(source),
+		WithTarget(target),
+		WithTailRedaction("email"),
+	)
+	require.NoError(t, err)
+
+	s := NewServer(zap.NewNop())
+	s.RegisterReplicator(r)
+	srv := httptest.NewServer(s.Routes())
+	defer srv.Close()
+
+	cancel, done := runReplicator(t, r)
+	defer func() {
+		cancel()
+		require.NoError(t, <-done)
+	}()
+
+	resp, err := http.Get(srv.URL + "/api/v1/replicators/orders/events/tail?table=users&limit=2&redact=ssn")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	require.Eventually(t, func() bool {
+		return r.tail.active.Load() == 1
+	}, time.Second, time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		event := testEvent(i)
+		event.Payload.After["email"] = "jane@example.com"
+		event.Payload.After["ssn"] = "123-45-6789"
+		source.events <- event
+	}
+
+	var tailed []map[string]interface{}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+			var event struct {
+				Payload struct {
+					After map[string]interface{} `json:"after"`
+				} `json:"payload"`
+			}
+			require.NoError(t, json.Unmarshal([]byte(data), &event))
+			tailed = append(tailed, event.Payload.After)
+		}
+	}
+	require.NoError(t, scanner.Err())
+
+	// the stream ends after limit events
+	require.Len(t, tailed, 2)
+	assert.Equal(t, "****", tailed[0]["email"])
+	assert.Equal(t, "****", tailed[0]["ssn"])
+	assert.Equal(t, float64(1), tailed[1]["id"])
+
+	// the target receives the events unredacted
+	require.Eventually(t, func() bool {
+		return len(target.written()) == 3
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, "jane@example.com", target.written()[0].Payload.After["email"])
+}
+
+func TestServerTailEventsNotFound(t *testing.T) {
+	s := NewServer(zap.NewNop())
+	srv := httptest.NewServer(s.Routes())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/api/v1/replicators/missing/events/tail")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
@@ -0,0 +1,50 @@
+package replicator
+
+import (
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/turbolytics/librarian/pkg/replicator"
+
+// Span names. Every event starts a trace at source.next; its transform and
+// target.write spans are children of it. target.flush and checkpointer.save
+// cover many events and start traces of their own.
+const (
+	spanSourceNext       = "source.next"
+	spanTransform        = "transform"
+	spanTargetWrite      = "target.write"
+	spanTargetFlush      = "target.flush"
+	spanCheckpointerSave = "checkpointer.save"
+)
+
+// WithTracerProvider sets the provider of the tracer the replicator records
+// spans with. The global provider is used by default, which does not record
+// anything unless one is installed with otel.SetTracerProvider.
+func WithTracerProvider(provider trace.TracerProvider) ReplicatorOption {
+	return func(r *Replicator) {
+		r.tracerProvider = provider
+	}
+}
+
+func (r *Replicator) eventAttributes(event Event) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("librarian.replicator_id", r.ID),
+		attribute.String("librarian.db", event.Payload.Source.Db),
+		attribute.String("librarian.schema", event.Payload.Source.Schema),
+		attribute.String("librarian.table", event.Payload.Source.Table),
+		attribute.String("librarian.op", string(event.Payload.Op)),
+		attribute.String("librarian.position", r.renderPosition(event.Position)),
+		attribute.Int64("librarian.sequence", int64(event.Sequence)),
+	}
+}
+
+// endSpan records err, if any, and ends the span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,75 @@
+package replicator
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// spanTarget records the span context each event is written in.
+type spanTarget struct {
+	memoryTarget
+
+	spanMu sync.Mutex
+	spans  []trace.SpanContext
+}
+
+func (t *spanTarget) Write(ctx context.Context, event Event) error {
+	t.spanMu.Lock()
+	t.spans = append(t.spans, trace.SpanContextFromContext(ctx))
+	t.spanMu.Unlock()
+	return t.memoryTarget.Write(ctx, event)
+}
+
+func TestReplicatorTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	source := newMemorySource()
+	target := &spanTarget{}
+	r, err := New(
+		WithID("orders"),
+		WithSource(source),
+		WithTarget(target),
+		WithCheckpointer(&memoryCheckpointer{}),
+		WithSourceOptions(SourceOptions{CheckpointBatchSize: 1}),
+		WithTransforms(InsertFields(map[string]interface{}{"env": "test"})),
+		WithTracerProvider(provider),
+	)
+	require.NoError(t, err)
+
+	cancel, done := runReplicator(t, r)
+	source.events <- testEvent(7)
+	require.Eventually(t, func() bool {
+		return len(target.written()) == 1
+	}, time.Second, time.Millisecond)
+	cancel()
+	require.NoError(t, <-done)
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, s := range recorder.Ended() {
+		spans[s.Name()] = s
+	}
+	require.Contains(t, spans, spanSourceNext)
+	require.Contains(t, spans, spanTransform)
+	require.Contains(t, spans, spanTargetWrite)
+	require.Contains(t, spans, spanCheckpointerSave)
+
+	read := spans[spanSourceNext]
+	assert.Equal(t, read.SpanContext().SpanID(), spans[spanTransform].Parent().SpanID())
+	assert.Equal(t, read.SpanContext().SpanID(), spans[spanTargetWrite].Parent().SpanID())
+	assert.Contains(t, read.Attributes(), attribute.String("librarian.table", "users"))
+	assert.Contains(t, read.Attributes(), attribute.String("librarian.position", "7"))
+
+	// the target writes in the write span, so it can propagate the trace
+	require.Len(t, target.spans, 1)
+	assert.Equal(t, spans[spanTargetWrite].SpanContext().SpanID(), target.spans[0].SpanID())
+}
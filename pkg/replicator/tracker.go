@@ -0,0 +1,187 @@
+package replicator
+
+import "sync"
+
+// ackTracker records the position of every event handed to the target and
+// the order in which they were written. Targets may acknowledge events out of
+// order; the tracker only releases the position of the highest contiguous
+// acknowledged event, so a checkpoint never moves past an event that has not
+// been delivered.
+type ackTracker struct {
+	mu      sync.Mutex
+	pending []trackedEvent
+
+	// ready is the position of the highest contiguous acknowledged event
+	// which has not been handed out by committable yet.
+	ready      []byte
+	readyCount int
+	hasReady   bool
+
+	// delivered counts every acknowledged event
+	delivered int
+
+	// notify receives a value whenever ready advances
+	notify chan struct{}
+
+	// failures holds events whose delivery failed, in the order the target
+	// reported them. failed receives a value whenever one is added.
+	failures []failedEvent
+	failed   chan struct{}
+}
+
+type trackedEvent struct {
+	seq      uint64
+	event    Event
+	acked    bool
+	attempts int
+}
+
+// failedEvent is an event the target failed to deliver.
+type failedEvent struct {
+	event    Event
+	err      error
+	attempts int
+}
+
+func newAckTracker() *ackTracker {
+	return &ackTracker{
+		notify: make(chan struct{}, 1),
+		failed: make(chan struct{}, 1),
+	}
+}
+
+// track registers an event which is about to be written. The event is kept
+// until it is acknowledged so a failed delivery can be retried. Sequences must
+// be tracked in increasing order without gaps.
+func (t *ackTracker) track(seq uint64, event Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.pending = append(t.pending, trackedEvent{
+		seq:   seq,
+		event: event,
+	})
+}
+
+// lookup returns the index of seq in pending, or -1.
+func (t *ackTracker) lookup(seq uint64) int {
+	if len(t.pending) == 0 || seq < t.pending[0].seq {
+		return -1
+	}
+	i := int(seq - t.pending[0].seq)
+	if i >= len(t.pending) {
+		return -1
+	}
+	return i
+}
+
+// nack records a failed delivery of the event with the given sequence. The
+// event stays pending, holding back the checkpoint, until it is retried and
+// acknowledged or given up on.
+func (t *ackTracker) nack(seq uint64, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	i := t.lookup(seq)
+	if i < 0 || t.pending[i].acked {
+		return
+	}
+	t.pending[i].attempts++
+	t.failures = append(t.failures, failedEvent{
+		event:    t.pending[i].event,
+		err:      err,
+		attempts: t.pending[i].attempts,
+	})
+
+	select {
+	case t.failed <- struct{}{}:
+	default:
+	}
+}
+
+// takeFailures returns and clears the failed deliveries.
+func (t *ackTracker) takeFailures() []failedEvent {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	failures := t.failures
+	t.failures = nil
+	return failures
+}
+
+// ack marks the event with the given sequence as delivered and returns it.
+// Acks for sequences the tracker does not know about, e.g. acks for events
+// written before a restart, are ignored and ok is false.
+func (t *ackTracker) ack(seq uint64) (event Event, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	i := t.lookup(seq)
+	if i < 0 || t.pending[i].acked {
+		return Event{}, false
+	}
+	t.pending[i].acked = true
+	t.delivered++
+	event = t.pending[i].event
+
+	n := 0
+	for n < len(t.pending) && t.pending[n].acked {
+		n++
+	}
+	if n == 0 {
+		return event, true
+	}
+
+	last := t.pending[n-1]
+	t.ready = last.event.Position
+	t.readyCount += n
+	t.hasReady = true
+	// release the acknowledged events
+	clear(t.pending[:n])
+	t.pending = t.pending[n:]
+
+	select {
+	case t.notify <- struct{}{}:
+	default:
+	}
+	return event, true
+}
+
+// committable returns the position of the highest contiguous acknowledged
+// event and the number of events it covers since the previous call. ok is
+// false when nothing new has been acknowledged.
+func (t *ackTracker) committable() (position []byte, count int, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.hasReady {
+		return nil, 0, false
+	}
+
+	position, count = t.ready, t.readyCount
+	t.ready, t.readyCount, t.hasReady = nil, 0, false
+	return position, count, true
+}
+
+// acked returns the number of contiguous acknowledged events which have not
+// been handed out by committable yet.
+func (t *ackTracker) acked() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.readyCount
+}
+
+// deliveredCount returns the number of events acknowledged since the tracker
+// was created.
+func (t *ackTracker) deliveredCount() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.delivered
+}
+
+// inFlight returns the number of events written but not yet acknowledged.
+func (t *ackTracker) inFlight() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.pending)
+}
@@ -0,0 +1,73 @@
+package replicator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAckTracker(t *testing.T) {
+	t.Run("only releases contiguous acknowledgements", func(t *testing.T) {
+		tr := newAckTracker()
+		for seq := uint64(1); seq <= 4; seq++ {
+			tr.track(seq, Event{Position: []byte{byte(seq)}})
+		}
+
+		tr.ack(2)
+		tr.ack(3)
+		_, _, ok := tr.committable()
+		assert.False(t, ok)
+		assert.Equal(t, 4, tr.inFlight())
+
+		tr.ack(1)
+		position, count, ok := tr.committable()
+		assert.True(t, ok)
+		assert.Equal(t, []byte{3}, position)
+		assert.Equal(t, 3, count)
+		assert.Equal(t, 1, tr.inFlight())
+
+		_, _, ok = tr.committable()
+		assert.False(t, ok)
+	})
+
+	t.Run("ignores unknown sequences", func(t *testing.T) {
+		tr := newAckTracker()
+		tr.track(10, Event{Position: []byte("a")})
+
+		tr.ack(9)
+		tr.ack(11)
+		_, _, ok := tr.committable()
+		assert.False(t, ok)
+
+		tr.ack(10)
+		position, _, ok := tr.committable()
+		assert.True(t, ok)
+		assert.Equal(t, []byte("a"), position)
+	})
+}
+
+func TestAckTrackerFailures(t *testing.T) {
+	tr := newAckTracker()
+	tr.track(1, Event{Position: []byte("a")})
+	tr.track(2, Event{Position: []byte("b")})
+
+	tr.nack(1, assert.AnError)
+	tr.ack(2)
+	_, _, ok := tr.committable()
+	assert.False(t, ok)
+
+	failures := tr.takeFailures()
+	assert.Len(t, failures, 1)
+	assert.Equal(t, []byte("a"), failures[0].event.Position)
+	assert.Equal(t, 1, failures[0].attempts)
+	assert.Empty(t, tr.takeFailures())
+
+	tr.nack(1, assert.AnError)
+	assert.Equal(t, 2, tr.takeFailures()[0].attempts)
+
+	tr.ack(1)
+	position, count, ok := tr.committable()
+	assert.True(t, ok)
+	assert.Equal(t, []byte("b"), position)
+	assert.Equal(t, 2, count)
+}
@@ -0,0 +1,269 @@
+package replicator
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	// ErrDropEvent is returned by a Transform to drop the event. Dropped
+	// events are not written to the target but their position is still
+	// checkpointed.
+	ErrDropEvent = errors.New("drop event")
+)
+
+// Transform is a single message transform applied to every event between the
+// source and the target, similar to Kafka Connect SMTs. Transforms may modify
+// the event in place; the event is owned by the pipeline.
+type Transform interface {
+	Apply(event Event) (Event, error)
+}
+
+// TransformFunc adapts a function to the Transform interface.
+type TransformFunc func(event Event) (Event, error)
+
+func (f TransformFunc) Apply(event Event) (Event, error) {
+	return f(event)
+}
+
+// Chain applies transforms in order. The chain stops at the first error,
+// including ErrDropEvent.
+type Chain []Transform
+
+func (c Chain) Apply(event Event) (Event, error) {
+	var err error
+	for _, t := range c {
+		if event, err = t.Apply(event); err != nil {
+			return event, err
+		}
+	}
+	return event, nil
+}
+
+// images returns the row images present on the event.
+func images(event Event) []map[string]interface{} {
+	var m []map[string]interface{}
+	if event.Payload.Before != nil {
+		m = append(m, event.Payload.Before)
+	}
+	if event.Payload.After != nil {
+		m = append(m, event.Payload.After)
+	}
+	return m
+}
+
+// DropFields removes fields from the before and after images.
+func DropFields(fields ...string) Transform {
+	return TransformFunc(func(event Event) (Event, error) {
+		for _, image := range images(event) {
+			for _, f := range fields {
+				delete(image, f)
+			}
+		}
+		return event, nil
+	})
+}
+
+// RenameFields renames fields in the before and after images, mapping the old
+// name to the new name.
+func RenameFields(renames map[string]string) Transform {
+	return TransformFunc(func(event Event) (Event, error) {
+		for _, image := range images(event) {
+			for from, to := range renames {
+				if v, ok := image[from]; ok {
+					delete(image, from)
+					image[to] = v
+				}
+			}
+		}
+		return event, nil
+	})
+}
+
+// MaskFields replaces the value of non-null fields in the before and after
+// images with replacement.
+func MaskFields(replacement string, fields ...string) Transform {
+	return TransformFunc(func(event Event) (Event, error) {
+		for _, image := range images(event) {
+			for _, f := range fields {
+				if v, ok := image[f]; ok && v != nil {
+					image[f] = replacement
+				}
+			}
+		}
+		return event, nil
+	})
+}
+
+// InsertFields adds static fields to the before and after images, overwriting
+// existing values.
+func InsertFields(fields map[string]interface{}) Transform {
+	return TransformFunc(func(event Event) (Event, error) {
+		for _, image := range images(event) {
+			for k, v := range fields {
+				image[k] = v
+			}
+		}
+		return event, nil
+	})
+}
+
+// AddHeaders adds static headers to the event. Targets that support message
+// headers, such as Kafka, write them alongside the event.
+func AddHeaders(headers map[string]string) Transform {
+	return TransformFunc(func(event Event) (Event, error) {
+		if event.Headers == nil {
+			event.Headers = make(map[string]string, len(headers))
+		}
+		for k, v := range headers {
+			event.Headers[k] = v
+		}
+		return event, nil
+	})
+}
+
+// RenameTable rewrites the source table name when it matches pattern.
+// replacement may reference capture groups, e.g. "archive_$1".
+func RenameTable(pattern *regexp.Regexp, replacement string) Transform {
+	return TransformFunc(func(event Event) (Event, error) {
+		table := event.Payload.Source.Table
+		if pattern.MatchString(table) {
+			event.Payload.Source.Table = pattern.ReplaceAllString(table, replacement)
+		}
+		return event, nil
+	})
+}
+
+// RouteTopic sets the event topic when "db.schema.table" matches pattern.
+// replacement may reference capture groups, e.g. "cdc.$1". Targets that write
+// to topics use it instead of their configured topic.
+func RouteTopic(pattern *regexp.Regexp, replacement string) Transform {
+	return TransformFunc(func(event Event) (Event, error) {
+		name := fmt.Sprintf("%s.%s.%s",
+			event.Payload.Source.Db,
+			event.Payload.Source.Schema,
+			event.Payload.Source.Table)
+		if pattern.MatchString(name) {
+			event.Topic = pattern.ReplaceAllString(name, replacement)
+		}
+		return event, nil
+	})
+}
+
+// Unwrap replaces the Debezium envelope with the after image when the event
+// is serialized, like Debezium's ExtractNewRecordState. Deletes serialize to
+// null.
+func Unwrap() Transform {
+	return TransformFunc(func(event Event) (Event, error) {
+		event.Unwrapped = true
+		return event, nil
+	})
+}
+
+// ParseTransform builds a built-in transform from a "name:args" spec, as used
+// by the --transform flag:
+//
+//	drop:password,token
+//	rename:old=new,email=contact
+//	mask:ssn,card_number
+//	insert:region=us-east-1
+//	header:source=librarian
+//	table:^users_(.*)$=accounts_$1
+//	topic:^test\.public\.(.*)$=cdc.$1
+//	unwrap
+func ParseTransform(spec string) (Transform, error) {
+	name, args, _ := strings.Cut(spec, ":")
+
+	switch name {
+	case "drop":
+		fields, err := parseList(args)
+		if err != nil {
+			return nil, fmt.Errorf("transform %q: %w", spec, err)
+		}
+		return DropFields(fields...), nil
+
+	case "rename":
+		pairs, err := parsePairs(args)
+		if err != nil {
+			return nil, fmt.Errorf("transform %q: %w", spec, err)
+		}
+		return RenameFields(pairs), nil
+
+	case "mask":
+		fields, err := parseList(args)
+		if err != nil {
+			return nil, fmt.Errorf("transform %q: %w", spec, err)
+		}
+		return MaskFields("****", fields...), nil
+
+	case "insert":
+		pairs, err := parsePairs(args)
+		if err != nil {
+			return nil, fmt.Errorf("transform %q: %w", spec, err)
+		}
+		fields := make(map[string]interface{}, len(pairs))
+		for k, v := range pairs {
+			fields[k] = v
+		}
+		return InsertFields(fields), nil
+
+	case "header":
+		pairs, err := parsePairs(args)
+		if err != nil {
+			return nil, fmt.Errorf("transform %q: %w", spec, err)
+		}
+		return AddHeaders(pairs), nil
+
+	case "table", "topic":
+		i := strings.LastIndex(args, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("transform %q: expected pattern=replacement", spec)
+		}
+		pattern, err := regexp.Compile(args[:i])
+		if err != nil {
+			return nil, fmt.Errorf("transform %q: %w", spec, err)
+		}
+		if name == "table" {
+			return RenameTable(pattern, args[i+1:]), nil
+		}
+		return RouteTopic(pattern, args[i+1:]), nil
+
+	case "unwrap":
+		return Unwrap(), nil
+
+	default:
+		return nil, fmt.Errorf("unknown transform: %q", name)
+	}
+}
+
+func parseList(args string) ([]string, error) {
+	var items []string
+	for _, item := range strings.Split(args, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	if len(items) == 0 {
+		return nil, errors.New("expected a comma separated list of fields")
+	}
+	return items, nil
+}
+
+func parsePairs(args string) (map[string]string, error) {
+	items, err := parseList(args)
+	if err != nil {
+		return nil, errors.New("expected comma separated key=value pairs")
+	}
+
+	pairs := make(map[string]string, len(items))
+	for _, item := range items {
+		k, v, ok := strings.Cut(item, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("expected key=value, got %q", item)
+		}
+		pairs[k] = v
+	}
+	return pairs, nil
+}
@@ -0,0 +1,121 @@
+package replicator
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func userEvent() Event {
+	return Event{
+		Payload: Payload{
+			Before: map[string]interface{}{"id": 1, "email": "old@example.com", "password": "a"},
+			After:  map[string]interface{}{"id": 1, "email": "new@example.com", "password": "b"},
+			Source: EventSource{Db: "test", Schema: "public", Table: "users"},
+			Op:     OpUpdate,
+		},
+	}
+}
+
+func TestTransforms(t *testing.T) {
+	testCases := []struct {
+		name   string
+		spec   string
+		assert func(t *testing.T, e Event)
+	}{
+		{
+			name: "drop",
+			spec: "drop:password",
+			assert: func(t *testing.T, e Event) {
+				assert.NotContains(t, e.Payload.Before, "password")
+				assert.NotContains(t, e.Payload.After, "password")
+			},
+		},
+		{
+			name: "rename",
+			spec: "rename:email=contact",
+			assert: func(t *testing.T, e Event) {
+				assert.Equal(t, "new@example.com", e.Payload.After["contact"])
+				assert.NotContains(t, e.Payload.After, "email")
+			},
+		},
+		{
+			name: "mask",
+			spec: "mask:email,missing",
+			assert: func(t *testing.T, e Event) {
+				assert.Equal(t, "****", e.Payload.Before["email"])
+				assert.Equal(t, "****", e.Payload.After["email"])
+				assert.NotContains(t, e.Payload.After, "missing")
+			},
+		},
+		{
+			name: "insert",
+			spec: "insert:region=us-east-1",
+			assert: func(t *testing.T, e Event) {
+				assert.Equal(t, "us-east-1", e.Payload.After["region"])
+			},
+		},
+		{
+			name: "header",
+			spec: "header:source=librarian",
+			assert: func(t *testing.T, e Event) {
+				assert.Equal(t, map[string]string{"source": "librarian"}, e.Headers)
+			},
+		},
+		{
+			name: "table",
+			spec: "table:^(.*)$=archive_$1",
+			assert: func(t *testing.T, e Event) {
+				assert.Equal(t, "archive_users", e.Payload.Source.Table)
+			},
+		},
+		{
+			name: "topic",
+			spec: `topic:^test\.public\.(.*)$=cdc.$1`,
+			assert: func(t *testing.T, e Event) {
+				assert.Equal(t, "cdc.users", e.Topic)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			transform, err := ParseTransform(tc.spec)
+			require.NoError(t, err)
+
+			e, err := transform.Apply(userEvent())
+			require.NoError(t, err)
+			tc.assert(t, e)
+		})
+	}
+}
+
+func TestParseTransformErrors(t *testing.T) {
+	for _, spec := range []string{"explode", "drop", "rename:email", "table:(=x", "topic:nopattern"} {
+		_, err := ParseTransform(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestChain(t *testing.T) {
+	drop := TransformFunc(func(e Event) (Event, error) {
+		if e.Payload.Op == OpDelete {
+			return e, ErrDropEvent
+		}
+		return e, nil
+	})
+	chain := Chain{DropFields("password"), drop, Unwrap()}
+
+	e, err := chain.Apply(userEvent())
+	require.NoError(t, err)
+	data, err := json.Marshal(e)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"id": 1, "email": "new@example.com"}`, string(data))
+
+	deleted := userEvent()
+	deleted.Payload.Op = OpDelete
+	_, err = chain.Apply(deleted)
+	assert.ErrorIs(t, err, ErrDropEvent)
+}